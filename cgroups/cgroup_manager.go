@@ -1,49 +1,88 @@
 package cgroups
 
 import (
+	"path"
+
 	"mydocker/cgroups/subsystems"
+	"mydocker/utils"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultCgroupParent 所有容器的 cgroup 都创建在该目录下，便于统一管理
+const DefaultCgroupParent = "mydocker"
+
 type CgroupManager struct {
 	// cgroup 在 hierarchy 中的路径，相当于创建的 cgroup 目录相对于 root cgroup 目录的路径
 	Path string
 	// 资源配置
 	Resource *subsystems.ResourceConfig
+	// 当前主机 cgroup 版本对应的 Subsystem 实例
+	subsystemsIns []subsystems.Subsystem
 }
 
+// NewCgroupManager 创建 cgroup manager，根据主机挂载的 cgroup 版本自动选择 v1 或 v2 的实现
 func NewCgroupManager(path string) *CgroupManager {
+	return NewCgroupManagerWithHierarchy(path, subsystems.DefaultHierarchy)
+}
+
+// NewCgroupManagerWithHierarchy 在指定的 cgroup 层级中创建 cgroup manager，根据层级中挂载的 cgroup 版本选择 v1 或 v2 的实现
+// 测试时可以传入基于临时目录伪造的 cgroup 层级
+func NewCgroupManagerWithHierarchy(path string, hierarchy *subsystems.Hierarchy) *CgroupManager {
+	subsystemsIns := subsystems.NewSubsystems(hierarchy)
+	if hierarchy.IsCgroup2UnifiedMode() {
+		subsystemsIns = subsystems.NewSubsystemsV2(hierarchy)
+	}
 	return &CgroupManager{
-		Path: path,
+		Path:          path,
+		subsystemsIns: subsystemsIns,
+	}
+}
+
+// ContainerCgroupPath 返回容器对应的 cgroup 路径，每个容器都以自己的容器 ID 命名一个独立的 cgroup
+// e.g. mydocker/1234567890
+// rootless 模式下创建在当前用户被委派的 cgroup 中，没有可用的 cgroup 时返回空字符串
+func ContainerCgroupPath(containerId string) string {
+	if utils.IsRootless() {
+		return rootlessCgroupPath(containerId)
 	}
+	return path.Join(DefaultCgroupParent, containerId)
 }
 
-// Set 设置 cgroup 资源限制
+// Set 设置 cgroup 资源限制，某个 subsystem 设置失败时不影响其他 subsystem，最后返回第一个错误
 func (c *CgroupManager) Set(res *subsystems.ResourceConfig) error {
-	for _, subSysIns := range subsystems.SubsystemsIns {
+	var firstErr error
+	for _, subSysIns := range c.subsystemsIns {
 		err := subSysIns.Set(c.Path, res)
 		if err != nil {
 			log.Errorf("set subsystem:%s err:%s", subSysIns.Name(), err)
+			if firstErr == nil {
+				firstErr = errors.WithMessagef(err, "set subsystem %s", subSysIns.Name())
+			}
 		}
 	}
-	return nil
+	return firstErr
 }
 
-// Apply 将进程 PID 加入到 cgroup 中
+// Apply 将进程 PID 加入到 cgroup 中，某个 subsystem 加入失败时不影响其他 subsystem，最后返回第一个错误
 func (c *CgroupManager) Apply(pid int, res *subsystems.ResourceConfig) error {
-	for _, subSysIns := range subsystems.SubsystemsIns {
+	var firstErr error
+	for _, subSysIns := range c.subsystemsIns {
 		err := subSysIns.Apply(c.Path, pid, res)
 		if err != nil {
 			log.Errorf("apply subsystem:%s err:%s", subSysIns.Name(), err)
+			if firstErr == nil {
+				firstErr = errors.WithMessagef(err, "apply subsystem %s", subSysIns.Name())
+			}
 		}
 	}
-	return nil
+	return firstErr
 }
 
 // Destroy 释放 cgroup
 func (c *CgroupManager) Destroy() error {
-	for _, subSysIns := range subsystems.SubsystemsIns {
+	for _, subSysIns := range c.subsystemsIns {
 		err := subSysIns.Remove(c.Path)
 		if err != nil {
 			log.Errorf("remove subsystem:%s err:%s", subSysIns.Name(), err)
@@ -51,3 +90,42 @@ func (c *CgroupManager) Destroy() error {
 	}
 	return nil
 }
+
+// GetStats 读取 cgroup 中各项资源的使用情况
+func (c *CgroupManager) GetStats() (*subsystems.Stats, error) {
+	stats := &subsystems.Stats{}
+	for _, subSysIns := range c.subsystemsIns {
+		getter, ok := subSysIns.(subsystems.StatsGetter)
+		if !ok {
+			continue
+		}
+		if err := getter.GetStats(c.Path, stats); err != nil {
+			return nil, errors.WithMessagef(err, "get stats of subsystem %s", subSysIns.Name())
+		}
+	}
+	return stats, nil
+}
+
+// Freeze 冻结（frozen 为 true）或解冻（frozen 为 false）cgroup 中的所有进程，用于 pause/unpause 容器
+func (c *CgroupManager) Freeze(frozen bool) error {
+	for _, subSysIns := range c.subsystemsIns {
+		freezer, ok := subSysIns.(subsystems.Freezer)
+		if !ok {
+			continue
+		}
+		return freezer.Freeze(c.Path, frozen)
+	}
+	return errors.New("freezer subsystem is not supported")
+}
+
+// NotifyOOM 监听 cgroup 的 OOM 事件，具体见 subsystems.OOMNotifier
+func (c *CgroupManager) NotifyOOM() (<-chan struct{}, error) {
+	for _, subSysIns := range c.subsystemsIns {
+		notifier, ok := subSysIns.(subsystems.OOMNotifier)
+		if !ok {
+			continue
+		}
+		return notifier.NotifyOOM(c.Path)
+	}
+	return nil, errors.New("oom notification is not supported")
+}
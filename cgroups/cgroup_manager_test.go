@@ -0,0 +1,101 @@
+package cgroups
+
+import (
+	"testing"
+
+	"mydocker/cgroups/cgrouptest"
+	"mydocker/cgroups/subsystems"
+)
+
+func TestCgroupManagerV1(t *testing.T) {
+	fake := cgrouptest.NewV1(t)
+	cgroupPath := ContainerCgroupPath("test")
+	manager := NewCgroupManagerWithHierarchy(cgroupPath, fake.Hierarchy)
+	res := &subsystems.ResourceConfig{MemoryLimit: "100m", NanoCpus: 500000000, PidsLimit: 10}
+	if err := manager.Set(res); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply(1234, res); err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{
+		"memory.limit_in_bytes": "100m",
+		"cgroup.procs":          "1234",
+	} {
+		if got := fake.ReadFile("memory", cgroupPath, file); got != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+	if got := fake.ReadFile("cpu", cgroupPath, "cpu.cfs_quota_us"); got != "50000" {
+		t.Errorf("cpu.cfs_quota_us: got %q, want %q", got, "50000")
+	}
+	if got := fake.ReadFile("pids", cgroupPath, "pids.max"); got != "10" {
+		t.Errorf("pids.max: got %q, want %q", got, "10")
+	}
+
+	if err := manager.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	for _, subsystem := range []string{"cpuset", "memory", "cpu", "pids", "blkio", "freezer", "devices"} {
+		if fake.Exists(subsystem, cgroupPath, "") {
+			t.Errorf("cgroup of %s should be removed", subsystem)
+		}
+	}
+}
+
+func TestCgroupManagerV2(t *testing.T) {
+	fake := cgrouptest.NewV2(t)
+	cgroupPath := ContainerCgroupPath("test")
+	manager := NewCgroupManagerWithHierarchy(cgroupPath, fake.Hierarchy)
+	res := &subsystems.ResourceConfig{MemoryLimit: "100m", NanoCpus: 500000000, PidsLimit: 10}
+	if err := manager.Set(res); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply(1234, res); err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{
+		"memory.max":   "104857600",
+		"cpu.max":      "50000 100000",
+		"pids.max":     "10",
+		"cgroup.procs": "1234",
+	} {
+		if got := fake.ReadFile("", cgroupPath, file); got != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestCgroupManagerGetStats(t *testing.T) {
+	fake := cgrouptest.NewV2(t)
+	cgroupPath := ContainerCgroupPath("test")
+	for file, content := range map[string]string{
+		"cpu.stat":       "usage_usec 2000\nuser_usec 1500\nsystem_usec 500\n",
+		"memory.current": "1048576",
+		"memory.max":     "max",
+		"memory.events":  "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+		"pids.current":   "3",
+		"pids.max":       "10",
+		"io.stat":        "8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0\n",
+	} {
+		fake.WriteFile("", cgroupPath, file, content)
+	}
+
+	stats, err := NewCgroupManagerWithHierarchy(cgroupPath, fake.Hierarchy).GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := subsystems.Stats{
+		CpuUsage:    2000000,
+		MemoryUsage: 1048576,
+		MemoryLimit: ^uint64(0),
+		PidsCurrent: 3,
+		PidsLimit:   10,
+		BlkioRead:   4096,
+		BlkioWrite:  8192,
+		OOMKill:     1,
+	}
+	if *stats != want {
+		t.Errorf("got %+v, want %+v", *stats, want)
+	}
+}
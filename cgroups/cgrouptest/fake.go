@@ -0,0 +1,137 @@
+// Package cgrouptest 提供基于临时目录伪造的 cgroup 层级，用于在没有 root 权限的情况下测试 cgroup 相关的代码
+package cgrouptest
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"mydocker/cgroups/subsystems"
+	"mydocker/constant"
+)
+
+const cgroupMountRoot = "/sys/fs/cgroup"
+
+// v1Mounts 伪造的 cgroup v1 挂载点，cpu 和 cpuacct 与大多数发行版一样挂载在同一个 hierarchy 上
+var v1Mounts = []struct {
+	dir        string
+	subsystems []string
+}{
+	{"cpuset", []string{"cpuset"}},
+	{"cpu,cpuacct", []string{"cpu", "cpuacct"}},
+	{"memory", []string{"memory"}},
+	{"pids", []string{"pids"}},
+	{"blkio", []string{"blkio"}},
+	{"freezer", []string{"freezer"}},
+	{"devices", []string{"devices"}},
+}
+
+/*
+ * FakeHierarchy 基于临时目录伪造的 cgroup 层级
+ * 临时目录中包含一份伪造的 mountinfo，以及 mountinfo 中各个挂载点对应的目录，
+ * 与真实的 cgroup 文件系统不同，这里的文件都是普通文件，每次写入都会覆盖之前的内容，也不会自动生成统计文件
+ */
+type FakeHierarchy struct {
+	*subsystems.Hierarchy
+	t       testing.TB
+	unified bool
+}
+
+// NewV1 创建一个 cgroup v1 的层级，根 cgroup 的 cpuset.cpus 为 0-3，cpuset.mems 为 0
+func NewV1(t testing.TB) *FakeHierarchy {
+	f := newFakeHierarchy(t, false)
+	var mountInfo strings.Builder
+	for i, mount := range v1Mounts {
+		mountpoint := path.Join(cgroupMountRoot, mount.dir)
+		fmt.Fprintf(&mountInfo, "%d 25 0:%d / %s rw,nosuid,nodev,noexec,relatime shared:%d - cgroup cgroup rw,%s\n",
+			30+i, 26+i, mountpoint, 10+i, strings.Join(mount.subsystems, ","))
+		f.mkdir(mountpoint)
+	}
+	f.writeMountInfo(mountInfo.String())
+	f.WriteFile("cpuset", "", "cpuset.cpus", "0-3")
+	f.WriteFile("cpuset", "", "cpuset.mems", "0")
+	return f
+}
+
+// NewV2 创建一个只挂载了 cgroup v2 的层级
+func NewV2(t testing.TB) *FakeHierarchy {
+	f := newFakeHierarchy(t, true)
+	f.writeMountInfo(fmt.Sprintf("35 24 0:30 / %s rw,nosuid,nodev,noexec,relatime shared:9 - cgroup2 cgroup2 rw,nsdelegate\n",
+		cgroupMountRoot))
+	f.mkdir(cgroupMountRoot)
+	f.WriteFile("", "", "cgroup.subtree_control", "")
+	return f
+}
+
+func newFakeHierarchy(t testing.TB, unified bool) *FakeHierarchy {
+	t.Helper()
+	root := t.TempDir()
+	return &FakeHierarchy{
+		Hierarchy: &subsystems.Hierarchy{
+			Root:          root,
+			MountInfoPath: path.Join(root, "mountinfo"),
+		},
+		t:       t,
+		unified: unified,
+	}
+}
+
+// Path 返回 cgroup 中某个文件在临时目录中的绝对路径，v2 中所有 controller 共用一个目录，subsystem 会被忽略
+func (f *FakeHierarchy) Path(subsystem, cgroupPath, file string) string {
+	f.t.Helper()
+	if f.unified {
+		return path.Join(f.Root, cgroupMountRoot, cgroupPath, file)
+	}
+	for _, mount := range v1Mounts {
+		for _, name := range mount.subsystems {
+			if name == subsystem {
+				return path.Join(f.Root, cgroupMountRoot, mount.dir, cgroupPath, file)
+			}
+		}
+	}
+	f.t.Fatalf("subsystem %s is not mounted", subsystem)
+	return ""
+}
+
+// ReadFile 读取 cgroup 中某个文件的内容
+func (f *FakeHierarchy) ReadFile(subsystem, cgroupPath, file string) string {
+	f.t.Helper()
+	content, err := os.ReadFile(f.Path(subsystem, cgroupPath, file))
+	if err != nil {
+		f.t.Fatalf("read %s: %v", file, err)
+	}
+	return string(content)
+}
+
+// WriteFile 写入 cgroup 中某个文件，用于预置内核生成的文件，比如 memory.usage_in_bytes
+func (f *FakeHierarchy) WriteFile(subsystem, cgroupPath, file, content string) {
+	f.t.Helper()
+	filePath := f.Path(subsystem, cgroupPath, file)
+	f.mkdir(strings.TrimPrefix(path.Dir(filePath), f.Root))
+	if err := os.WriteFile(filePath, []byte(content), constant.Perm0644); err != nil {
+		f.t.Fatalf("write %s: %v", file, err)
+	}
+}
+
+// Exists 判断 cgroup 中某个文件或者 cgroup 目录是否存在
+func (f *FakeHierarchy) Exists(subsystem, cgroupPath, file string) bool {
+	f.t.Helper()
+	_, err := os.Stat(f.Path(subsystem, cgroupPath, file))
+	return err == nil
+}
+
+func (f *FakeHierarchy) mkdir(dir string) {
+	f.t.Helper()
+	if err := os.MkdirAll(path.Join(f.Root, dir), constant.Perm0755); err != nil {
+		f.t.Fatalf("mkdir %s: %v", dir, err)
+	}
+}
+
+func (f *FakeHierarchy) writeMountInfo(content string) {
+	f.t.Helper()
+	if err := os.WriteFile(f.MountInfoPath, []byte(content), constant.Perm0644); err != nil {
+		f.t.Fatalf("write mountinfo: %v", err)
+	}
+}
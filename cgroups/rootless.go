@@ -0,0 +1,58 @@
+package cgroups
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"mydocker/cgroups/subsystems"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const procSelfCgroup = "/proc/self/cgroup"
+
+/*
+ * rootlessCgroupPath rootless 模式下容器的 cgroup 路径
+ * 普通用户只能在 systemd 委派给自己的 cgroup v2 子树中创建 cgroup，即 user@{uid}.service 下面
+ * e.g. /user.slice/user-1000.slice/user@1000.service/mydocker/1234567890
+ * cgroup v1 或者没有委派时返回空字符串，容器不设置 cgroup，资源限制不生效
+ */
+func rootlessCgroupPath(containerId string) string {
+	if !subsystems.DefaultHierarchy.IsCgroup2UnifiedMode() {
+		log.Warnf("cgroup v2 is required to limit resources in rootless mode")
+		return ""
+	}
+	content, err := os.ReadFile(procSelfCgroup)
+	if err != nil {
+		log.Warnf("Read %s error %v", procSelfCgroup, err)
+		return ""
+	}
+	parent := delegatedCgroupParent(string(content), os.Geteuid())
+	if parent == "" || unix.Access(path.Join(subsystems.DefaultHierarchy.Cgroup2Mountpoint(), parent), unix.W_OK) != nil {
+		log.Warnf("No delegated cgroup for user %d, resource limits are disabled", os.Geteuid())
+		return ""
+	}
+	return path.Join(parent, DefaultCgroupParent, containerId)
+}
+
+// delegatedCgroupParent 从 /proc/self/cgroup 的内容中找出当前用户被委派的 cgroup，e.g. 0::/user.slice/user-1000.slice/user@1000.service/app.slice
+func delegatedCgroupParent(procCgroup string, uid int) string {
+	service := fmt.Sprintf("user@%d.service", uid)
+	for _, line := range strings.Split(procCgroup, "\n") {
+		// cgroup v2 的行以 0:: 开头
+		cgroupPath, ok := strings.CutPrefix(line, "0::")
+		if !ok {
+			continue
+		}
+		dirs := strings.Split(cgroupPath, "/")
+		for i, dir := range dirs {
+			if dir == service {
+				return strings.Join(dirs[:i+1], "/")
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,21 @@
+package cgroups
+
+import "testing"
+
+func TestDelegatedCgroupParent(t *testing.T) {
+	for _, tc := range []struct {
+		procCgroup string
+		want       string
+	}{
+		{"0::/user.slice/user-1000.slice/user@1000.service/app.slice/run-u1.scope\n", "/user.slice/user-1000.slice/user@1000.service"},
+		{"12:memory:/user.slice\n0::/user.slice/user-1000.slice/user@1000.service/init.scope\n", "/user.slice/user-1000.slice/user@1000.service"},
+		// 其他用户的 service 不能使用
+		{"0::/user.slice/user-1001.slice/user@1001.service/init.scope\n", ""},
+		{"0::/user.slice/user-1000.slice/session-1.scope\n", ""},
+		{"12:memory:/user.slice/user-1000.slice/user@1000.service\n", ""},
+	} {
+		if got := delegatedCgroupParent(tc.procCgroup, 1000); got != tc.want {
+			t.Errorf("delegatedCgroupParent(%q) = %q, want %q", tc.procCgroup, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+type BlkioSubSystem struct {
+	cgroupBase
+}
+
+// Name 返回 cgroup 名字
+func (s *BlkioSubSystem) Name() string {
+	return "blkio"
+}
+
+// Set 设置 cgroupPath 对应的 cgroup 的块设备 IO 限制
+func (s *BlkioSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if !res.hasBlkio() {
+		return nil
+	}
+
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+
+	// blkio.weight 是 IO 调度的权重，只有在使用 CFQ/BFQ 调度器时才会生效
+	if res.BlkioWeight != 0 {
+		err = os.WriteFile(path.Join(subsysCgroupPath, "blkio.weight"),
+			[]byte(strconv.Itoa(int(res.BlkioWeight))),
+			constant.Perm0644)
+		if err != nil {
+			return fmt.Errorf("set cgroup blkio weight fail %v", err)
+		}
+	}
+
+	// blkio.throttle.* 是按设备的限速，每次写入只能设置一个设备，格式为 major:minor rate
+	throttles := []struct {
+		file    string
+		devices []*ThrottleDevice
+	}{
+		{"blkio.throttle.read_bps_device", res.BlkioDeviceReadBps},
+		{"blkio.throttle.write_bps_device", res.BlkioDeviceWriteBps},
+		{"blkio.throttle.read_iops_device", res.BlkioDeviceReadIOps},
+		{"blkio.throttle.write_iops_device", res.BlkioDeviceWriteIOps},
+	}
+	for _, throttle := range throttles {
+		for _, device := range throttle.devices {
+			err = os.WriteFile(path.Join(subsysCgroupPath, throttle.file),
+				[]byte(device.String()),
+				constant.Perm0644)
+			if err != nil {
+				return fmt.Errorf("set cgroup %s fail %v", throttle.file, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply 将 pid 加入到 cgroupPath 对应的 cgroup 中
+func (s *BlkioSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return errors.Wrapf(err, "get cgroup %s", cgroupPath)
+	}
+
+	err = os.WriteFile(path.Join(subsysCgroupPath, cgroupProcs),
+		[]byte(strconv.Itoa(pid)),
+		constant.Perm0644)
+	if err != nil {
+		return fmt.Errorf("set cgroup proc fail %v", err)
+	}
+	return nil
+}
+
+// Remove 删除 cgroupPath 对应的 cgroup
+func (s *BlkioSubSystem) Remove(cgroupPath string) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(subsysCgroupPath)
+}
+
+// GetStats 读取 cgroupPath 对应的 cgroup 的块设备 IO 使用情况
+func (s *BlkioSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	// 文件内容大概是这样的，每个设备按操作类型分别统计，最后一行是总计：
+	// 8:0 Read 4096
+	// 8:0 Write 8192
+	// ...
+	// Total 12288
+	content, err := os.ReadFile(path.Join(subsysCgroupPath, "blkio.throttle.io_service_bytes"))
+	if err != nil {
+		return errors.Wrap(err, "read blkio stats")
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			stats.BlkioRead += value
+		case "Write":
+			stats.BlkioWrite += value
+		}
+	}
+	return nil
+}
@@ -1,15 +1,19 @@
 package subsystems
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"strconv"
 
 	"mydocker/constant"
+
+	"golang.org/x/sys/unix"
 )
 
 type CpuSubSystem struct {
+	cgroupBase
 }
 
 const (
@@ -24,11 +28,11 @@ func (s *CpuSubSystem) Name() string {
 
 // Set 设置 cgroupPath 对应的 cgroup 的 CPU 限制
 func (s *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
-	if res.CpuCfsQuota == 0 && res.CpuShare == "" {
+	if !res.hasCpu() {
 		return nil
 	}
 
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, true)
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
 	if err != nil {
 		return err
 	}
@@ -43,45 +47,53 @@ func (s *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 		}
 	}
 
-	if res.CpuCfsQuota != 0 {
-		// cpu.cfs_period_us 控制的是 CPU 分配周期的时间，单位是微秒
-		// 这个值通常设置为 100000，即 100ms，这意味着内核会每 100 毫秒重新调整一次 CPU 时间的分配
+	// cpu.cfs_period_us 控制的是 CPU 分配周期的时间，单位是微秒，默认为 100000，即 100ms
+	// cpu.cfs_quota_us 定义了在一个周期内，一组进程可以运行的 CPU 时间，可以大于周期时间，表示可以同时使用多个 CPU
+	// 比如 --cpus 1.5 对应 quota 150000、period 100000
+	quota, period := res.CpuQuotaAndPeriod()
+	if period != 0 {
 		err = os.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_period_us"),
-			[]byte(strconv.Itoa(PeriodDefault)),
+			[]byte(strconv.FormatUint(period, 10)),
 			constant.Perm0644)
-		if err != nil {
-			return fmt.Errorf("set cgroup cpu.cfs_period_us fail %v", err)
-
+		// 内核要求 quota 与 period 的比值不能超过父 cgroup，先写 period 可能会因为与旧的 quota 不匹配而失败，
+		// 这种情况下先写 quota 再写 period
+		if err != nil && errors.Is(err, unix.EINVAL) && quota != 0 {
+			if err = writeCpuQuota(subsysCgroupPath, quota); err != nil {
+				return err
+			}
+			err = os.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_period_us"),
+				[]byte(strconv.FormatUint(period, 10)),
+				constant.Perm0644)
 		}
-
-		// cpu.cfs_quota_us 定义了在 cpu.cfs_period_us 定义的周期内，一组进程可以运行的 CPU 时间
-		// 如果这个值小于周期时间，它将限制进程组的 CPU 使用率
-		// 此处的 cpu.cfs_quota_us 根据用户传递的参数 res.CpuCfsQuota 来控制，比如参数为 20，就是希望限制为 20% CPU
-		// 所以把 cpu.cfs_quota_us 设置为 cpu.cfs_period_us 的 20% 就行
-		// 这里只是简单的计算了下，并没有处理一些特殊情况，比如负数什么的
-		err = os.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_quota_us"),
-			[]byte(strconv.Itoa(PeriodDefault/Percent*res.CpuCfsQuota)),
-			constant.Perm0644)
 		if err != nil {
-			return fmt.Errorf("set cgroup cpu.cfs_quota_us fail %v", err)
+			return fmt.Errorf("set cgroup cpu.cfs_period_us fail %v", err)
 		}
 	}
+	if quota != 0 {
+		return writeCpuQuota(subsysCgroupPath, quota)
+	}
+	return nil
+}
 
+// writeCpuQuota 写入 cpu.cfs_quota_us，-1 表示不限制
+func writeCpuQuota(subsysCgroupPath string, quota int64) error {
+	err := os.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_quota_us"),
+		[]byte(strconv.FormatInt(quota, 10)),
+		constant.Perm0644)
+	if err != nil {
+		return fmt.Errorf("set cgroup cpu.cfs_quota_us fail %v", err)
+	}
 	return nil
 }
 
 // Apply 将 pid 加入到 cgroupPath 对应的 cgroup 中
 func (s *CpuSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
-	if res.CpuCfsQuota == 0 && res.CpuShare == "" {
-		return nil
-	}
-
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
 	if err != nil {
 		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
 	}
 
-	err = os.WriteFile(path.Join(subsysCgroupPath, "tasks"),
+	err = os.WriteFile(path.Join(subsysCgroupPath, cgroupProcs),
 		[]byte(strconv.Itoa(pid)),
 		constant.Perm0644)
 	if err != nil {
@@ -92,7 +104,7 @@ func (s *CpuSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) er
 
 // Remove 删除 cgroupPath 对应的 cgroup
 func (s *CpuSubSystem) Remove(cgroupPath string) error {
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
 	if err != nil {
 		return err
 	}
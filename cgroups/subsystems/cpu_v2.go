@@ -0,0 +1,118 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+// CpuSubSystemV2 cgroup v2 的 cpu controller
+type CpuSubSystemV2 struct {
+	cgroupBase
+}
+
+// Name 返回 controller 名字
+func (s *CpuSubSystemV2) Name() string {
+	return "cpu"
+}
+
+// Set 设置 cgroupPath 对应的 cgroup 的 CPU 限制
+func (s *CpuSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if !res.hasCpu() {
+		return nil
+	}
+
+	subsysCgroupPath, err := s.getCgroupPathV2(cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err = s.enableController(cgroupPath, s.Name()); err != nil {
+		return err
+	}
+
+	// v2 中使用 cpu.weight 替代了 v1 的 cpu.shares，取值范围从 [2, 262144] 变成了 [1, 10000]，需要换算一下
+	if res.CpuShare != "" {
+		shares, err := strconv.ParseUint(res.CpuShare, 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "invalid cpu share %s", res.CpuShare)
+		}
+		err = os.WriteFile(path.Join(subsysCgroupPath, "cpu.weight"),
+			[]byte(strconv.FormatUint(convertCPUSharesToWeight(shares), 10)),
+			constant.Perm0644)
+		if err != nil {
+			return errors.Wrap(err, "set cgroup cpu weight fail")
+		}
+	}
+
+	// v2 中将 v1 的 cpu.cfs_quota_us 和 cpu.cfs_period_us 合并成了 cpu.max，格式为 "$QUOTA $PERIOD"，不限制时 quota 为 max
+	quota, period := res.CpuQuotaAndPeriod()
+	if quota != 0 || period != 0 {
+		quotaStr := strconv.FormatInt(quota, 10)
+		switch {
+		case quota < 0:
+			quotaStr = "max"
+		case quota == 0:
+			// 只修改 period 时保留原有的 quota，与 v1 中只写 cpu.cfs_period_us 的效果一致
+			quotaStr = "max"
+			if content, err := os.ReadFile(path.Join(subsysCgroupPath, "cpu.max")); err == nil {
+				if fields := strings.Fields(string(content)); len(fields) > 0 {
+					quotaStr = fields[0]
+				}
+			}
+		}
+		if period == 0 {
+			period = PeriodDefault
+		}
+		err = os.WriteFile(path.Join(subsysCgroupPath, "cpu.max"),
+			[]byte(fmt.Sprintf("%s %d", quotaStr, period)),
+			constant.Perm0644)
+		if err != nil {
+			return errors.Wrap(err, "set cgroup cpu.max fail")
+		}
+	}
+	return nil
+}
+
+// Apply 将 pid 加入到 cgroupPath 对应的 cgroup 中
+func (s *CpuSubSystemV2) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	return s.applyV2(cgroupPath, s.Name(), pid)
+}
+
+// Remove 删除 cgroupPath 对应的 cgroup
+func (s *CpuSubSystemV2) Remove(cgroupPath string) error {
+	return s.removeV2(cgroupPath)
+}
+
+// convertCPUSharesToWeight 将 v1 的 cpu.shares 换算成 v2 的 cpu.weight
+// 换算公式与 runc 保持一致：shares 的 [2, 262144] 线性映射到 weight 的 [1, 10000]
+func convertCPUSharesToWeight(shares uint64) uint64 {
+	if shares == 0 {
+		return 0
+	}
+	if shares < 2 {
+		shares = 2
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// GetStats 读取 cgroupPath 对应的 cgroup 的 CPU 使用时间
+// v2 中没有单独的 cpuacct，而是由 cpu.stat 中的 usage_usec 提供，单位为微秒
+func (s *CpuSubSystemV2) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := s.getCgroupPathV2(cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	usage, err := readKeyValue(path.Join(subsysCgroupPath, "cpu.stat"), "usage_usec")
+	if err != nil {
+		return errors.Wrap(err, "read cpu stats")
+	}
+	stats.CpuUsage = usage * uint64(time.Microsecond)
+	return nil
+}
@@ -0,0 +1,63 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+// CpuacctSubSystem 只用于统计 CPU 使用时间，没有资源限制
+// 很多发行版会把 cpu 和 cpuacct 挂载在同一个 hierarchy 上，但也可能是分开挂载的，因此需要单独加入
+type CpuacctSubSystem struct {
+	cgroupBase
+}
+
+// Name 返回 cgroup 名字
+func (s *CpuacctSubSystem) Name() string {
+	return "cpuacct"
+}
+
+// Set cpuacct 没有资源限制，不需要设置
+func (s *CpuacctSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	return nil
+}
+
+// Apply 将 pid 加入到 cgroupPath 对应的 cgroup 中
+func (s *CpuacctSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return errors.Wrapf(err, "get cgroup %s", cgroupPath)
+	}
+
+	err = os.WriteFile(path.Join(subsysCgroupPath, cgroupProcs),
+		[]byte(strconv.Itoa(pid)),
+		constant.Perm0644)
+	if err != nil {
+		return fmt.Errorf("set cgroup proc fail %v", err)
+	}
+	return nil
+}
+
+// Remove 删除 cgroupPath 对应的 cgroup
+func (s *CpuacctSubSystem) Remove(cgroupPath string) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(subsysCgroupPath)
+}
+
+// GetStats 读取 cgroupPath 对应的 cgroup 的 CPU 使用时间，cpuacct.usage 的单位为纳秒
+func (s *CpuacctSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	stats.CpuUsage, err = readUint(path.Join(subsysCgroupPath, "cpuacct.usage"))
+	return errors.Wrap(err, "read cpu usage")
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"mydocker/constant"
 
@@ -12,6 +14,7 @@ import (
 )
 
 type CpusetSubSystem struct {
+	cgroupBase
 }
 
 // Name 返回 cgroup 名字
@@ -25,10 +28,14 @@ func (s *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 		return nil
 	}
 
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, true)
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
 	if err != nil {
 		return err
 	}
+	// 新建的 cpuset cgroup 中 cpuset.cpus、cpuset.mems 都是空的，需要先从父 cgroup 继承，否则无法加入进程
+	if err = initCpuset(s.hierarchyOrDefault().findCgroupMountpoint(s.Name()), subsysCgroupPath); err != nil {
+		return errors.WithMessage(err, "init cpuset")
+	}
 
 	err = os.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"),
 		[]byte(res.CpuSet),
@@ -42,16 +49,15 @@ func (s *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 
 // Apply 将进程 PID 添加到 cgroupPath 对应的 cgroup 中
 func (s *CpusetSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
-	if res.CpuSet == "" {
-		return nil
-	}
-
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
 	if err != nil {
 		return errors.Wrapf(err, "get cgroup %s", cgroupPath)
 	}
+	if err = initCpuset(s.hierarchyOrDefault().findCgroupMountpoint(s.Name()), subsysCgroupPath); err != nil {
+		return errors.WithMessage(err, "init cpuset")
+	}
 
-	err = os.WriteFile(path.Join(subsysCgroupPath, "tasks"),
+	err = os.WriteFile(path.Join(subsysCgroupPath, cgroupProcs),
 		[]byte(strconv.Itoa(pid)),
 		constant.Perm0644)
 	if err != nil {
@@ -63,10 +69,46 @@ func (s *CpusetSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig)
 
 // Remove 移除 cgroupPath 对应的 cgroup
 func (s *CpusetSubSystem) Remove(cgroupPath string) error {
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
 	if err != nil {
 		return errors.Wrapf(err, "get cgroup %s", cgroupPath)
 	}
 
 	return os.RemoveAll(subsysCgroupPath)
 }
+
+// initCpuset 从 cgroupRoot 开始逐级向下，将父 cgroup 的 cpuset.cpus、cpuset.mems 复制到为空的子 cgroup 中
+// 由于容器 cgroup 是多级目录（mydocker/{containerId}），中间目录同样需要初始化
+func initCpuset(cgroupRoot, absPath string) error {
+	rel, err := filepath.Rel(cgroupRoot, absPath)
+	if err != nil {
+		return err
+	}
+	parent := cgroupRoot
+	for _, dir := range strings.Split(rel, string(filepath.Separator)) {
+		current := path.Join(parent, dir)
+		for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+			if err = copyIfEmpty(path.Join(parent, file), path.Join(current, file)); err != nil {
+				return err
+			}
+		}
+		parent = current
+	}
+	return nil
+}
+
+// copyIfEmpty 如果 dst 文件内容为空（或者不存在），则将 src 文件的内容写入 dst
+func copyIfEmpty(src, dst string) error {
+	dstContent, err := os.ReadFile(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if strings.TrimSpace(string(dstContent)) != "" {
+		return nil
+	}
+	srcContent, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, srcContent, constant.Perm0644)
+}
@@ -0,0 +1,51 @@
+package subsystems
+
+import (
+	"os"
+	"path"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+// CpusetSubSystemV2 cgroup v2 的 cpuset controller
+type CpusetSubSystemV2 struct {
+	cgroupBase
+}
+
+// Name 返回 controller 名字
+func (s *CpusetSubSystemV2) Name() string {
+	return "cpuset"
+}
+
+// Set 设置 cgroupPath 对应的 cgroup 的 CPU 核心限制
+// v2 中 cpuset.cpus、cpuset.mems 为空时会直接使用父 cgroup 的配置，因此不需要像 v1 一样手动初始化
+func (s *CpusetSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if res.CpuSet == "" {
+		return nil
+	}
+
+	subsysCgroupPath, err := s.getCgroupPathV2(cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err = s.enableController(cgroupPath, s.Name()); err != nil {
+		return err
+	}
+
+	err = os.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"),
+		[]byte(res.CpuSet),
+		constant.Perm0644)
+	return errors.Wrap(err, "set cgroup cpuset fail")
+}
+
+// Apply 将进程 PID 添加到 cgroupPath 对应的 cgroup 中
+func (s *CpusetSubSystemV2) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	return s.applyV2(cgroupPath, s.Name(), pid)
+}
+
+// Remove 移除 cgroupPath 对应的 cgroup
+func (s *CpusetSubSystemV2) Remove(cgroupPath string) error {
+	return s.removeV2(cgroupPath)
+}
@@ -0,0 +1,104 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DeviceTypeAll   = "a"
+	DeviceTypeChar  = "c"
+	DeviceTypeBlock = "b"
+	// DeviceWildcard 表示匹配所有的 major 或 minor
+	DeviceWildcard = -1
+)
+
+// DeviceRule 设备访问规则，描述是否允许容器以某种权限访问某类设备
+type DeviceRule struct {
+	Type        string `json:"type"`        // 设备类型，c 字符设备、b 块设备、a 所有设备
+	Major       int64  `json:"major"`       // 主设备号，-1 表示任意
+	Minor       int64  `json:"minor"`       // 次设备号，-1 表示任意
+	Permissions string `json:"permissions"` // r 读、w 写、m 创建设备文件（mknod）的组合
+	Allow       bool   `json:"allow"`       // true 表示允许，false 表示禁止
+}
+
+// String 返回 cgroup v1 中 devices.allow、devices.deny 使用的格式，e.g. c 1:3 rwm、c 136:* rwm、a
+func (r *DeviceRule) String() string {
+	if r.Type == DeviceTypeAll {
+		return DeviceTypeAll
+	}
+	return fmt.Sprintf("%s %s:%s %s", r.Type, deviceNumber(r.Major), deviceNumber(r.Minor), r.Permissions)
+}
+
+func deviceNumber(number int64) string {
+	if number == DeviceWildcard {
+		return "*"
+	}
+	return strconv.FormatInt(number, 10)
+}
+
+type DevicesSubSystem struct {
+	cgroupBase
+}
+
+// Name 返回 cgroup 名字
+func (s *DevicesSubSystem) Name() string {
+	return "devices"
+}
+
+/*
+ * Set 设置 cgroupPath 对应的 cgroup 的设备访问规则
+ * 规则按顺序写入，后写入的规则会覆盖前面的规则，因此一般第一条规则是禁止访问所有设备（a），再逐条放开允许访问的设备
+ */
+func (s *DevicesSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if len(res.Devices) == 0 {
+		return nil
+	}
+
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range res.Devices {
+		file := "devices.deny"
+		if rule.Allow {
+			file = "devices.allow"
+		}
+		if err = os.WriteFile(path.Join(subsysCgroupPath, file), []byte(rule.String()), constant.Perm0644); err != nil {
+			return errors.Wrapf(err, "write %s to %s", rule, file)
+		}
+	}
+	return nil
+}
+
+// Apply 将 pid 加入到 cgroupPath 对应的 cgroup 中
+func (s *DevicesSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return errors.Wrapf(err, "get cgroup %s", cgroupPath)
+	}
+
+	err = os.WriteFile(path.Join(subsysCgroupPath, cgroupProcs),
+		[]byte(strconv.Itoa(pid)),
+		constant.Perm0644)
+	if err != nil {
+		return fmt.Errorf("set cgroup proc fail %v", err)
+	}
+	return nil
+}
+
+// Remove 删除 cgroupPath 对应的 cgroup
+func (s *DevicesSubSystem) Remove(cgroupPath string) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(subsysCgroupPath)
+}
@@ -0,0 +1,229 @@
+package subsystems
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// eBPF 指令中用到的操作码，参考 include/uapi/linux/bpf.h 和 bpf_common.h
+const (
+	bpfClassLdx   = 0x01
+	bpfClassAlu   = 0x04
+	bpfClassJmp   = 0x05
+	bpfSizeW      = 0x00
+	bpfModeMem    = 0x60
+	bpfOpAnd      = 0x50
+	bpfOpRsh      = 0x70
+	bpfOpMov      = 0xb0
+	bpfOpJne      = 0x50
+	bpfOpExit     = 0x90
+	bpfSrcK       = 0x00
+	bpfSrcX       = 0x08
+	bpfInsnLength = 8
+)
+
+// bpf_cgroup_dev_ctx 中的设备类型和访问类型
+const (
+	bpfDevcgDevBlock = 1
+	bpfDevcgDevChar  = 2
+	bpfDevcgAccMknod = 1
+	bpfDevcgAccRead  = 2
+	bpfDevcgAccWrite = 4
+)
+
+// bpfInsn 对应内核中的 struct bpf_insn
+type bpfInsn struct {
+	code uint8
+	dst  uint8
+	src  uint8
+	off  int16
+	imm  int32
+}
+
+func loadCtx(dst uint8, off int16) bpfInsn {
+	return bpfInsn{code: bpfClassLdx | bpfModeMem | bpfSizeW, dst: dst, src: 1, off: off}
+}
+
+func aluImm(op, dst uint8, imm int32) bpfInsn {
+	return bpfInsn{code: bpfClassAlu | op | bpfSrcK, dst: dst, imm: imm}
+}
+
+func movReg(dst, src uint8) bpfInsn {
+	return bpfInsn{code: bpfClassAlu | bpfOpMov | bpfSrcX, dst: dst, src: src}
+}
+
+func jneImm(dst uint8, imm int32) bpfInsn {
+	return bpfInsn{code: bpfClassJmp | bpfOpJne | bpfSrcK, dst: dst, imm: imm}
+}
+
+func jneReg(dst, src uint8) bpfInsn {
+	return bpfInsn{code: bpfClassJmp | bpfOpJne | bpfSrcX, dst: dst, src: src}
+}
+
+func exitInsn() bpfInsn {
+	return bpfInsn{code: bpfClassJmp | bpfOpExit}
+}
+
+/*
+ * deviceFilterProgram 根据设备访问规则生成 eBPF 程序，与 cgroup v1 一致，后面的规则优先级更高
+ * 程序的入参 r1 指向 struct bpf_cgroup_dev_ctx { u32 access_type; u32 major; u32 minor; }
+ * 其中 access_type 的低 16 位为设备类型，高 16 位为访问类型
+ * 1. 先将设备类型、访问类型、major、minor 分别加载到 r2、r3、r4、r5
+ * 2. 倒序为每条规则生成一段指令，不匹配时跳转到下一段，匹配时返回该规则是否允许访问
+ * 3. 所有规则都不匹配时拒绝访问
+ */
+func deviceFilterProgram(rules []*DeviceRule) ([]bpfInsn, error) {
+	insns := []bpfInsn{
+		loadCtx(2, 0),
+		aluImm(bpfOpAnd, 2, 0xFFFF),
+		loadCtx(3, 0),
+		aluImm(bpfOpRsh, 3, 16),
+		loadCtx(4, 4),
+		loadCtx(5, 8),
+	}
+	for i := len(rules) - 1; i >= 0; i-- {
+		block, err := deviceRuleBlock(rules[i])
+		if err != nil {
+			return nil, err
+		}
+		insns = append(insns, block...)
+		// 没有任何条件的规则（比如 a）一定会匹配，后面的指令都不可达，内核校验器会拒绝包含不可达指令的程序
+		if len(block) == 2 {
+			return insns, nil
+		}
+	}
+	return append(insns, aluImm(bpfOpMov, 0, 0), exitInsn()), nil
+}
+
+// deviceRuleBlock 为一条规则生成指令，块中的条件跳转都跳转到块的末尾，也就是下一条规则的开始
+func deviceRuleBlock(rule *DeviceRule) ([]bpfInsn, error) {
+	var block []bpfInsn
+	switch rule.Type {
+	case DeviceTypeAll:
+	case DeviceTypeChar:
+		block = append(block, jneImm(2, bpfDevcgDevChar))
+	case DeviceTypeBlock:
+		block = append(block, jneImm(2, bpfDevcgDevBlock))
+	default:
+		return nil, fmt.Errorf("invalid device type %q", rule.Type)
+	}
+
+	var access int32
+	for _, perm := range rule.Permissions {
+		switch perm {
+		case 'r':
+			access |= bpfDevcgAccRead
+		case 'w':
+			access |= bpfDevcgAccWrite
+		case 'm':
+			access |= bpfDevcgAccMknod
+		default:
+			return nil, fmt.Errorf("invalid device permissions %q", rule.Permissions)
+		}
+	}
+	// 类型为 a 时与 v1 一致，忽略权限，匹配所有访问
+	if rule.Type != DeviceTypeAll && access != bpfDevcgAccRead|bpfDevcgAccWrite|bpfDevcgAccMknod {
+		// 请求的访问类型必须是规则中权限的子集：(access_type & access) == access_type
+		block = append(block, movReg(1, 3), aluImm(bpfOpAnd, 1, access), jneReg(1, 3))
+	}
+	if rule.Type != DeviceTypeAll && rule.Major != DeviceWildcard {
+		block = append(block, jneImm(4, int32(rule.Major)))
+	}
+	if rule.Type != DeviceTypeAll && rule.Minor != DeviceWildcard {
+		block = append(block, jneImm(5, int32(rule.Minor)))
+	}
+
+	allow := int32(0)
+	if rule.Allow {
+		allow = 1
+	}
+	block = append(block, aluImm(bpfOpMov, 0, allow), exitInsn())
+	for i := range block {
+		if block[i].code&0x07 == bpfClassJmp && block[i].code != exitInsn().code {
+			block[i].off = int16(len(block) - i - 1)
+		}
+	}
+	return block, nil
+}
+
+// encodeInsns 将指令编码成内核需要的二进制格式，dst 和 src 寄存器共用一个字节
+func encodeInsns(insns []bpfInsn) []byte {
+	buf := make([]byte, len(insns)*bpfInsnLength)
+	for i, insn := range insns {
+		b := buf[i*bpfInsnLength:]
+		b[0] = insn.code
+		b[1] = insn.dst&0x0f | insn.src<<4
+		binary.LittleEndian.PutUint16(b[2:], uint16(insn.off))
+		binary.LittleEndian.PutUint32(b[4:], uint32(insn.imm))
+	}
+	return buf
+}
+
+// bpfProgLoadAttr 对应 union bpf_attr 中 BPF_PROG_LOAD 使用的部分
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+// bpfProgAttachAttr 对应 union bpf_attr 中 BPF_PROG_ATTACH 使用的部分
+type bpfProgAttachAttr struct {
+	targetFd     uint32
+	attachBpfFd  uint32
+	attachType   uint32
+	attachFlags  uint32
+	replaceBpfFd uint32
+}
+
+/*
+ * loadAndAttachDeviceFilter 通过 bpf 系统调用加载 eBPF 程序并挂载到 cgroup 上
+ * 挂载时不指定 BPF_F_ALLOW_MULTI，这样重复设置时新程序会替换掉 cgroup 上已有的程序
+ * 程序挂载后由 cgroup 持有引用，因此可以直接关闭程序的 fd
+ */
+func loadAndAttachDeviceFilter(dirFd int, insns []bpfInsn) error {
+	code := encodeInsns(insns)
+	license := []byte("GPL\x00")
+	logBuf := make([]byte, 64*1024)
+	loadAttr := bpfProgLoadAttr{
+		progType: unix.BPF_PROG_TYPE_CGROUP_DEVICE,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(logBuf)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+	progFd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD,
+		uintptr(unsafe.Pointer(&loadAttr)), unsafe.Sizeof(loadAttr))
+	runtime.KeepAlive(code)
+	runtime.KeepAlive(license)
+	if errno != 0 {
+		verifierLog := strings.TrimRight(string(logBuf), "\x00")
+		return errors.Wrapf(errno, "load device filter program, verifier log: %s", verifierLog)
+	}
+	defer unix.Close(int(progFd))
+
+	attachAttr := bpfProgAttachAttr{
+		targetFd:    uint32(dirFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  unix.BPF_CGROUP_DEVICE,
+	}
+	_, _, errno = unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_ATTACH,
+		uintptr(unsafe.Pointer(&attachAttr)), unsafe.Sizeof(attachAttr))
+	if errno != 0 {
+		return errors.Wrap(errno, "attach device filter program")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package subsystems
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// DevicesSubSystemV2 cgroup v2 中没有 devices controller，设备访问控制需要通过挂载到 cgroup 上的 eBPF 程序实现
+type DevicesSubSystemV2 struct {
+	cgroupBase
+}
+
+// Name 返回名字，仅用于日志
+func (s *DevicesSubSystemV2) Name() string {
+	return "devices"
+}
+
+/*
+ * Set 根据设备访问规则生成 BPF_PROG_TYPE_CGROUP_DEVICE 类型的 eBPF 程序，并挂载到 cgroupPath 对应的 cgroup 上
+ * 进程每次访问设备（open、mknod）时内核都会执行该程序，返回 0 表示拒绝访问
+ */
+func (s *DevicesSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if len(res.Devices) == 0 {
+		return nil
+	}
+
+	subsysCgroupPath, err := s.getCgroupPathV2(cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	insns, err := deviceFilterProgram(res.Devices)
+	if err != nil {
+		return err
+	}
+	dirFd, err := unix.Open(subsysCgroupPath, unix.O_DIRECTORY|unix.O_RDONLY, 0)
+	if err != nil {
+		return errors.Wrapf(err, "open cgroup %s", subsysCgroupPath)
+	}
+	defer unix.Close(dirFd)
+	return errors.WithMessagef(loadAndAttachDeviceFilter(dirFd, insns), "set device filter for %s", cgroupPath)
+}
+
+// Apply 设备访问控制不需要启用 controller，进程已经由其他 controller 加入到 cgroup 中了
+func (s *DevicesSubSystemV2) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	return nil
+}
+
+// Remove 删除 cgroupPath 对应的 cgroup，挂载在上面的 eBPF 程序会随 cgroup 一起释放
+func (s *DevicesSubSystemV2) Remove(cgroupPath string) error {
+	return s.removeV2(cgroupPath)
+}
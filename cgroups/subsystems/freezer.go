@@ -0,0 +1,93 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	frozen = "FROZEN"
+	thawed = "THAWED"
+	// 冻结是异步的，需要轮询 freezer.state 等待状态变化
+	freezeRetryInterval = 10 * time.Millisecond
+	freezeRetryTimes    = 500
+)
+
+// FreezerSubSystem 用于挂起（pause）和恢复（unpause）cgroup 中的所有进程，没有资源限制
+type FreezerSubSystem struct {
+	cgroupBase
+}
+
+// Name 返回 cgroup 名字
+func (s *FreezerSubSystem) Name() string {
+	return "freezer"
+}
+
+// Set freezer 没有资源限制，不需要设置
+func (s *FreezerSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	return nil
+}
+
+// Apply 将 pid 加入到 cgroupPath 对应的 cgroup 中
+func (s *FreezerSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return errors.Wrapf(err, "get cgroup %s", cgroupPath)
+	}
+
+	err = os.WriteFile(path.Join(subsysCgroupPath, cgroupProcs),
+		[]byte(strconv.Itoa(pid)),
+		constant.Perm0644)
+	if err != nil {
+		return fmt.Errorf("set cgroup proc fail %v", err)
+	}
+	return nil
+}
+
+// Remove 删除 cgroupPath 对应的 cgroup
+func (s *FreezerSubSystem) Remove(cgroupPath string) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(subsysCgroupPath)
+}
+
+/*
+ * Freeze 冻结或解冻 cgroupPath 对应的 cgroup 中的所有进程
+ * 向 freezer.state 写入 FROZEN 后状态会先变成 FREEZING，等所有进程都被冻结后才会变成 FROZEN，
+ * 因此写入后需要轮询，直到状态变成期望的值
+ */
+func (s *FreezerSubSystem) Freeze(cgroupPath string, freeze bool) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	state := thawed
+	if freeze {
+		state = frozen
+	}
+	stateFile := path.Join(subsysCgroupPath, "freezer.state")
+	for i := 0; i < freezeRetryTimes; i++ {
+		if err = os.WriteFile(stateFile, []byte(state), constant.Perm0644); err != nil {
+			return errors.Wrapf(err, "write %s to freezer.state", state)
+		}
+		current, err := os.ReadFile(stateFile)
+		if err != nil {
+			return errors.Wrap(err, "read freezer.state")
+		}
+		if strings.TrimSpace(string(current)) == state {
+			return nil
+		}
+		time.Sleep(freezeRetryInterval)
+	}
+	return fmt.Errorf("timeout waiting for cgroup %s to be %s", cgroupPath, state)
+}
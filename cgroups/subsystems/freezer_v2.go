@@ -0,0 +1,70 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+// FreezerSubSystemV2 cgroup v2 中没有 freezer controller，而是由每个非根 cgroup 中的 cgroup.freeze 文件提供冻结功能
+type FreezerSubSystemV2 struct {
+	cgroupBase
+}
+
+// Name 返回名字，仅用于日志
+func (s *FreezerSubSystemV2) Name() string {
+	return "freezer"
+}
+
+// Set freezer 没有资源限制，不需要设置
+func (s *FreezerSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	return nil
+}
+
+// Apply cgroup.freeze 是 v2 的核心文件，不需要启用 controller，进程已经由其他 controller 加入到 cgroup 中了
+func (s *FreezerSubSystemV2) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	return nil
+}
+
+// Remove 删除 cgroupPath 对应的 cgroup
+func (s *FreezerSubSystemV2) Remove(cgroupPath string) error {
+	return s.removeV2(cgroupPath)
+}
+
+/*
+ * Freeze 冻结或解冻 cgroupPath 对应的 cgroup 中的所有进程
+ * 向 cgroup.freeze 写入 1 表示冻结，写入 0 表示解冻，
+ * 冻结完成后 cgroup.events 中的 frozen 字段会变成 1，因此写入后需要轮询等待
+ */
+func (s *FreezerSubSystemV2) Freeze(cgroupPath string, freeze bool) error {
+	subsysCgroupPath, err := s.getCgroupPathV2(cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	state := "0"
+	if freeze {
+		state = "1"
+	}
+	if err = os.WriteFile(path.Join(subsysCgroupPath, "cgroup.freeze"), []byte(state), constant.Perm0644); err != nil {
+		return errors.Wrapf(err, "write %s to cgroup.freeze", state)
+	}
+	for i := 0; i < freezeRetryTimes; i++ {
+		events, err := os.ReadFile(path.Join(subsysCgroupPath, "cgroup.events"))
+		if err != nil {
+			return errors.Wrap(err, "read cgroup.events")
+		}
+		for _, line := range strings.Split(string(events), "\n") {
+			if line == "frozen "+state {
+				return nil
+			}
+		}
+		time.Sleep(freezeRetryInterval)
+	}
+	return fmt.Errorf("timeout waiting for cgroup %s frozen=%s", cgroupPath, state)
+}
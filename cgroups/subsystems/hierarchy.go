@@ -0,0 +1,142 @@
+package subsystems
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	mountPointIndex      = 4
+	defaultMountInfoPath = "/proc/self/mountinfo"
+)
+
+// Hierarchy 描述 cgroup 层级在文件系统中的位置，Subsystem 通过它找到各个 hierarchy 的挂载点
+// 默认使用宿主机真实的挂载信息，测试时可以指向临时目录中伪造的 cgroup 层级
+type Hierarchy struct {
+	// Root 拼接在 mountinfo 中的挂载点前面的根目录，为空表示宿主机的根目录
+	Root string
+	// MountInfoPath mountinfo 文件的路径，为空时读取 /proc/self/mountinfo
+	MountInfoPath string
+}
+
+// DefaultHierarchy 宿主机上真实的 cgroup 层级
+var DefaultHierarchy = &Hierarchy{}
+
+// NewSubsystems 返回 hierarchy 中 cgroup v1 的所有 Subsystem 实例
+func NewSubsystems(hierarchy *Hierarchy) []Subsystem {
+	base := cgroupBase{hierarchy: hierarchy}
+	return []Subsystem{
+		&CpusetSubSystem{base},
+		&MemorySubSystem{base},
+		&CpuSubSystem{base},
+		&CpuacctSubSystem{base},
+		&PidsSubSystem{base},
+		&BlkioSubSystem{base},
+		&FreezerSubSystem{base},
+		&DevicesSubSystem{base},
+	}
+}
+
+// NewSubsystemsV2 返回 hierarchy 中 cgroup v2（unified hierarchy）的所有 Subsystem 实例
+func NewSubsystemsV2(hierarchy *Hierarchy) []Subsystem {
+	base := cgroupBase{hierarchy: hierarchy}
+	return []Subsystem{
+		&CpusetSubSystemV2{base},
+		&MemorySubSystemV2{base},
+		&CpuSubSystemV2{base},
+		&PidsSubSystemV2{base},
+		&IoSubSystemV2{base},
+		&FreezerSubSystemV2{base},
+		&DevicesSubSystemV2{base},
+	}
+}
+
+// cgroupBase 各个 Subsystem 共用的部分，记录 Subsystem 所在的 cgroup 层级
+type cgroupBase struct {
+	hierarchy *Hierarchy
+}
+
+// hierarchyOrDefault 直接通过 &CpuSubSystem{} 创建的 Subsystem 没有指定层级，使用宿主机的 cgroup 层级
+func (b *cgroupBase) hierarchyOrDefault() *Hierarchy {
+	if b.hierarchy == nil {
+		return DefaultHierarchy
+	}
+	return b.hierarchy
+}
+
+// IsCgroup2UnifiedMode 判断是否只挂载了 cgroup v2
+// hybrid 模式下（v1 和 v2 同时挂载，v2 挂载在 /sys/fs/cgroup/unified）各个 subsystem 仍然在 v1 上，因此依旧使用 v1
+func (h *Hierarchy) IsCgroup2UnifiedMode() bool {
+	return h.findCgroupMountpoint("memory") == "" && h.findCgroup2Mountpoint() != ""
+}
+
+// findCgroupMountpoint 通过 mountinfo 找出挂载了某个 subsystem 的 hierarchy cgroup 根节点所在的目录，挂载点会拼接上 Root
+func (h *Hierarchy) findCgroupMountpoint(subsystem string) string {
+	var mountpoint string
+	h.scanMountInfo(func(txt string) bool {
+		// txt 大概是这样的：104 85 0:20 / /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime - cgroup cgroup rw,memory
+		// 然后按照空格分割
+		fields := strings.Split(txt, " ")
+		// 对最后一个元素按逗号进行分割，这里的最后一个元素就是 rw,memory
+		// 其中的的 memory 就表示这是一个 memory subsystem
+		for _, opt := range strings.Split(fields[len(fields)-1], ",") {
+			if opt == subsystem {
+				// 如果等于指定的 subsystem，那么就返回这个挂载点跟目录，就是第四个元素，
+				// 这里就是`/sys/fs/cgroup/memory`,即我们要找的根目录
+				mountpoint = path.Join(h.Root, fields[mountPointIndex])
+				return true
+			}
+		}
+		return false
+	})
+	return mountpoint
+}
+
+// Cgroup2Mountpoint 返回 cgroup v2 的挂载点，没有挂载时返回空字符串
+func (h *Hierarchy) Cgroup2Mountpoint() string {
+	return h.findCgroup2Mountpoint()
+}
+
+// findCgroup2Mountpoint 通过 mountinfo 找出 cgroup v2（unified hierarchy）的挂载点
+func (h *Hierarchy) findCgroup2Mountpoint() string {
+	var mountpoint string
+	h.scanMountInfo(func(txt string) bool {
+		// txt 大概是这样的：42 32 0:38 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime - cgroup2 cgroup2 rw
+		// 分隔符 " - " 之后的第一个字段就是文件系统类型
+		fields := strings.Split(txt, " - ")
+		if len(fields) == 2 && strings.HasPrefix(fields[1], "cgroup2 ") {
+			mountpoint = path.Join(h.Root, strings.Split(fields[0], " ")[mountPointIndex])
+			return true
+		}
+		return false
+	})
+	return mountpoint
+}
+
+// scanMountInfo 逐行读取 mountinfo，match 返回 true 时停止读取
+func (h *Hierarchy) scanMountInfo(match func(txt string) bool) {
+	mountInfoPath := h.MountInfoPath
+	if mountInfoPath == "" {
+		//  /proc/self/mountinfo 为当前进程的 mountinfo 信息
+		// 可以直接通过 cat /proc/self/mountinfo 命令查看
+		mountInfoPath = defaultMountInfoPath
+	}
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if match(scanner.Text()) {
+			return
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		log.Error("read err: ", err)
+	}
+}
@@ -0,0 +1,144 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+// IoSubSystemV2 cgroup v2 的 io controller，对应 v1 的 blkio
+type IoSubSystemV2 struct {
+	cgroupBase
+}
+
+// Name 返回 controller 名字
+func (s *IoSubSystemV2) Name() string {
+	return "io"
+}
+
+// Set 设置 cgroupPath 对应的 cgroup 的块设备 IO 限制
+func (s *IoSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if !res.hasBlkio() {
+		return nil
+	}
+
+	subsysCgroupPath, err := s.getCgroupPathV2(cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err = s.enableController(cgroupPath, s.Name()); err != nil {
+		return err
+	}
+
+	// v2 中使用 io.weight 替代了 v1 的 blkio.weight，取值范围从 [10, 1000] 变成了 [1, 10000]
+	if res.BlkioWeight != 0 {
+		err = os.WriteFile(path.Join(subsysCgroupPath, "io.weight"),
+			[]byte(fmt.Sprintf("default %d", convertBlkioToIOWeight(res.BlkioWeight))),
+			constant.Perm0644)
+		if err != nil {
+			return errors.Wrap(err, "set cgroup io weight fail")
+		}
+	}
+
+	// v2 中将 v1 的 4 个 blkio.throttle.* 文件合并成了 io.max，格式为 major:minor rbps=x wbps=x riops=x wiops=x
+	for _, line := range ioMaxLines(res) {
+		err = os.WriteFile(path.Join(subsysCgroupPath, "io.max"),
+			[]byte(line),
+			constant.Perm0644)
+		if err != nil {
+			return errors.Wrap(err, "set cgroup io.max fail")
+		}
+	}
+	return nil
+}
+
+// Apply 将 pid 加入到 cgroupPath 对应的 cgroup 中
+func (s *IoSubSystemV2) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	return s.applyV2(cgroupPath, s.Name(), pid)
+}
+
+// Remove 删除 cgroupPath 对应的 cgroup
+func (s *IoSubSystemV2) Remove(cgroupPath string) error {
+	return s.removeV2(cgroupPath)
+}
+
+// ioMaxLines 将各个设备的限速配置按设备聚合，生成需要写入 io.max 的内容，每个设备一行
+func ioMaxLines(res *ResourceConfig) []string {
+	var devices []string
+	limits := map[string][]string{}
+	throttles := []struct {
+		key     string
+		devices []*ThrottleDevice
+	}{
+		{"rbps", res.BlkioDeviceReadBps},
+		{"wbps", res.BlkioDeviceWriteBps},
+		{"riops", res.BlkioDeviceReadIOps},
+		{"wiops", res.BlkioDeviceWriteIOps},
+	}
+	for _, throttle := range throttles {
+		for _, device := range throttle.devices {
+			dev := fmt.Sprintf("%d:%d", device.Major, device.Minor)
+			if _, ok := limits[dev]; !ok {
+				devices = append(devices, dev)
+			}
+			limits[dev] = append(limits[dev], fmt.Sprintf("%s=%d", throttle.key, device.Rate))
+		}
+	}
+	lines := make([]string, 0, len(devices))
+	for _, dev := range devices {
+		lines = append(lines, dev+" "+strings.Join(limits[dev], " "))
+	}
+	return lines
+}
+
+// convertBlkioToIOWeight 将 v1 的 blkio.weight 换算成 v2 的 io.weight
+// 换算公式与 runc 保持一致：[10, 1000] 线性映射到 [1, 10000]
+func convertBlkioToIOWeight(blkioWeight uint16) uint64 {
+	if blkioWeight == 0 {
+		return 0
+	}
+	return 1 + (uint64(blkioWeight)-10)*9999/990
+}
+
+// GetStats 读取 cgroupPath 对应的 cgroup 的块设备 IO 使用情况
+func (s *IoSubSystemV2) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := s.getCgroupPathV2(cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	// v2 中使用 io.stat 替代了 v1 的 blkio.throttle.io_service_bytes 等文件，每个设备一行：
+	// 8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0
+	content, err := os.ReadFile(path.Join(subsysCgroupPath, "io.stat"))
+	if err != nil {
+		return errors.Wrap(err, "read io stats")
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			value, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch kv[0] {
+			case "rbytes":
+				stats.BlkioRead += value
+			case "wbytes":
+				stats.BlkioWrite += value
+			}
+		}
+	}
+	return nil
+}
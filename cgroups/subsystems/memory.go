@@ -12,6 +12,7 @@ import (
 )
 
 type MemorySubSystem struct {
+	cgroupBase
 }
 
 // Name 返回 cgroup 名字
@@ -21,21 +22,55 @@ func (s *MemorySubSystem) Name() string {
 
 // Set 设置 cgroupPath 对应的 cgroup 的内存资源限制
 func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
-	if res.MemoryLimit == "" {
+	if !res.hasMemory() {
 		return nil
 	}
 
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, true)
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
 	if err != nil {
 		return err
 	}
 
 	// 设置这个 cgroup 的内存限制，即将限制写入到 cgroup 对应目录的 memory.limit_in_bytes 文件中
-	err = os.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"),
-		[]byte(res.MemoryLimit),
-		constant.Perm0644)
-	if err != nil {
-		return fmt.Errorf("set cgroup memory fail %v", err)
+	if res.MemoryLimit != "" {
+		err = os.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"),
+			[]byte(res.MemoryLimit),
+			constant.Perm0644)
+		// 内核要求 memsw 限制始终不小于内存限制，update 调大内存限制时可能会超过当前的 memsw 限制导致写入失败，
+		// 这种情况下先设置 memsw 再重新设置内存限制
+		if err != nil && res.MemorySwap != "" {
+			if err = os.WriteFile(path.Join(subsysCgroupPath, "memory.memsw.limit_in_bytes"),
+				[]byte(res.MemorySwap),
+				constant.Perm0644); err == nil {
+				err = os.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"),
+					[]byte(res.MemoryLimit),
+					constant.Perm0644)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("set cgroup memory fail %v", err)
+		}
+	}
+
+	// memory.soft_limit_in_bytes 是内存软限制，只有在系统内存紧张时才会将 cgroup 的内存回收到这个值
+	if res.MemoryReservation != "" {
+		err = os.WriteFile(path.Join(subsysCgroupPath, "memory.soft_limit_in_bytes"),
+			[]byte(res.MemoryReservation),
+			constant.Perm0644)
+		if err != nil {
+			return fmt.Errorf("set cgroup memory reservation fail %v", err)
+		}
+	}
+
+	// memory.memsw.limit_in_bytes 限制的是内存 + swap 的总量，必须在 memory.limit_in_bytes 之后设置
+	// NOTE: 需要内核开启 swap accounting（swapaccount=1）才会有这个文件
+	if res.MemorySwap != "" {
+		err = os.WriteFile(path.Join(subsysCgroupPath, "memory.memsw.limit_in_bytes"),
+			[]byte(res.MemorySwap),
+			constant.Perm0644)
+		if err != nil {
+			return fmt.Errorf("set cgroup memory swap fail %v", err)
+		}
 	}
 
 	return nil
@@ -43,17 +78,14 @@ func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 
 // Apply 将 pid 加入到 cgroupPath 对应的 cgroup 中
 func (s *MemorySubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
-	if res.MemoryLimit == "" {
-		return nil
-	}
-
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
 	if err != nil {
 		return errors.Wrapf(err, "get cgroup %s", cgroupPath)
 	}
 
 	// 将进程 PID 加入到 cgroupPath 对应的 cgroup 中
-	err = os.WriteFile(path.Join(subsysCgroupPath, "tasks"),
+	// NOTE: tasks 文件只会移动单个线程，而 cgroup.procs 会移动整个进程的所有线程
+	err = os.WriteFile(path.Join(subsysCgroupPath, cgroupProcs),
 		[]byte(strconv.Itoa(pid)),
 		constant.Perm0644)
 	if err != nil {
@@ -65,9 +97,26 @@ func (s *MemorySubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig)
 
 // Remove 删除 cgroupPath 对应的 cgroup
 func (s *MemorySubSystem) Remove(cgroupPath string) error {
-	subsysCgroupPath, err := getCgroupPath(s.Name(), cgroupPath, false)
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
 	if err != nil {
 		return err
 	}
 	return os.RemoveAll(subsysCgroupPath)
 }
+
+// GetStats 读取 cgroupPath 对应的 cgroup 的内存使用情况
+func (s *MemorySubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	if stats.MemoryUsage, err = readUint(path.Join(subsysCgroupPath, "memory.usage_in_bytes")); err != nil {
+		return errors.Wrap(err, "read memory usage")
+	}
+	if stats.MemoryLimit, err = readUint(path.Join(subsysCgroupPath, "memory.limit_in_bytes")); err != nil {
+		return errors.Wrap(err, "read memory limit")
+	}
+	// memory.oom_control 中的 oom_kill 需要 4.13 以上的内核才有，读取失败时忽略
+	stats.OOMKill, _ = readKeyValue(path.Join(subsysCgroupPath, "memory.oom_control"), "oom_kill")
+	return nil
+}
@@ -0,0 +1,130 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+/*
+ * NotifyOOM 监听 cgroup v1 的 OOM 事件
+ * 1. 创建一个 eventfd，并打开 memory.oom_control
+ * 2. 向 cgroup.event_control 写入 "{eventfd} {memory.oom_control 的 fd}" 注册 OOM 事件
+ * 3. 每次发生 OOM 内核都会向 eventfd 写入一个计数，读取 eventfd 即可感知 OOM
+ * NOTE: cgroup 被删除时内核同样会通知 eventfd，因此读到事件后需要判断 cgroup 是否还存在
+ */
+func (s *MemorySubSystem) NotifyOOM(cgroupPath string) (<-chan struct{}, error) {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, err
+	}
+	oomControl, err := os.Open(path.Join(subsysCgroupPath, "memory.oom_control"))
+	if err != nil {
+		return nil, errors.Wrap(err, "open memory.oom_control")
+	}
+	efd, err := unix.Eventfd(0, unix.EFD_CLOEXEC)
+	if err != nil {
+		oomControl.Close()
+		return nil, errors.Wrap(err, "create eventfd")
+	}
+	eventFile := os.NewFile(uintptr(efd), "eventfd")
+	eventControlPath := path.Join(subsysCgroupPath, "cgroup.event_control")
+	data := fmt.Sprintf("%d %d", efd, oomControl.Fd())
+	if err = os.WriteFile(eventControlPath, []byte(data), constant.Perm0644); err != nil {
+		eventFile.Close()
+		oomControl.Close()
+		return nil, errors.Wrap(err, "register oom event")
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			close(ch)
+			eventFile.Close()
+			oomControl.Close()
+		}()
+		// eventfd 每次读取的都是一个 8 字节的计数
+		buf := make([]byte, 8)
+		for {
+			if _, err := eventFile.Read(buf); err != nil {
+				return
+			}
+			if _, err := os.Stat(eventControlPath); os.IsNotExist(err) {
+				return
+			}
+			notify(ch)
+		}
+	}()
+	return ch, nil
+}
+
+/*
+ * NotifyOOM 监听 cgroup v2 的 OOM 事件
+ * v2 中不再支持 cgroup.event_control，而是在 memory.events 中记录 oom_kill 次数，文件内容变化时会产生 inotify 事件，
+ * 因此通过 inotify 监听 memory.events，每次变化后对比 oom_kill 的值即可感知 OOM
+ * cgroup.events 中的 populated 变成 0 说明 cgroup 中已经没有进程了，此时结束监听
+ */
+func (s *MemorySubSystemV2) NotifyOOM(cgroupPath string) (<-chan struct{}, error) {
+	subsysCgroupPath, err := s.getCgroupPathV2(cgroupPath, false)
+	if err != nil {
+		return nil, err
+	}
+	eventsPath := path.Join(subsysCgroupPath, "memory.events")
+	cgroupEventsPath := path.Join(subsysCgroupPath, "cgroup.events")
+	lastOOMKill, err := readKeyValue(eventsPath, "oom_kill")
+	if err != nil {
+		return nil, errors.Wrap(err, "read memory.events")
+	}
+
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, errors.Wrap(err, "init inotify")
+	}
+	inotifyFile := os.NewFile(uintptr(fd), "inotify")
+	for _, file := range []string{eventsPath, cgroupEventsPath} {
+		if _, err = unix.InotifyAddWatch(fd, file, unix.IN_MODIFY); err != nil {
+			inotifyFile.Close()
+			return nil, errors.Wrapf(err, "add inotify watch for %s", file)
+		}
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			close(ch)
+			inotifyFile.Close()
+		}()
+		buf := make([]byte, unix.SizeofInotifyEvent+unix.PathMax+1)
+		for {
+			if _, err := inotifyFile.Read(buf); err != nil {
+				return
+			}
+			oomKill, err := readKeyValue(eventsPath, "oom_kill")
+			if err != nil {
+				return
+			}
+			if oomKill > lastOOMKill {
+				lastOOMKill = oomKill
+				notify(ch)
+			}
+			populated, err := readKeyValue(cgroupEventsPath, "populated")
+			if err != nil || populated == 0 {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// notify 非阻塞地发送通知，接收方还没处理上一个通知时直接丢弃，避免阻塞监听的 goroutine
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
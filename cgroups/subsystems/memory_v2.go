@@ -0,0 +1,124 @@
+package subsystems
+
+import (
+	"os"
+	"path"
+	"strconv"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+// MemorySubSystemV2 cgroup v2 的 memory controller
+type MemorySubSystemV2 struct {
+	cgroupBase
+}
+
+// Name 返回 controller 名字
+func (s *MemorySubSystemV2) Name() string {
+	return "memory"
+}
+
+// Set 设置 cgroupPath 对应的 cgroup 的内存资源限制
+func (s *MemorySubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if !res.hasMemory() {
+		return nil
+	}
+
+	subsysCgroupPath, err := s.getCgroupPathV2(cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err = s.enableController(cgroupPath, s.Name()); err != nil {
+		return err
+	}
+
+	// v2 中使用 memory.max 替代了 v1 的 memory.limit_in_bytes
+	if res.MemoryLimit != "" {
+		limit, err := parseBytes(res.MemoryLimit)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path.Join(subsysCgroupPath, "memory.max"),
+			[]byte(strconv.FormatInt(limit, 10)),
+			constant.Perm0644)
+		if err != nil {
+			return errors.Wrap(err, "set cgroup memory fail")
+		}
+	}
+
+	// v2 中使用 memory.low 替代了 v1 的 memory.soft_limit_in_bytes
+	if res.MemoryReservation != "" {
+		reservation, err := parseBytes(res.MemoryReservation)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path.Join(subsysCgroupPath, "memory.low"),
+			[]byte(strconv.FormatInt(reservation, 10)),
+			constant.Perm0644)
+		if err != nil {
+			return errors.Wrap(err, "set cgroup memory reservation fail")
+		}
+	}
+
+	// v2 中 memory.swap.max 只限制 swap 的用量，而 MemorySwap 是内存 + swap 的总量，因此需要减去内存限制
+	if res.MemorySwap != "" {
+		swapMax, err := convertMemorySwapToV2(res.MemoryLimit, res.MemorySwap)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path.Join(subsysCgroupPath, "memory.swap.max"),
+			[]byte(swapMax),
+			constant.Perm0644)
+		if err != nil {
+			return errors.Wrap(err, "set cgroup memory swap fail")
+		}
+	}
+	return nil
+}
+
+// Apply 将 pid 加入到 cgroupPath 对应的 cgroup 中
+func (s *MemorySubSystemV2) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	return s.applyV2(cgroupPath, s.Name(), pid)
+}
+
+// Remove 删除 cgroupPath 对应的 cgroup
+func (s *MemorySubSystemV2) Remove(cgroupPath string) error {
+	return s.removeV2(cgroupPath)
+}
+
+// convertMemorySwapToV2 将 v1 中内存 + swap 的总量换算成 v2 memory.swap.max 需要的 swap 用量
+func convertMemorySwapToV2(memoryLimit, memorySwap string) (string, error) {
+	if memorySwap == "-1" {
+		return "max", nil
+	}
+	memory, err := parseBytes(memoryLimit)
+	if err != nil {
+		return "", err
+	}
+	swap, err := parseBytes(memorySwap)
+	if err != nil {
+		return "", err
+	}
+	if swap < memory {
+		return "", errors.New("memory swap limit should be larger than memory limit")
+	}
+	return strconv.FormatInt(swap-memory, 10), nil
+}
+
+// GetStats 读取 cgroupPath 对应的 cgroup 的内存使用情况，v2 中使用 memory.current 替代了 v1 的 memory.usage_in_bytes
+func (s *MemorySubSystemV2) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := s.getCgroupPathV2(cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	if stats.MemoryUsage, err = readUint(path.Join(subsysCgroupPath, "memory.current")); err != nil {
+		return errors.Wrap(err, "read memory usage")
+	}
+	if stats.MemoryLimit, err = readUint(path.Join(subsysCgroupPath, "memory.max")); err != nil {
+		return errors.Wrap(err, "read memory limit")
+	}
+	stats.OOMKill, err = readKeyValue(path.Join(subsysCgroupPath, "memory.events"), "oom_kill")
+	return errors.Wrap(err, "read memory events")
+}
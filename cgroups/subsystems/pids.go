@@ -0,0 +1,86 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+// PidsUnlimited --pids-limit 为 -1 时表示不限制进程数，update 时可以用来去掉已经设置的限制
+const PidsUnlimited = -1
+
+type PidsSubSystem struct {
+	cgroupBase
+}
+
+// Name 返回 cgroup 名字
+func (s *PidsSubSystem) Name() string {
+	return "pids"
+}
+
+// Set 设置 cgroupPath 对应的 cgroup 的最大进程数，避免容器内的 fork 炸弹拖垮整个宿主机
+func (s *PidsSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if res.PidsLimit == 0 {
+		return nil
+	}
+
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(path.Join(subsysCgroupPath, "pids.max"),
+		[]byte(pidsMax(res.PidsLimit)),
+		constant.Perm0644)
+	if err != nil {
+		return fmt.Errorf("set cgroup pids fail %v", err)
+	}
+	return nil
+}
+
+// pidsMax 返回写入 pids.max 的内容，PidsLimit 为 -1 时表示不限制，v1 和 v2 中都写入 max
+func pidsMax(limit int64) string {
+	if limit == PidsUnlimited {
+		return "max"
+	}
+	return strconv.FormatInt(limit, 10)
+}
+
+// Apply 将 pid 加入到 cgroupPath 对应的 cgroup 中
+func (s *PidsSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return errors.Wrapf(err, "get cgroup %s", cgroupPath)
+	}
+
+	err = os.WriteFile(path.Join(subsysCgroupPath, cgroupProcs),
+		[]byte(strconv.Itoa(pid)),
+		constant.Perm0644)
+	if err != nil {
+		return fmt.Errorf("set cgroup proc fail %v", err)
+	}
+	return nil
+}
+
+// Remove 删除 cgroupPath 对应的 cgroup
+func (s *PidsSubSystem) Remove(cgroupPath string) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(subsysCgroupPath)
+}
+
+// GetStats 读取 cgroupPath 对应的 cgroup 的进程数
+func (s *PidsSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := s.getCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	return readPidsStats(subsysCgroupPath, stats)
+}
@@ -0,0 +1,59 @@
+package subsystems
+
+import (
+	"os"
+	"path"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+// PidsSubSystemV2 cgroup v2 的 pids controller
+type PidsSubSystemV2 struct {
+	cgroupBase
+}
+
+// Name 返回 controller 名字
+func (s *PidsSubSystemV2) Name() string {
+	return "pids"
+}
+
+// Set 设置 cgroupPath 对应的 cgroup 的最大进程数，v2 中文件名与 v1 一致
+func (s *PidsSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if res.PidsLimit == 0 {
+		return nil
+	}
+
+	subsysCgroupPath, err := s.getCgroupPathV2(cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err = s.enableController(cgroupPath, s.Name()); err != nil {
+		return err
+	}
+
+	err = os.WriteFile(path.Join(subsysCgroupPath, "pids.max"),
+		[]byte(pidsMax(res.PidsLimit)),
+		constant.Perm0644)
+	return errors.Wrap(err, "set cgroup pids fail")
+}
+
+// Apply 将 pid 加入到 cgroupPath 对应的 cgroup 中
+func (s *PidsSubSystemV2) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	return s.applyV2(cgroupPath, s.Name(), pid)
+}
+
+// Remove 删除 cgroupPath 对应的 cgroup
+func (s *PidsSubSystemV2) Remove(cgroupPath string) error {
+	return s.removeV2(cgroupPath)
+}
+
+// GetStats 读取 cgroupPath 对应的 cgroup 的进程数
+func (s *PidsSubSystemV2) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := s.getCgroupPathV2(cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	return readPidsStats(subsysCgroupPath, stats)
+}
@@ -0,0 +1,272 @@
+package subsystems
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	blkioWeightMin = 10
+	blkioWeightMax = 1000
+	cpuSharesMin   = 2
+	cpuSharesMax   = 262144
+	// CFS 调度周期的取值范围 [1ms, 1s]，quota 最小为 1ms，单位都是微秒
+	cpuPeriodMin = 1000
+	cpuPeriodMax = 1000000
+	cpuQuotaMin  = 1000
+	nanoCpusUnit = 1e9
+)
+
+// ThrottleDevice 块设备 IO 限速配置，设备以 major:minor 表示
+type ThrottleDevice struct {
+	Path  string `json:"path"` // 宿主机上的设备路径，仅用于展示
+	Major int64  `json:"major"`
+	Minor int64  `json:"minor"`
+	Rate  uint64 `json:"rate"` // bps 限速时为每秒字节数，iops 限速时为每秒 IO 次数
+}
+
+// String 返回 cgroup 文件中使用的格式，e.g. 8:0 1048576
+func (d *ThrottleDevice) String() string {
+	return fmt.Sprintf("%d:%d %d", d.Major, d.Minor, d.Rate)
+}
+
+/*
+ * ParseThrottleDevice 解析 --device-read-bps 等参数，格式为 {设备路径}:{速率}，e.g. /dev/sda:1mb
+ * withUnit 为 true 时速率可以带单位（bps），否则必须是整数（iops）
+ */
+func ParseThrottleDevice(spec string, withUnit bool) (*ThrottleDevice, error) {
+	idx := strings.LastIndex(spec, ":")
+	if idx <= 0 || idx == len(spec)-1 {
+		return nil, fmt.Errorf("invalid device throttle [%s], must be like /dev/sda:1mb", spec)
+	}
+	devicePath, rateStr := spec[:idx], spec[idx+1:]
+
+	var rate uint64
+	if withUnit {
+		bytes, err := parseBytes(rateStr)
+		if err != nil {
+			return nil, err
+		}
+		rate = uint64(bytes)
+	} else {
+		var err error
+		if rate, err = strconv.ParseUint(rateStr, 10, 64); err != nil {
+			return nil, errors.Wrapf(err, "invalid rate %s", rateStr)
+		}
+	}
+
+	// 通过 stat 拿到设备号，cgroup 中是通过 major:minor 来指定设备的
+	var stat syscall.Stat_t
+	if err := syscall.Stat(devicePath, &stat); err != nil {
+		return nil, errors.Wrapf(err, "stat device %s", devicePath)
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return nil, fmt.Errorf("%s is not a block device", devicePath)
+	}
+	return &ThrottleDevice{
+		Path:  devicePath,
+		Major: int64(unix.Major(stat.Rdev)),
+		Minor: int64(unix.Minor(stat.Rdev)),
+		Rate:  rate,
+	}, nil
+}
+
+// Validate 校验资源配置是否合法
+func (r *ResourceConfig) Validate() error {
+	if r.MemoryReservation != "" {
+		if _, err := parseBytes(r.MemoryReservation); err != nil {
+			return errors.WithMessage(err, "invalid memory reservation")
+		}
+	}
+	if r.MemorySwap != "" && r.MemorySwap != "-1" {
+		if r.MemoryLimit == "" {
+			return errors.New("memory swap can not be set without memory limit")
+		}
+		memory, err := parseBytes(r.MemoryLimit)
+		if err != nil {
+			return errors.WithMessage(err, "invalid memory limit")
+		}
+		swap, err := parseBytes(r.MemorySwap)
+		if err != nil {
+			return errors.WithMessage(err, "invalid memory swap")
+		}
+		// memory swap 表示的是内存 + swap 的总量，因此不能比内存限制小
+		if swap < memory {
+			return errors.New("memory swap limit should be larger than memory limit")
+		}
+	}
+	if err := r.validateCpu(); err != nil {
+		return err
+	}
+	if r.PidsLimit < PidsUnlimited {
+		return fmt.Errorf("invalid pids limit %d, must be -1 or positive", r.PidsLimit)
+	}
+	if r.BlkioWeight != 0 && (r.BlkioWeight < blkioWeightMin || r.BlkioWeight > blkioWeightMax) {
+		return fmt.Errorf("blkio weight %d out of range [%d, %d]", r.BlkioWeight, blkioWeightMin, blkioWeightMax)
+	}
+	return nil
+}
+
+/*
+ * validateCpu 校验 CPU 相关的配置
+ * 1. --cpus 与 --cpu-period、--cpu-quota、-cpu 是互斥的，它们都用于设置 CFS 的 quota 和 period
+ * 2. 限制的 CPU 个数（quota / period）不能超过宿主机的 CPU 个数
+ */
+func (r *ResourceConfig) validateCpu() error {
+	if r.CpuShare != "" {
+		shares, err := strconv.ParseInt(r.CpuShare, 10, 64)
+		if err != nil || shares < cpuSharesMin || shares > cpuSharesMax {
+			return fmt.Errorf("invalid cpu shares %s, must be in range [%d, %d]", r.CpuShare, cpuSharesMin, cpuSharesMax)
+		}
+	}
+	if r.NanoCpus != 0 && (r.CpuPeriod != 0 || r.CpuQuota != 0 || r.CpuCfsQuota != 0) {
+		return errors.New("conflicting options: cpus and cpu period/quota can not both be set")
+	}
+	if r.CpuCfsQuota != 0 && (r.CpuPeriod != 0 || r.CpuQuota != 0) {
+		return errors.New("conflicting options: cpu and cpu period/quota can not both be set")
+	}
+	if r.NanoCpus < 0 || r.CpuCfsQuota < 0 {
+		return errors.New("cpus must be positive")
+	}
+	if r.CpuPeriod != 0 && (r.CpuPeriod < cpuPeriodMin || r.CpuPeriod > cpuPeriodMax) {
+		return fmt.Errorf("invalid cpu period %d, must be in range [%d, %d]", r.CpuPeriod, cpuPeriodMin, cpuPeriodMax)
+	}
+	if r.CpuQuota != 0 && r.CpuQuota != -1 && r.CpuQuota < cpuQuotaMin {
+		return fmt.Errorf("invalid cpu quota %d, must be -1 or larger than %d", r.CpuQuota, cpuQuotaMin)
+	}
+	quota, period := r.CpuQuotaAndPeriod()
+	if quota > 0 {
+		if quota < cpuQuotaMin {
+			return fmt.Errorf("cpu quota %dus is too small, at least %dus", quota, cpuQuotaMin)
+		}
+		hostCpus := runtime.NumCPU()
+		if float64(quota)/float64(period) > float64(hostCpus) {
+			return fmt.Errorf("cpu limit %.2f is larger than available cpus %d",
+				float64(quota)/float64(period), hostCpus)
+		}
+	}
+	return nil
+}
+
+/*
+ * CpuQuotaAndPeriod 将 CPU 相关的配置换算成 CFS 的 quota 和 period，单位都是微秒
+ * quota 为 0 表示不修改，-1 表示不限制；period 为 0 表示不修改
+ * e.g. --cpus 1.5 换算成 quota 150000、period 100000，即每 100ms 可以使用 150ms 的 CPU 时间
+ */
+func (r *ResourceConfig) CpuQuotaAndPeriod() (int64, uint64) {
+	period := r.CpuPeriod
+	switch {
+	case r.NanoCpus != 0:
+		return r.NanoCpus * PeriodDefault / nanoCpusUnit, PeriodDefault
+	case r.CpuCfsQuota != 0:
+		return int64(PeriodDefault / Percent * r.CpuCfsQuota), PeriodDefault
+	case r.CpuQuota != 0:
+		if period == 0 {
+			period = PeriodDefault
+		}
+		return r.CpuQuota, period
+	default:
+		return 0, period
+	}
+}
+
+// Merge 将 patch 中设置了的字段覆盖到当前配置上，返回合并后的新配置，用于 update 命令
+// patch 中字段为零值表示不修改，PidsLimit 为 -1 时去掉进程数限制
+func (r *ResourceConfig) Merge(patch *ResourceConfig) *ResourceConfig {
+	merged := &ResourceConfig{}
+	if r != nil {
+		*merged = *r
+	}
+	if patch.MemoryLimit != "" {
+		merged.MemoryLimit = patch.MemoryLimit
+	}
+	if patch.MemoryReservation != "" {
+		merged.MemoryReservation = patch.MemoryReservation
+	}
+	if patch.MemorySwap != "" {
+		merged.MemorySwap = patch.MemorySwap
+	}
+	if patch.CpuShare != "" {
+		merged.CpuShare = patch.CpuShare
+	}
+	// 设置 CFS quota 的几种方式是互斥的，patch 中使用了某一种方式时清空其他方式的配置
+	if patch.NanoCpus != 0 {
+		merged.NanoCpus, merged.CpuCfsQuota, merged.CpuQuota, merged.CpuPeriod = patch.NanoCpus, 0, 0, 0
+	}
+	if patch.CpuCfsQuota != 0 {
+		merged.NanoCpus, merged.CpuCfsQuota, merged.CpuQuota, merged.CpuPeriod = 0, patch.CpuCfsQuota, 0, 0
+	}
+	if patch.CpuQuota != 0 || patch.CpuPeriod != 0 {
+		merged.NanoCpus, merged.CpuCfsQuota = 0, 0
+	}
+	if patch.CpuQuota != 0 {
+		merged.CpuQuota = patch.CpuQuota
+	}
+	if patch.CpuPeriod != 0 {
+		merged.CpuPeriod = patch.CpuPeriod
+	}
+	if patch.CpuSet != "" {
+		merged.CpuSet = patch.CpuSet
+	}
+	if patch.PidsLimit != 0 {
+		merged.PidsLimit = patch.PidsLimit
+	}
+	if patch.BlkioWeight != 0 {
+		merged.BlkioWeight = patch.BlkioWeight
+	}
+	return merged
+}
+
+// MemoryLimitInBytes 返回以字节为单位的内存限制，未设置时返回 0
+func (r *ResourceConfig) MemoryLimitInBytes() (int64, error) {
+	if r.MemoryLimit == "" {
+		return 0, nil
+	}
+	return parseBytes(r.MemoryLimit)
+}
+
+// hasBlkio 判断是否配置了块设备 IO 限制
+func (r *ResourceConfig) hasBlkio() bool {
+	return r.BlkioWeight != 0 || len(r.BlkioDeviceReadBps) != 0 || len(r.BlkioDeviceWriteBps) != 0 ||
+		len(r.BlkioDeviceReadIOps) != 0 || len(r.BlkioDeviceWriteIOps) != 0
+}
+
+// hasCpu 判断是否配置了 CPU 相关的限制
+func (r *ResourceConfig) hasCpu() bool {
+	return r.CpuShare != "" || r.CpuCfsQuota != 0 || r.NanoCpus != 0 || r.CpuQuota != 0 || r.CpuPeriod != 0
+}
+
+// hasMemory 判断是否配置了内存相关的限制
+func (r *ResourceConfig) hasMemory() bool {
+	return r.MemoryLimit != "" || r.MemoryReservation != "" || r.MemorySwap != ""
+}
+
+// parseBytes 将 100m、1g 这样带单位的大小转换成字节数，cgroup v2 的 memory.max 等文件不支持带单位的写法
+func parseBytes(raw string) (int64, error) {
+	size := strings.ToLower(strings.TrimSpace(raw))
+	size = strings.TrimSuffix(size, "b")
+	units := map[byte]int64{
+		'k': 1 << 10,
+		'm': 1 << 20,
+		'g': 1 << 30,
+		't': 1 << 40,
+	}
+	multiple := int64(1)
+	if len(size) > 0 {
+		if unit, ok := units[size[len(size)-1]]; ok {
+			multiple = unit
+			size = size[:len(size)-1]
+		}
+	}
+	value, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || value < 0 {
+		return 0, errors.Errorf("invalid size %q", raw)
+	}
+	return value * multiple, nil
+}
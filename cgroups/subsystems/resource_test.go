@@ -0,0 +1,34 @@
+package subsystems_test
+
+import (
+	"testing"
+
+	"mydocker/cgroups/subsystems"
+)
+
+func TestMergePidsLimit(t *testing.T) {
+	current := &subsystems.ResourceConfig{PidsLimit: 100}
+	tests := []struct {
+		name    string
+		patch   int64
+		want    int64
+		wantErr bool
+	}{
+		{name: "unchanged", patch: 0, want: 100},
+		{name: "new limit", patch: 200, want: 200},
+		{name: "unlimited", patch: subsystems.PidsUnlimited, want: subsystems.PidsUnlimited},
+		{name: "invalid", patch: -2, want: -2, wantErr: true},
+	}
+	for _, tt := range tests {
+		merged := current.Merge(&subsystems.ResourceConfig{PidsLimit: tt.patch})
+		if merged.PidsLimit != tt.want {
+			t.Errorf("%s: got pids limit %d, want %d", tt.name, merged.PidsLimit, tt.want)
+		}
+		if err := merged.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validate error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if current.PidsLimit != 100 {
+		t.Errorf("merge should not modify the current config")
+	}
+}
@@ -1,12 +1,26 @@
 package subsystems
 
 // ResourceConfig 用于传递资源限制配置的结构体
-// 包含内存限制、CPU 时间片权重、CPU 核心数等
+// 包含内存限制、CPU 时间片权重、CPU 核心数、进程数、块设备 IO、设备访问规则等
 type ResourceConfig struct {
-	MemoryLimit string
-	CpuShare    string
-	CpuCfsQuota int
-	CpuSet      string
+	MemoryLimit       string `json:"memoryLimit"`
+	MemoryReservation string `json:"memoryReservation"` // 内存软限制
+	MemorySwap        string `json:"memorySwap"`        // 内存 + swap 的总限制，-1 表示不限制 swap
+	CpuShare          string `json:"cpuShare"`          // CPU 时间片的相对权重，取值范围 [2, 262144]
+	CpuCfsQuota       int    `json:"cpuCfsQuota"`       // 单个 CPU 的百分比，e.g. 20 表示 20%，与 NanoCpus、CpuQuota 互斥
+	NanoCpus          int64  `json:"nanoCpus"`          // 可以使用的 CPU 个数 * 1e9，e.g. 1.5 个 CPU 为 1500000000
+	CpuPeriod         uint64 `json:"cpuPeriod"`         // CFS 调度周期，单位为微秒，0 表示使用默认值 100ms
+	CpuQuota          int64  `json:"cpuQuota"`          // 每个 CFS 调度周期内可以使用的 CPU 时间，单位为微秒，-1 表示不限制
+	CpuSet            string `json:"cpuSet"`
+	PidsLimit         int64  `json:"pidsLimit"` // 最大进程数，0 表示不限制
+
+	BlkioWeight          uint16            `json:"blkioWeight"` // 块设备 IO 权重，取值范围 [10, 1000]
+	BlkioDeviceReadBps   []*ThrottleDevice `json:"blkioDeviceReadBps"`
+	BlkioDeviceWriteBps  []*ThrottleDevice `json:"blkioDeviceWriteBps"`
+	BlkioDeviceReadIOps  []*ThrottleDevice `json:"blkioDeviceReadIOps"`
+	BlkioDeviceWriteIOps []*ThrottleDevice `json:"blkioDeviceWriteIOps"`
+
+	Devices []*DeviceRule `json:"devices"` // 设备访问规则，按顺序生效
 }
 
 // Subsystem 接口定义了 cgroup 中的各个子系统应该具备的方法
@@ -22,9 +36,39 @@ type Subsystem interface {
 	Remove(path string) error
 }
 
-// SubsystemsIns 是一个 Subsystem 的切片，包含了所有的 Subsystem 实例
-var SubsystemsIns = []Subsystem{
-	&CpusetSubSystem{},
-	&MemorySubSystem{},
-	&CpuSubSystem{},
+// Stats cgroup 中各项资源的使用情况
+type Stats struct {
+	CpuUsage    uint64 `json:"cpuUsage"`    // 累计使用的 CPU 时间，单位为纳秒
+	MemoryUsage uint64 `json:"memoryUsage"` // 当前内存使用量，单位为字节
+	MemoryLimit uint64 `json:"memoryLimit"` // 内存限制，未限制时为一个很大的值
+	PidsCurrent uint64 `json:"pidsCurrent"` // 当前进程数
+	PidsLimit   uint64 `json:"pidsLimit"`   // 最大进程数，未限制时为 math.MaxUint64
+	BlkioRead   uint64 `json:"blkioRead"`   // 累计从块设备读取的字节数
+	BlkioWrite  uint64 `json:"blkioWrite"`  // 累计向块设备写入的字节数
+	OOMKill     uint64 `json:"oomKill"`     // 因超出内存限制被 OOM killer 杀死的进程数
+}
+
+// StatsGetter 接口定义了可以读取资源使用情况的 Subsystem 应该具备的方法，并不是每个 Subsystem 都需要实现
+type StatsGetter interface {
+	// GetStats 方法用于读取某个 cgroup 在这个 Subsystem 中的资源使用情况，并填充到 stats 中
+	GetStats(path string, stats *Stats) error
+}
+
+// Freezer 接口定义了可以冻结 cgroup 中所有进程的 Subsystem 应该具备的方法
+type Freezer interface {
+	// Freeze 方法用于冻结（frozen 为 true）或者解冻（frozen 为 false）某个 cgroup 中的所有进程
+	Freeze(path string, frozen bool) error
+}
+
+// OOMNotifier 接口定义了可以监听 OOM 事件的 Subsystem 应该具备的方法
+type OOMNotifier interface {
+	// NotifyOOM 方法用于监听某个 cgroup 的 OOM 事件，每次发生 OOM 都会向返回的 channel 发送一个信号，
+	// cgroup 被删除或者其中的进程全部退出后 channel 会被关闭
+	NotifyOOM(path string) (<-chan struct{}, error)
 }
+
+// SubsystemsIns 是一个 Subsystem 的切片，包含了宿主机上所有的 Subsystem 实例
+var SubsystemsIns = NewSubsystems(DefaultHierarchy)
+
+// SubsystemsInsV2 是 cgroup v2（unified hierarchy）下的 Subsystem 实例
+var SubsystemsInsV2 = NewSubsystemsV2(DefaultHierarchy)
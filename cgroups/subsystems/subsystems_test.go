@@ -0,0 +1,297 @@
+package subsystems_test
+
+import (
+	"testing"
+
+	"mydocker/cgroups/cgrouptest"
+	"mydocker/cgroups/subsystems"
+)
+
+const testCgroupPath = "mydocker/test"
+
+// cgroupFile 期望 Subsystem 写入的文件内容
+type cgroupFile struct {
+	subsystem string
+	file      string
+	content   string
+}
+
+func findSubsystem(t *testing.T, subsystemsIns []subsystems.Subsystem, name string) subsystems.Subsystem {
+	t.Helper()
+	for _, subSysIns := range subsystemsIns {
+		if subSysIns.Name() == name {
+			return subSysIns
+		}
+	}
+	t.Fatalf("subsystem %s not found", name)
+	return nil
+}
+
+func checkFiles(t *testing.T, fake *cgrouptest.FakeHierarchy, cgroupPath string, files []cgroupFile) {
+	t.Helper()
+	for _, f := range files {
+		if got := fake.ReadFile(f.subsystem, cgroupPath, f.file); got != f.content {
+			t.Errorf("%s: got %q, want %q", f.file, got, f.content)
+		}
+	}
+}
+
+func TestSubsystemsSetV1(t *testing.T) {
+	tests := []struct {
+		name      string
+		subsystem string
+		res       *subsystems.ResourceConfig
+		want      []cgroupFile
+	}{
+		{
+			name:      "cpus",
+			subsystem: "cpu",
+			res:       &subsystems.ResourceConfig{NanoCpus: 1500000000, CpuShare: "512"},
+			want: []cgroupFile{
+				{"cpu", "cpu.shares", "512"},
+				{"cpu", "cpu.cfs_period_us", "100000"},
+				{"cpu", "cpu.cfs_quota_us", "150000"},
+			},
+		},
+		{
+			name:      "cpu quota and period",
+			subsystem: "cpu",
+			res:       &subsystems.ResourceConfig{CpuQuota: 20000, CpuPeriod: 50000},
+			want: []cgroupFile{
+				{"cpu", "cpu.cfs_period_us", "50000"},
+				{"cpu", "cpu.cfs_quota_us", "20000"},
+			},
+		},
+		{
+			name:      "cpu percent",
+			subsystem: "cpu",
+			res:       &subsystems.ResourceConfig{CpuCfsQuota: 20},
+			want: []cgroupFile{
+				{"cpu", "cpu.cfs_period_us", "100000"},
+				{"cpu", "cpu.cfs_quota_us", "20000"},
+			},
+		},
+		{
+			name:      "cpuset",
+			subsystem: "cpuset",
+			res:       &subsystems.ResourceConfig{CpuSet: "1"},
+			want: []cgroupFile{
+				{"cpuset", "cpuset.cpus", "1"},
+				{"cpuset", "cpuset.mems", "0"},
+			},
+		},
+		{
+			name:      "memory",
+			subsystem: "memory",
+			res:       &subsystems.ResourceConfig{MemoryLimit: "100m", MemoryReservation: "50m", MemorySwap: "200m"},
+			want: []cgroupFile{
+				{"memory", "memory.limit_in_bytes", "100m"},
+				{"memory", "memory.soft_limit_in_bytes", "50m"},
+				{"memory", "memory.memsw.limit_in_bytes", "200m"},
+			},
+		},
+		{
+			name:      "pids",
+			subsystem: "pids",
+			res:       &subsystems.ResourceConfig{PidsLimit: 100},
+			want:      []cgroupFile{{"pids", "pids.max", "100"}},
+		},
+		{
+			name:      "unlimited pids",
+			subsystem: "pids",
+			res:       &subsystems.ResourceConfig{PidsLimit: subsystems.PidsUnlimited},
+			want:      []cgroupFile{{"pids", "pids.max", "max"}},
+		},
+		{
+			name:      "blkio",
+			subsystem: "blkio",
+			res: &subsystems.ResourceConfig{
+				BlkioWeight:          500,
+				BlkioDeviceReadBps:   []*subsystems.ThrottleDevice{{Major: 8, Minor: 0, Rate: 1048576}},
+				BlkioDeviceWriteIOps: []*subsystems.ThrottleDevice{{Major: 8, Minor: 16, Rate: 1000}},
+			},
+			want: []cgroupFile{
+				{"blkio", "blkio.weight", "500"},
+				{"blkio", "blkio.throttle.read_bps_device", "8:0 1048576"},
+				{"blkio", "blkio.throttle.write_iops_device", "8:16 1000"},
+			},
+		},
+		{
+			name:      "devices",
+			subsystem: "devices",
+			res: &subsystems.ResourceConfig{Devices: []*subsystems.DeviceRule{
+				{Type: subsystems.DeviceTypeAll, Major: subsystems.DeviceWildcard, Minor: subsystems.DeviceWildcard, Permissions: "rwm"},
+				{Type: subsystems.DeviceTypeChar, Major: 136, Minor: subsystems.DeviceWildcard, Permissions: "rw", Allow: true},
+			}},
+			want: []cgroupFile{
+				{"devices", "devices.deny", "a"},
+				{"devices", "devices.allow", "c 136:* rw"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := cgrouptest.NewV1(t)
+			subSysIns := findSubsystem(t, subsystems.NewSubsystems(fake.Hierarchy), tt.subsystem)
+			if err := subSysIns.Set(testCgroupPath, tt.res); err != nil {
+				t.Fatal(err)
+			}
+			checkFiles(t, fake, testCgroupPath, tt.want)
+		})
+	}
+}
+
+func TestSubsystemsSetV2(t *testing.T) {
+	tests := []struct {
+		name      string
+		subsystem string
+		res       *subsystems.ResourceConfig
+		want      []cgroupFile
+	}{
+		{
+			name:      "cpus",
+			subsystem: "cpu",
+			res:       &subsystems.ResourceConfig{NanoCpus: 1500000000, CpuShare: "1024"},
+			want: []cgroupFile{
+				{"", "cpu.weight", "39"},
+				{"", "cpu.max", "150000 100000"},
+			},
+		},
+		{
+			name:      "unlimited cpu quota",
+			subsystem: "cpu",
+			res:       &subsystems.ResourceConfig{CpuQuota: -1},
+			want:      []cgroupFile{{"", "cpu.max", "max 100000"}},
+		},
+		{
+			name:      "cpuset",
+			subsystem: "cpuset",
+			res:       &subsystems.ResourceConfig{CpuSet: "0-1"},
+			want:      []cgroupFile{{"", "cpuset.cpus", "0-1"}},
+		},
+		{
+			name:      "memory",
+			subsystem: "memory",
+			res:       &subsystems.ResourceConfig{MemoryLimit: "100m", MemoryReservation: "50m", MemorySwap: "200m"},
+			want: []cgroupFile{
+				{"", "memory.max", "104857600"},
+				{"", "memory.low", "52428800"},
+				{"", "memory.swap.max", "104857600"},
+			},
+		},
+		{
+			name:      "unlimited swap",
+			subsystem: "memory",
+			res:       &subsystems.ResourceConfig{MemoryLimit: "100m", MemorySwap: "-1"},
+			want:      []cgroupFile{{"", "memory.swap.max", "max"}},
+		},
+		{
+			name:      "pids",
+			subsystem: "pids",
+			res:       &subsystems.ResourceConfig{PidsLimit: 100},
+			want:      []cgroupFile{{"", "pids.max", "100"}},
+		},
+		{
+			name:      "unlimited pids",
+			subsystem: "pids",
+			res:       &subsystems.ResourceConfig{PidsLimit: subsystems.PidsUnlimited},
+			want:      []cgroupFile{{"", "pids.max", "max"}},
+		},
+		{
+			name:      "io",
+			subsystem: "io",
+			res: &subsystems.ResourceConfig{
+				BlkioWeight:         500,
+				BlkioDeviceReadBps:  []*subsystems.ThrottleDevice{{Major: 8, Minor: 0, Rate: 1048576}},
+				BlkioDeviceReadIOps: []*subsystems.ThrottleDevice{{Major: 8, Minor: 0, Rate: 1000}},
+			},
+			want: []cgroupFile{
+				{"", "io.weight", "default 4950"},
+				{"", "io.max", "8:0 rbps=1048576 riops=1000"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := cgrouptest.NewV2(t)
+			subSysIns := findSubsystem(t, subsystems.NewSubsystemsV2(fake.Hierarchy), tt.subsystem)
+			if err := subSysIns.Set(testCgroupPath, tt.res); err != nil {
+				t.Fatal(err)
+			}
+			checkFiles(t, fake, testCgroupPath, tt.want)
+			// controller 需要在每一级父 cgroup 的 cgroup.subtree_control 中启用
+			checkFiles(t, fake, "", []cgroupFile{{"", "cgroup.subtree_control", "+" + tt.subsystem}})
+			checkFiles(t, fake, "mydocker", []cgroupFile{{"", "cgroup.subtree_control", "+" + tt.subsystem}})
+		})
+	}
+}
+
+func TestSubsystemsSetEmptyConfig(t *testing.T) {
+	fake := cgrouptest.NewV1(t)
+	for _, subSysIns := range subsystems.NewSubsystems(fake.Hierarchy) {
+		if err := subSysIns.Set(testCgroupPath, &subsystems.ResourceConfig{}); err != nil {
+			t.Fatalf("set %s: %v", subSysIns.Name(), err)
+		}
+		if fake.Exists(subSysIns.Name(), testCgroupPath, "") {
+			t.Errorf("%s: cgroup should not be created without limits", subSysIns.Name())
+		}
+	}
+}
+
+func TestSubsystemsApplyV1(t *testing.T) {
+	fake := cgrouptest.NewV1(t)
+	for _, subSysIns := range subsystems.NewSubsystems(fake.Hierarchy) {
+		if err := subSysIns.Apply(testCgroupPath, 1234, &subsystems.ResourceConfig{}); err != nil {
+			t.Fatalf("apply %s: %v", subSysIns.Name(), err)
+		}
+		checkFiles(t, fake, testCgroupPath, []cgroupFile{{subSysIns.Name(), "cgroup.procs", "1234"}})
+	}
+	// cpuset 加入进程前需要从父 cgroup 继承 cpuset.cpus 和 cpuset.mems，中间目录同样需要初始化
+	for _, cgroupPath := range []string{"mydocker", testCgroupPath} {
+		checkFiles(t, fake, cgroupPath, []cgroupFile{
+			{"cpuset", "cpuset.cpus", "0-3"},
+			{"cpuset", "cpuset.mems", "0"},
+		})
+	}
+}
+
+func TestSubsystemsApplyV2(t *testing.T) {
+	fake := cgrouptest.NewV2(t)
+	subSysIns := findSubsystem(t, subsystems.NewSubsystemsV2(fake.Hierarchy), "memory")
+	if err := subSysIns.Apply(testCgroupPath, 1234, &subsystems.ResourceConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, fake, testCgroupPath, []cgroupFile{{"", "cgroup.procs", "1234"}})
+	checkFiles(t, fake, "", []cgroupFile{{"", "cgroup.subtree_control", "+memory"}})
+}
+
+func TestFreezer(t *testing.T) {
+	t.Run("v1", func(t *testing.T) {
+		fake := cgrouptest.NewV1(t)
+		freezer := findSubsystem(t, subsystems.NewSubsystems(fake.Hierarchy), "freezer").(subsystems.Freezer)
+		fake.WriteFile("freezer", testCgroupPath, "freezer.state", "THAWED")
+		if err := freezer.Freeze(testCgroupPath, true); err != nil {
+			t.Fatal(err)
+		}
+		checkFiles(t, fake, testCgroupPath, []cgroupFile{{"freezer", "freezer.state", "FROZEN"}})
+	})
+	t.Run("v2", func(t *testing.T) {
+		fake := cgrouptest.NewV2(t)
+		freezer := findSubsystem(t, subsystems.NewSubsystemsV2(fake.Hierarchy), "freezer").(subsystems.Freezer)
+		// 伪造的层级不会更新 cgroup.events，这里预先写入冻结完成后的状态
+		fake.WriteFile("", testCgroupPath, "cgroup.events", "populated 1\nfrozen 1\n")
+		if err := freezer.Freeze(testCgroupPath, true); err != nil {
+			t.Fatal(err)
+		}
+		checkFiles(t, fake, testCgroupPath, []cgroupFile{{"", "cgroup.freeze", "1"}})
+	})
+}
+
+func TestHierarchyMountpoint(t *testing.T) {
+	if cgrouptest.NewV1(t).IsCgroup2UnifiedMode() {
+		t.Error("v1 hierarchy should not be unified mode")
+	}
+	if !cgrouptest.NewV2(t).IsCgroup2UnifiedMode() {
+		t.Error("v2 hierarchy should be unified mode")
+	}
+}
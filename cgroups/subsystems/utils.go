@@ -1,73 +1,85 @@
 package subsystems
 
 import (
-	"bufio"
+	"math"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 
 	"mydocker/constant"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/pkg/errors"
 )
 
-const mountPointIndex = 4
-
 /*
  * getCgroupPath 找到 cgroup 在文件系统中的绝对路径
  * 实际就是将根目录和 cgroup 名称拼接成一个路径。
  * 如果指定了自动创建，就先检测一下是否存在，如果对应的目录不存在，则说明 cgroup 不存在，这里就给创建一个
  */
-func getCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string, error) {
+func (b *cgroupBase) getCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string, error) {
 	// 不需要自动创建就直接返回
-	cgroupRoot := findCgroupMountpoint(subsystem)
+	cgroupRoot := b.hierarchyOrDefault().findCgroupMountpoint(subsystem)
+	// 找不到挂载点时 cgroupRoot 为空，拼接出来的就是相对路径了，这里直接报错，避免误操作当前目录
+	if cgroupRoot == "" {
+		return "", errors.Errorf("cgroup subsystem %s is not mounted", subsystem)
+	}
 	absPath := path.Join(cgroupRoot, cgroupPath)
 	if !autoCreate {
 		return absPath, nil
 	}
 	// 指定自动创建时才判断是否存在
 	_, err := os.Stat(absPath)
-	// 只有不存在时才创建
+	// 只有不存在时才创建，cgroupPath 可能是多级目录（如 mydocker/{containerId}），因此需要级联创建
 	if err != nil && os.IsNotExist(err) {
-		err = os.Mkdir(absPath, constant.Perm0755)
+		err = os.MkdirAll(absPath, constant.Perm0755)
 		return absPath, err
 	}
 	// 其他错误或者没有错误都直接返回，如果 err=nil，那么 errors.Wrap(err, "") 也会是 nil
 	return absPath, errors.Wrap(err, "create cgroup")
 }
 
-// findCgroupMountpoint 通过 /proc/self/mountinfo 找出挂载了某个 subsystem 的 hierarchy cgroup 根节点所在的目录
-func findCgroupMountpoint(subsystem string) string {
-	//  /proc/self/mountinfo 为当前进程的 mountinfo 信息
-	// 可以直接通过 cat /proc/self/mountinfo 命令查看
-	f, err := os.Open("/proc/self/mountinfo")
+// IsCgroup2UnifiedMode 判断当前主机是否只挂载了 cgroup v2
+func IsCgroup2UnifiedMode() bool {
+	return DefaultHierarchy.IsCgroup2UnifiedMode()
+}
+
+// readUint 读取 cgroup 文件中的单个无符号整数，比如 memory.usage_in_bytes
+// cgroup v2 中不限制时文件内容为 max，这里统一转换成 math.MaxUint64
+func readUint(file string) (uint64, error) {
+	content, err := os.ReadFile(file)
 	if err != nil {
-		return ""
+		return 0, err
 	}
-	defer f.Close()
-	// 这里主要根据各种字符串处理来找到目标位置
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		// txt 大概是这样的：104 85 0:20 / /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime - cgroup cgroup rw,memory
-		txt := scanner.Text()
-		// 然后按照空格分割
-		fields := strings.Split(txt, " ")
-		// 对最后一个元素按逗号进行分割，这里的最后一个元素就是 rw,memory
-		// 其中的的 memory 就表示这是一个 memory subsystem
-		subsystems := strings.Split(fields[len(fields)-1], ",")
-		for _, opt := range subsystems {
-			if opt == subsystem {
-				// 如果等于指定的 subsystem，那么就返回这个挂载点跟目录，就是第四个元素，
-				// 这里就是`/sys/fs/cgroup/memory`,即我们要找的根目录
-				return fields[mountPointIndex]
-			}
-		}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return math.MaxUint64, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// readPidsStats 读取 pids.current 和 pids.max，v1 和 v2 中文件名与格式都是一致的
+func readPidsStats(subsysCgroupPath string, stats *Stats) error {
+	var err error
+	if stats.PidsCurrent, err = readUint(path.Join(subsysCgroupPath, "pids.current")); err != nil {
+		return errors.Wrap(err, "read pids current")
 	}
-	if err = scanner.Err(); err != nil {
-		log.Error("read err: ", err)
-		return ""
+	stats.PidsLimit, err = readUint(path.Join(subsysCgroupPath, "pids.max"))
+	return errors.Wrap(err, "read pids limit")
+}
+
+// readKeyValue 读取 cgroup 中 flat keyed 格式文件中指定 key 的值，比如 memory.events、cpu.stat
+// 文件每行的格式为 "{key} {value}"
+func readKeyValue(file, key string) (uint64, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
 	}
-	return ""
+	return 0, errors.Errorf("key %s not found in %s", key, file)
 }
@@ -0,0 +1,95 @@
+package subsystems
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	cgroupProcs          = "cgroup.procs"
+	cgroupSubtreeControl = "cgroup.subtree_control"
+)
+
+/*
+ * getCgroupPathV2 找到 cgroup v2 中 cgroup 在文件系统中的绝对路径
+ * v2 中所有 subsystem（v2 里称为 controller）共用同一棵树，因此不需要按 subsystem 区分挂载点
+ */
+func (b *cgroupBase) getCgroupPathV2(cgroupPath string, autoCreate bool) (string, error) {
+	cgroupRoot := b.hierarchyOrDefault().findCgroup2Mountpoint()
+	if cgroupRoot == "" {
+		return "", errors.New("cgroup2 is not mounted")
+	}
+	absPath := path.Join(cgroupRoot, cgroupPath)
+	if !autoCreate {
+		return absPath, nil
+	}
+	_, err := os.Stat(absPath)
+	if err != nil && os.IsNotExist(err) {
+		err = os.MkdirAll(absPath, constant.Perm0755)
+		return absPath, err
+	}
+	return absPath, errors.Wrap(err, "create cgroup")
+}
+
+/*
+ * enableController 在 cgroup v2 中为 cgroupPath 启用指定的 controller
+ * v2 中子 cgroup 能使用哪些 controller 由父 cgroup 的 cgroup.subtree_control 决定，
+ * 因此需要从根节点开始，逐级向 cgroup.subtree_control 写入 +{controller}
+ * 比如 cgroupPath 为 mydocker/123，需要写入 /sys/fs/cgroup/cgroup.subtree_control 和 /sys/fs/cgroup/mydocker/cgroup.subtree_control
+ * rootless 模式下普通用户没有权限修改被委派的 cgroup 之上的祖先节点，这些节点中的 controller 由 systemd 负责启用，跳过即可
+ */
+func (b *cgroupBase) enableController(cgroupPath, controller string) error {
+	cgroupRoot := b.hierarchyOrDefault().findCgroup2Mountpoint()
+	if cgroupRoot == "" {
+		return errors.New("cgroup2 is not mounted")
+	}
+	current := cgroupRoot
+	for _, dir := range strings.Split(path.Clean(cgroupPath), "/") {
+		if err := os.WriteFile(path.Join(current, cgroupSubtreeControl),
+			[]byte("+"+controller),
+			constant.Perm0644); err != nil && !os.IsPermission(err) {
+			return errors.Wrapf(err, "enable controller %s in %s", controller, current)
+		}
+		current = path.Join(current, dir)
+	}
+	return nil
+}
+
+/*
+ * applyV2 将进程 PID 加入到 cgroup v2 中，v2 中使用 cgroup.procs 替代了 v1 的 tasks
+ * 即使没有设置限制也会启用对应的 controller，这样 stats 才能读取到 memory.current 等文件，update 也可以直接修改限制
+ * controller 启用失败（比如没有被委派）不影响进程加入 cgroup
+ */
+func (b *cgroupBase) applyV2(cgroupPath, controller string, pid int) error {
+	absPath, err := b.getCgroupPathV2(cgroupPath, true)
+	if err != nil {
+		return errors.Wrapf(err, "get cgroup %s", cgroupPath)
+	}
+	enableErr := b.enableController(cgroupPath, controller)
+	err = os.WriteFile(path.Join(absPath, cgroupProcs),
+		[]byte(strconv.Itoa(pid)),
+		constant.Perm0644)
+	if err != nil {
+		return errors.Wrap(err, "set cgroup proc fail")
+	}
+	return enableErr
+}
+
+// removeV2 删除 cgroup v2 中的 cgroup
+// v2 中各个 controller 共用同一个目录，因此目录已经被其他 controller 删除时直接返回
+func (b *cgroupBase) removeV2(cgroupPath string) error {
+	absPath, err := b.getCgroupPathV2(cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(absPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// capAll --cap-add、--cap-drop 中表示所有 capability 的特殊值
+const capAll = "ALL"
+
+// capLastCapPath 内核支持的最大 capability 编号
+const capLastCapPath = "/proc/sys/kernel/cap_last_cap"
+
+// capabilityValues capability 名称与 CAP_* 常量的对应关系
+var capabilityValues = map[string]int{
+	"CAP_CHOWN":              unix.CAP_CHOWN,
+	"CAP_DAC_OVERRIDE":       unix.CAP_DAC_OVERRIDE,
+	"CAP_DAC_READ_SEARCH":    unix.CAP_DAC_READ_SEARCH,
+	"CAP_FOWNER":             unix.CAP_FOWNER,
+	"CAP_FSETID":             unix.CAP_FSETID,
+	"CAP_KILL":               unix.CAP_KILL,
+	"CAP_SETGID":             unix.CAP_SETGID,
+	"CAP_SETUID":             unix.CAP_SETUID,
+	"CAP_SETPCAP":            unix.CAP_SETPCAP,
+	"CAP_LINUX_IMMUTABLE":    unix.CAP_LINUX_IMMUTABLE,
+	"CAP_NET_BIND_SERVICE":   unix.CAP_NET_BIND_SERVICE,
+	"CAP_NET_BROADCAST":      unix.CAP_NET_BROADCAST,
+	"CAP_NET_ADMIN":          unix.CAP_NET_ADMIN,
+	"CAP_NET_RAW":            unix.CAP_NET_RAW,
+	"CAP_IPC_LOCK":           unix.CAP_IPC_LOCK,
+	"CAP_IPC_OWNER":          unix.CAP_IPC_OWNER,
+	"CAP_SYS_MODULE":         unix.CAP_SYS_MODULE,
+	"CAP_SYS_RAWIO":          unix.CAP_SYS_RAWIO,
+	"CAP_SYS_CHROOT":         unix.CAP_SYS_CHROOT,
+	"CAP_SYS_PTRACE":         unix.CAP_SYS_PTRACE,
+	"CAP_SYS_PACCT":          unix.CAP_SYS_PACCT,
+	"CAP_SYS_ADMIN":          unix.CAP_SYS_ADMIN,
+	"CAP_SYS_BOOT":           unix.CAP_SYS_BOOT,
+	"CAP_SYS_NICE":           unix.CAP_SYS_NICE,
+	"CAP_SYS_RESOURCE":       unix.CAP_SYS_RESOURCE,
+	"CAP_SYS_TIME":           unix.CAP_SYS_TIME,
+	"CAP_SYS_TTY_CONFIG":     unix.CAP_SYS_TTY_CONFIG,
+	"CAP_MKNOD":              unix.CAP_MKNOD,
+	"CAP_LEASE":              unix.CAP_LEASE,
+	"CAP_AUDIT_WRITE":        unix.CAP_AUDIT_WRITE,
+	"CAP_AUDIT_CONTROL":      unix.CAP_AUDIT_CONTROL,
+	"CAP_SETFCAP":            unix.CAP_SETFCAP,
+	"CAP_MAC_OVERRIDE":       unix.CAP_MAC_OVERRIDE,
+	"CAP_MAC_ADMIN":          unix.CAP_MAC_ADMIN,
+	"CAP_SYSLOG":             unix.CAP_SYSLOG,
+	"CAP_WAKE_ALARM":         unix.CAP_WAKE_ALARM,
+	"CAP_BLOCK_SUSPEND":      unix.CAP_BLOCK_SUSPEND,
+	"CAP_AUDIT_READ":         unix.CAP_AUDIT_READ,
+	"CAP_PERFMON":            unix.CAP_PERFMON,
+	"CAP_BPF":                unix.CAP_BPF,
+	"CAP_CHECKPOINT_RESTORE": unix.CAP_CHECKPOINT_RESTORE,
+}
+
+// DefaultCapabilities 容器默认拥有的 capability，与 docker 保持一致
+var DefaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+/*
+ * ParseCapabilities 根据 --cap-add、--cap-drop 和 --privileged 计算容器拥有的 capability，按编号排序
+ * 1. 名称不区分大小写，可以省略 CAP_ 前缀，e.g. net_admin、CAP_NET_ADMIN
+ * 2. 与 docker 一致，先从默认集合中删除 --cap-drop 指定的 capability，再加入 --cap-add 指定的，ALL 表示所有 capability
+ * 3. --privileged 时拥有所有 capability，忽略 --cap-add 和 --cap-drop
+ */
+func ParseCapabilities(add, drop []string, privileged bool) ([]string, error) {
+	if privileged {
+		return sortCapabilities(allCapabilities()), nil
+	}
+	caps := make(map[string]bool)
+	for _, name := range DefaultCapabilities {
+		caps[name] = true
+	}
+	for _, name := range drop {
+		name, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		if name == capAll {
+			caps = make(map[string]bool)
+			continue
+		}
+		delete(caps, name)
+	}
+	for _, name := range add {
+		name, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		if name == capAll {
+			for _, capName := range allCapabilities() {
+				caps[capName] = true
+			}
+			continue
+		}
+		caps[name] = true
+	}
+	result := make([]string, 0, len(caps))
+	for name := range caps {
+		result = append(result, name)
+	}
+	return sortCapabilities(result), nil
+}
+
+// normalizeCapability 将 capability 名称统一为 CAP_ 开头的大写形式
+func normalizeCapability(name string) (string, error) {
+	name = strings.ToUpper(name)
+	if name == capAll {
+		return name, nil
+	}
+	if !strings.HasPrefix(name, "CAP_") {
+		name = "CAP_" + name
+	}
+	if _, ok := capabilityValues[name]; !ok {
+		return "", fmt.Errorf("unknown capability %s", name)
+	}
+	return name, nil
+}
+
+// allCapabilities 返回所有已知的 capability
+func allCapabilities() []string {
+	caps := make([]string, 0, len(capabilityValues))
+	for name := range capabilityValues {
+		caps = append(caps, name)
+	}
+	return caps
+}
+
+// sortCapabilities 按 capability 编号排序
+func sortCapabilities(caps []string) []string {
+	sort.Slice(caps, func(i, j int) bool {
+		return capabilityValues[caps[i]] < capabilityValues[caps[j]]
+	})
+	return caps
+}
+
+// lastCap 返回内核支持的最大 capability 编号，读取失败时使用编译时已知的最大编号
+func lastCap() int {
+	content, err := os.ReadFile(capLastCapPath)
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	return last
+}
+
+/*
+ * setUserAndCapabilities 切换用户并将进程的 capability 限制为 config.Capabilities，exec 之后用户进程只拥有这些 capability
+ * 1. 先从 bounding 集合中删除其他 capability，之后即使执行 setuid 程序也无法再获得，删除需要 CAP_SETPCAP，因此要在切换用户之前完成
+ * 2. 切换用户，切换为非 root 用户时内核会清空 permitted、effective 和 ambient 集合
+ * 3. 用户为 root 时将 effective、permitted、inheritable 集合设置为 config.Capabilities 并设置 ambient 集合，
+ *    非 root 用户与 docker、runc 一致，只保留 bounding 集合，否则 --user 指定的用户可以通过 CAP_SETUID 等重新切换为 root
+ * 没有记录 capability 的旧容器使用默认集合
+ */
+func setUserAndCapabilities(config *InitConfig) error {
+	names := config.Capabilities
+	if names == nil {
+		names = DefaultCapabilities
+	}
+	last := lastCap()
+	var caps []int
+	for _, name := range names {
+		value, ok := capabilityValues[name]
+		if !ok {
+			return fmt.Errorf("unknown capability %s", name)
+		}
+		if value > last {
+			log.Warnf("capability %s is not supported by the kernel, ignore it", name)
+			continue
+		}
+		// 进程无法获得 bounding 集合之外的 capability，e.g. mydocker 本身运行在受限的环境中
+		if inBounding, err := unix.PrctlRetInt(unix.PR_CAPBSET_READ, uintptr(value), 0, 0, 0); err == nil && inBounding == 0 {
+			log.Warnf("capability %s is not in the bounding set of mydocker, ignore it", name)
+			continue
+		}
+		caps = append(caps, value)
+	}
+
+	keep := make(map[int]bool, len(caps))
+	for _, c := range caps {
+		keep[c] = true
+	}
+	for c := 0; c <= last; c++ {
+		if keep[c] {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+			return errors.Wrapf(err, "drop capability %d from bounding set", c)
+		}
+	}
+
+	if err := setUser(config.User); err != nil {
+		return err
+	}
+	if unix.Getuid() != 0 {
+		caps = nil
+	}
+
+	// capability 集合是 64 位的，v3 版本的接口分为两个 32 位的 CapUserData，非 root 用户时全部清空
+	var data [2]unix.CapUserData
+	for _, c := range caps {
+		data[c/32].Effective |= 1 << uint(c%32)
+		data[c/32].Permitted |= 1 << uint(c%32)
+		data[c/32].Inheritable |= 1 << uint(c%32)
+	}
+	header := &unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	if err := unix.Capset(header, &data[0]); err != nil {
+		return errors.Wrap(err, "capset")
+	}
+
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return errors.Wrap(err, "clear ambient capabilities")
+	}
+	for _, c := range caps {
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, uintptr(c), 0, 0); err != nil {
+			return errors.Wrapf(err, "raise ambient capability %d", c)
+		}
+	}
+	return nil
+}
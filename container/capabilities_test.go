@@ -0,0 +1,144 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// capsHelperEnv 设置该环境变量时，测试进程作为容器 init 进程切换为该变量指定的用户，设置 capability 和 no_new_privs 后 exec cat /proc/self/status
+const capsHelperEnv = "MYDOCKER_TEST_CAPS_HELPER"
+
+func TestParseCapabilities(t *testing.T) {
+	tests := []struct {
+		name       string
+		add, drop  []string
+		privileged bool
+		want       []string
+		wantErr    bool
+	}{
+		{name: "default", want: sortCapabilities(append([]string(nil), DefaultCapabilities...))},
+		{name: "drop all", drop: []string{"all"}, want: []string{}},
+		{name: "drop all add one", drop: []string{"ALL"}, add: []string{"net_bind_service"}, want: []string{"CAP_NET_BIND_SERVICE"}},
+		{name: "add and drop", add: []string{"CAP_NET_ADMIN"}, drop: []string{"mknod", "chown"}, want: []string{
+			"CAP_DAC_OVERRIDE", "CAP_FOWNER", "CAP_FSETID", "CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP",
+			"CAP_NET_BIND_SERVICE", "CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_SYS_CHROOT", "CAP_AUDIT_WRITE", "CAP_SETFCAP",
+		}},
+		{name: "unknown", add: []string{"fly"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCapabilities(tt.add, tt.drop, tt.privileged)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// --privileged 忽略 --cap-drop，并且与 --cap-add ALL 的结果相同
+	privileged, _ := ParseCapabilities(nil, []string{"ALL"}, true)
+	addAll, _ := ParseCapabilities([]string{"ALL"}, nil, false)
+	if len(privileged) != len(capabilityValues) || !reflect.DeepEqual(privileged, addAll) {
+		t.Errorf("privileged: got %v, want all capabilities %v", privileged, addAll)
+	}
+}
+
+// TestCapabilitiesHelperProcess 模拟容器 init 进程，只在 TestCapabilitiesAfterExec 启动的子进程中运行
+func TestCapabilitiesHelperProcess(t *testing.T) {
+	if os.Getenv(capsHelperEnv) == "" {
+		t.Skip("helper process")
+	}
+	// 与 RunContainerInitProcess 一样将 goroutine 固定在当前线程上直到 exec
+	runtime.LockOSThread()
+	// 让其他线程保持忙碌，没有固定线程时 exec 很可能发生在没有设置 capability 的线程上
+	for i := 0; i < 8; i++ {
+		go func() {
+			for {
+				runtime.Gosched()
+			}
+		}()
+	}
+	config := &InitConfig{
+		Capabilities: []string{"CAP_CHOWN", "CAP_KILL", "CAP_NET_BIND_SERVICE"},
+		User:         os.Getenv(capsHelperEnv),
+	}
+	if err := setUserAndCapabilities(config); err != nil {
+		os.Stderr.WriteString(err.Error())
+		os.Exit(1)
+	}
+	if err := setNoNewPrivileges(); err != nil {
+		os.Stderr.WriteString(err.Error())
+		os.Exit(1)
+	}
+	// init 进程在设置 capability 之后 exec 之前还会安装 seccomp 等，期间 goroutine 可能被调度到其他线程上
+	// 阻塞的系统调用会让出 P，返回时 goroutine 可能在另一个线程上继续运行
+	for i := 0; i < 10; i++ {
+		_ = syscall.Nanosleep(&syscall.Timespec{Nsec: int64(time.Millisecond)}, nil)
+	}
+	err := syscall.Exec("/bin/cat", []string{"cat", "/proc/self/status"}, os.Environ())
+	os.Stderr.WriteString(err.Error())
+	os.Exit(1)
+}
+
+// TestCapabilitiesAfterExec 检查 exec 之后的用户进程中 capability 集合只包含配置的 capability，并且设置了 no_new_privs
+// 非 root 用户只保留 bounding 集合，不能拥有任何 capability
+func TestCapabilitiesAfterExec(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("requires root")
+	}
+	caps := uint64(1)<<capabilityValues["CAP_CHOWN"] | 1<<capabilityValues["CAP_KILL"] |
+		1<<capabilityValues["CAP_NET_BIND_SERVICE"]
+	tests := []struct {
+		user string
+		want map[string]uint64
+	}{
+		{user: "0", want: map[string]uint64{"CapEff": caps, "CapPrm": caps, "CapBnd": caps, "CapAmb": caps}},
+		{user: "65534:65534", want: map[string]uint64{"CapInh": 0, "CapEff": 0, "CapPrm": 0, "CapBnd": caps, "CapAmb": 0}},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestCapabilitiesHelperProcess$")
+		cmd.Env = append(os.Environ(), capsHelperEnv+"="+tt.user, "GOMAXPROCS=4")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: helper process: %v: %s", tt.user, err, stderr.String())
+		}
+
+		status := make(map[string]string)
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		for scanner.Scan() {
+			if key, value, ok := strings.Cut(scanner.Text(), ":"); ok {
+				status[key] = strings.TrimSpace(value)
+			}
+		}
+		for key, want := range tt.want {
+			got, err := strconv.ParseUint(status[key], 16, 64)
+			if err != nil {
+				t.Fatalf("%s: parse %s %q: %v", tt.user, key, status[key], err)
+			}
+			if got != want {
+				t.Errorf("%s: %s: got %016x, want %016x", tt.user, key, got, want)
+			}
+		}
+		if status["NoNewPrivs"] != "1" {
+			t.Errorf("%s: NoNewPrivs: got %q, want 1", tt.user, status["NoNewPrivs"])
+		}
+	}
+}
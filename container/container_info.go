@@ -1,64 +1,85 @@
 package container
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"math/rand"
 	"os"
 	"path"
-	"strconv"
 	"strings"
 	"time"
 
 	"mydocker/constant"
+	"mydocker/store"
+	"mydocker/utils"
 
 	"github.com/pkg/errors"
 )
 
-func RecordContainerInfo(containerPid int, cmdArray, portMapping []string,
-	containerName, containerId, volume, networkName, ip string) (*Info, error) {
-	// 如果未指定容器名，则使用随机生成的 containerID
-	if containerName == "" {
-		containerName = containerId
-	}
-	cmd := strings.Join(cmdArray, "")
-	containerInfo := &Info{
-		Pid:         strconv.Itoa(containerPid),
-		Id:          containerId,
-		Name:        containerName,
-		Command:     cmd,
-		CreatedTime: time.Now().Format("2006-01-02 15:04:05"),
-		Status:      RUNNING,
-		Volume:      volume,
-		NetworkName: networkName,
-		PortMapping: portMapping,
-		IP:          ip,
-	}
-
-	// 将容器信息序列化为 json 字符串
-	jsonBytes, err := json.Marshal(containerInfo)
-	if err != nil {
-		return containerInfo, errors.WithMessage(err, "container info marshal failed")
-	}
-	jsonStr := string(jsonBytes)
+// infoSchemaVersion 容器信息文件的格式版本，修改 Info 的字段格式时需要增加版本号并在 infoStore 中注册迁移函数
+const infoSchemaVersion = 1
 
-	// 拼接出存储容器信息文件的路径，如果目录不存在则级联创建
-	dirPath := GetConfigDirPath(containerId)
-	if err = os.MkdirAll(dirPath, constant.Perm0622); err != nil {
-		return containerInfo, errors.WithMessagef(err, "mkdir %s failed", dirPath)
+// namesLockFile 创建容器时持有的锁，保证并发创建同名的容器时只有一个能成功
+const namesLockFile = "names"
+
+var infoStore = store.New(infoSchemaVersion, nil)
+
+// RecordContainerInfo 记录新创建的容器信息，此时容器进程还没有启动，状态为 created
+func RecordContainerInfo(containerInfo *Info) error {
+	// 如果未指定容器名，则使用短格式的容器 Id
+	if containerInfo.Name == "" {
+		containerInfo.Name = ShortID(containerInfo.Id)
+	}
+	if err := os.MkdirAll(InfoLoc, constant.Perm0755); err != nil {
+		return errors.WithMessagef(err, "mkdir %s failed", InfoLoc)
 	}
-	// 将容器信息写入文件
-	fileName := path.Join(dirPath, ConfigName)
-	file, err := os.Create(fileName)
+	unlock, err := infoStore.Lock(path.Join(InfoLoc, namesLockFile))
 	if err != nil {
-		return containerInfo, errors.WithMessagef(err, "create file %s failed", fileName)
+		return err
 	}
-	defer file.Close()
-	if _, err = file.WriteString(jsonStr); err != nil {
-		return containerInfo, errors.WithMessagef(err, "write container info to file %s failed", fileName)
+	defer unlock()
+	// 容器名可以代替容器 Id 使用，因此不能重复
+	if owner, err := getInfoByName(containerInfo.Name); err == nil {
+		return fmt.Errorf("container name %s is already in use by container %s", containerInfo.Name, owner.Id)
 	}
+	containerInfo.CreatedTime = time.Now().Format(TimeFormat)
+	containerInfo.Status = CREATED
 
-	return containerInfo, nil
+	// 拼接出存储容器信息文件的路径，容器目录已经存在说明 Id 冲突，不能覆盖其他容器的信息
+	dirPath := GetConfigDirPath(containerInfo.Id)
+	if err = os.Mkdir(dirPath, constant.Perm0755); err != nil {
+		return errors.WithMessagef(err, "mkdir %s failed", dirPath)
+	}
+	return saveContainerInfo(containerInfo)
+}
+
+// saveContainerInfo 将容器信息写入配置文件，会覆盖其他进程的并发修改，只用于创建容器时写入，之后的修改都应该使用 ModifyContainerInfo
+func saveContainerInfo(containerInfo *Info) error {
+	configFilePath := GetConfigFilePath(containerInfo.Id)
+	if err := infoStore.Save(configFilePath, containerInfo, constant.Perm0622); err != nil {
+		return errors.WithMessagef(err, "write container info to file %s failed", configFilePath)
+	}
+	return nil
+}
+
+// ModifyContainerInfo 持有容器的锁读取最新的容器信息，调用 fn 修改后写回，返回修改后的容器信息
+// fn 返回错误时不会写回，容器已经被删除时返回错误
+func ModifyContainerInfo(containerId string, fn func(info *Info) error) (*Info, error) {
+	configFilePath := GetConfigFilePath(containerId)
+	var containerInfo Info
+	err := infoStore.Update(configFilePath, &containerInfo, constant.Perm0622, func() error {
+		if containerInfo.Id == "" {
+			return fmt.Errorf("no such container: %s", containerId)
+		}
+		return fn(&containerInfo)
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no such container: %s", containerId)
+		}
+		return nil, err
+	}
+	return &containerInfo, nil
 }
 
 func DeleteContainerInfo(containerId string) error {
@@ -69,19 +90,34 @@ func DeleteContainerInfo(containerId string) error {
 	return nil
 }
 
-func GenerateContainerID() string {
-	return randStringBytes(IDLength)
+/*
+ * GenerateContainerID 生成 64 位十六进制的随机容器 Id
+ * 使用 crypto/rand 作为随机源，同一时刻创建的容器也不会生成相同的 Id
+ * 极端情况下生成的 Id 已经存在对应的容器目录时重新生成
+ */
+func GenerateContainerID() (string, error) {
+	b := make([]byte, IDLength/2)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return "", errors.Wrap(err, "read random bytes")
+		}
+		containerId := hex.EncodeToString(b)
+		exist, err := utils.PathExists(GetConfigDirPath(containerId))
+		if err != nil {
+			return "", errors.WithMessagef(err, "check container %s exist failed", containerId)
+		}
+		if !exist {
+			return containerId, nil
+		}
+	}
 }
 
-func randStringBytes(n int) string {
-	letterBytes := "1234567890"
-	randSource := rand.NewSource(time.Now().UnixNano())
-	r := rand.New(randSource)
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[r.Intn(len(letterBytes))]
+// ShortID 返回容器 Id 的短格式，用于展示以及作为默认的容器名
+func ShortID(containerId string) string {
+	if len(containerId) > ShortIDLength {
+		return containerId[:ShortIDLength]
 	}
-	return string(b)
+	return containerId
 }
 
 func GetLogFileName(containerId string) string {
@@ -100,13 +136,9 @@ func GetConfigFilePath(containerId string) string {
 
 func GetInfoByContainerId(containerId string) (*Info, error) {
 	configFilePath := GetConfigFilePath(containerId)
-	contentBytes, err := os.ReadFile(configFilePath)
-	if err != nil {
-		return nil, errors.Wrapf(err, "read file %s", configFilePath)
-	}
 	var containerInfo Info
-	if err = json.Unmarshal(contentBytes, &containerInfo); err != nil {
-		return nil, err
+	if err := infoStore.Load(configFilePath, &containerInfo); err != nil {
+		return nil, errors.Wrapf(err, "read file %s", configFilePath)
 	}
 	return &containerInfo, nil
 }
@@ -115,3 +147,95 @@ func GetPidByContainerId(containerId string) (string, error) {
 	containerInfo, err := GetInfoByContainerId(containerId)
 	return containerInfo.Pid, err
 }
+
+/*
+ * ResolveContainerId 将命令行中的容器引用解析为完整的容器 Id，引用可以是完整的容器 Id、容器名或者容器 Id 的前缀
+ * 1. 优先按完整 Id 查找，其次是容器名，最后是 Id 前缀
+ * 2. 前缀匹配到多个容器时返回错误，需要输入更长的前缀
+ */
+func ResolveContainerId(ref string) (string, error) {
+	if ref == "" || strings.Contains(ref, "/") || ref == "." || ref == ".." {
+		return "", fmt.Errorf("invalid container reference %q", ref)
+	}
+	if _, err := os.Stat(GetConfigFilePath(ref)); err == nil {
+		return ref, nil
+	}
+	infos, err := listContainerInfos()
+	if err != nil {
+		return "", err
+	}
+	info, err := matchContainer(ref, infos)
+	if err != nil {
+		return "", err
+	}
+	return info.Id, nil
+}
+
+// matchContainer 在容器列表中按容器 Id、容器名、Id 前缀的顺序查找容器
+func matchContainer(ref string, infos []*Info) (*Info, error) {
+	var byName *Info
+	var byPrefix []*Info
+	for _, info := range infos {
+		if info.Id == ref {
+			return info, nil
+		}
+		if info.Name == ref {
+			byName = info
+		}
+		if strings.HasPrefix(info.Id, ref) {
+			byPrefix = append(byPrefix, info)
+		}
+	}
+	if byName != nil {
+		return byName, nil
+	}
+	switch len(byPrefix) {
+	case 0:
+		return nil, fmt.Errorf("no such container: %s", ref)
+	case 1:
+		return byPrefix[0], nil
+	default:
+		ids := make([]string, 0, len(byPrefix))
+		for _, info := range byPrefix {
+			ids = append(ids, info.Id)
+		}
+		return nil, fmt.Errorf("container reference %s is ambiguous, it matches %s", ref, strings.Join(ids, ", "))
+	}
+}
+
+// getInfoByName 根据容器名查找容器
+func getInfoByName(name string) (*Info, error) {
+	infos, err := listContainerInfos()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.Name == name {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("no such container: %s", name)
+}
+
+// listContainerInfos 读取所有容器的信息，读取失败的容器会被跳过
+func listContainerInfos() ([]*Info, error) {
+	entries, err := os.ReadDir(InfoLoc)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "read dir %s", InfoLoc)
+	}
+	infos := make([]*Info, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := GetInfoByContainerId(entry.Name())
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
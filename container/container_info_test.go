@@ -0,0 +1,46 @@
+package container
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchContainer(t *testing.T) {
+	infos := []*Info{
+		{Id: "1234567890", Name: "web"},
+		{Id: "1234500000", Name: "1234567890"},
+		{Id: "9876543210", Name: "9876543210"},
+		{Id: "5550000000", Name: "98"},
+	}
+	tests := []struct {
+		ref     string
+		wantId  string
+		wantErr string
+	}{
+		// 完整 Id 优先于同名的容器名
+		{ref: "1234567890", wantId: "1234567890"},
+		{ref: "web", wantId: "1234567890"},
+		// 容器名优先于 Id 前缀
+		{ref: "98", wantId: "5550000000"},
+		{ref: "98765", wantId: "9876543210"},
+		{ref: "12345", wantErr: "ambiguous"},
+		{ref: "123456", wantId: "1234567890"},
+		{ref: "db", wantErr: "no such container"},
+	}
+	for _, tt := range tests {
+		info, err := matchContainer(tt.ref, infos)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: got error %v, want %q", tt.ref, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.ref, err)
+			continue
+		}
+		if info.Id != tt.wantId {
+			t.Errorf("%q: got %s, want %s", tt.ref, info.Id, tt.wantId)
+		}
+	}
+}
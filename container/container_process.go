@@ -6,6 +6,7 @@ import (
 	"path"
 	"syscall"
 
+	"mydocker/cgroups/subsystems"
 	"mydocker/constant"
 	"mydocker/utils"
 
@@ -13,27 +14,52 @@ import (
 )
 
 const (
+	CREATED       = "created"
+	STARTING      = "starting"
 	RUNNING       = "running"
 	STOP          = "stopped"
 	Exit          = "exited"
-	InfoLoc       = "/var/lib/mydocker/containers/"
-	InfoLocFormat = InfoLoc + "%s/"
+	PAUSED        = "paused"
+	RESTARTING    = "restarting"
 	ConfigName    = "config.json"
-	IDLength      = 10
+	IDLength      = 64
+	ShortIDLength = 12
 	LogFile       = "%s-json.log"
+	TimeFormat    = "2006-01-02 15:04:05"
+)
+
+var (
+	InfoLoc       = utils.DataRoot + "/containers/"
+	InfoLocFormat = InfoLoc + "%s/"
 )
 
 type Info struct {
-	Pid         string   `json:"pid"`         // 容器的init进程在宿主机上的 PID
-	Id          string   `json:"id"`          // 容器Id
-	Name        string   `json:"name"`        // 容器名
-	Command     string   `json:"command"`     // 容器内init运行命令
-	CreatedTime string   `json:"createTime"`  // 创建时间
-	Status      string   `json:"status"`      // 容器的状态
-	Volume      string   `json:"volume"`      // 容器的数据卷
-	NetworkName string   `json:"networkName"` // 容器所在的网络
-	PortMapping []string `json:"portMapping"` // 端口映射
-	IP          string   `json:"ip"`
+	Pid           string                     `json:"pid"`         // 容器的init进程在宿主机上的 PID
+	Id            string                     `json:"id"`          // 容器Id
+	Name          string                     `json:"name"`        // 容器名
+	Command       string                     `json:"command"`     // 容器内init运行命令
+	CreatedTime   string                     `json:"createTime"`  // 创建时间
+	Status        string                     `json:"status"`      // 容器的状态
+	Volume        string                     `json:"volume"`      // 容器的数据卷
+	NetworkName   string                     `json:"networkName"` // 容器所在的网络
+	PortMapping   []string                   `json:"portMapping"` // 端口映射
+	IP            string                     `json:"ip"`
+	CgroupPath    string                     `json:"cgroupPath"`    // 容器的 cgroup 在 hierarchy 中的路径
+	Resource      *subsystems.ResourceConfig `json:"resource"`      // 容器的资源限制
+	OomScoreAdj   int                        `json:"oomScoreAdj"`   // 容器 init 进程的 oom_score_adj
+	OOMKilled     bool                       `json:"oomKilled"`     // 容器是否因为超出内存限制被 OOM killer 杀死
+	OOMKillCount  *uint64                    `json:"oomKillCount"`  // 容器最近一次启动时 cgroup 中的 oom_kill 计数，为空表示读取失败
+	ExitCode      int                        `json:"exitCode"`      // 容器 init 进程的退出码，-1 表示未知
+	StartedAt     string                     `json:"startedAt"`     // 容器最近一次启动的时间
+	FinishedAt    string                     `json:"finishedAt"`    // 容器退出的时间
+	Image         string                     `json:"image"`         // 容器使用的镜像
+	InitConfig    *InitConfig                `json:"initConfig"`    // 容器 init 进程的配置
+	MonitorPid    int                        `json:"monitorPid"`    // 负责回收容器 init 进程并记录退出状态的 monitor 进程的 PID
+	RestartPolicy *RestartPolicy             `json:"restartPolicy"` // 容器退出时的重启策略
+	RestartCount  int                        `json:"restartCount"`  // 容器被自动重启的次数
+	ManualStopped bool                       `json:"manualStopped"` // 容器是否被 stop 命令手动停止，手动停止的容器不会被自动重启
+	StopSignal    string                     `json:"stopSignal"`    // stop 命令停止容器时发送的信号，为空时为 SIGTERM
+	AutoRemove    bool                       `json:"autoRemove"`    // 容器退出后是否自动删除
 }
 
 /*
@@ -41,22 +67,30 @@ type Info struct {
  * 1. 这里的 /proc/self/exe 调用中，/proc/self/ 指向当前正在执行的进程的环境，exec 是自己调用自己，使用这种方式对创造出来的进程进行初始化
  * 2. 后面的 args 是参数，其中 init 是传递给本进程的第一个参数，在本例中，其实就是会去调用 initCommand 去初始化进程的一些环境和资源
  * 3. Cloneflags 参数是用来设置进程的 Namespace 类型的，这里设置了五个 Namespace，分别是 UTS、PID、Mount、IPC、Network
+ *    InitConfig 中指定了 uid 映射时还会创建 User Namespace，具体见 setUpIDMappings
  * 4. 如果 tty 为 true，那么就会将当前进程的标准输入、输出、错误输出都映射到新创建出来的进程中
- * 5. 返回创建好的 cmd
+ * 5. 返回创建好的 cmd，以及用于发送 InitConfig 的管道和用于接收初始化结果的同步管道
  */
-func NewParentProcess(tty bool, volume, containerId, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
+func NewParentProcess(tty bool, volume, containerId, imageName string, config *InitConfig) (*exec.Cmd, *os.File, *os.File) {
 	// 创建匿名管道用于传递参数，将 readPipe 作为子进程的 ExtraFiles，子进程从 readPipe 中读取参数
 	// 父进程中则通过 writePipe 将参数写入管道
 	readPipe, writePipe, err := os.Pipe()
 	if err != nil {
 		log.Errorf("New pipe error: %v", err)
-		return nil, nil
+		return nil, nil, nil
+	}
+	// 同步管道的方向与上面相反，子进程初始化失败时通过 syncWritePipe 将错误发送给父进程
+	syncReadPipe, syncWritePipe, err := os.Pipe()
+	if err != nil {
+		log.Errorf("New sync pipe error: %v", err)
+		return nil, nil, nil
 	}
 	cmd := exec.Command("/proc/self/exe", "init")
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
 			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
 	}
+	setUpIDMappings(cmd, config.UidMappings, config.GidMappings)
 	if tty {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
@@ -64,23 +98,24 @@ func NewParentProcess(tty bool, volume, containerId, imageName string, envSlice
 	} else {
 		// 对于后台运行容器，将 stdout、stderr 重定向到日志文件中，便于后续查看
 		dirPath := GetConfigDirPath(containerId)
-		if err := os.MkdirAll(dirPath, constant.Perm0622); err != nil {
+		if err := os.MkdirAll(dirPath, constant.Perm0755); err != nil {
 			log.Errorf("NewParentProcess Mkdir dir %s error %v", dirPath, err)
-			return nil, nil
+			return nil, nil, nil
 		}
 		stdLogFilePath := path.Join(dirPath, GetLogFileName(containerId))
-		stdLogFile, err := os.Create(stdLogFilePath)
+		// 重新启动的容器继续追加到原来的日志文件中
+		stdLogFile, err := os.OpenFile(stdLogFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, constant.Perm0644)
 		if err != nil {
 			log.Errorf("NewParentProcess Create log file %s error %v", stdLogFilePath, err)
-			return nil, nil
+			return nil, nil, nil
 
 		}
 		cmd.Stdout = stdLogFile
 		cmd.Stderr = stdLogFile
 	}
-	cmd.Env = append(os.Environ(), envSlice...)
-	cmd.ExtraFiles = []*os.File{readPipe}
-	NewWorkSpace(containerId, imageName, volume)
+	// readPipe 和 syncWritePipe 在子进程中的 fd 分别为 3 和 4
+	cmd.ExtraFiles = []*os.File{readPipe, syncWritePipe}
+	NewWorkSpace(containerId, imageName, volume, config.UidMappings, config.GidMappings)
 	cmd.Dir = utils.GetMerged(containerId)
-	return cmd, writePipe
+	return cmd, writePipe, syncReadPipe
 }
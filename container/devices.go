@@ -0,0 +1,233 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"mydocker/cgroups/subsystems"
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// Device 需要在容器的 /dev 中创建的设备
+type Device struct {
+	HostPath      string      `json:"hostPath"`      // 宿主机上的设备路径
+	ContainerPath string      `json:"containerPath"` // 容器内的设备路径
+	Permissions   string      `json:"permissions"`   // 容器访问设备的权限，r、w、m 的组合
+	Type          string      `json:"type"`          // 设备类型，c 字符设备、b 块设备
+	Major         int64       `json:"major"`
+	Minor         int64       `json:"minor"`
+	FileMode      os.FileMode `json:"fileMode"`
+	Uid           uint32      `json:"uid"`
+	Gid           uint32      `json:"gid"`
+}
+
+// Rule 返回允许容器访问该设备的规则
+func (d *Device) Rule() *subsystems.DeviceRule {
+	return &subsystems.DeviceRule{
+		Type:        d.Type,
+		Major:       d.Major,
+		Minor:       d.Minor,
+		Permissions: d.Permissions,
+		Allow:       true,
+	}
+}
+
+// DefaultDevices 每个容器都会创建的设备，与 docker 保持一致
+var DefaultDevices = []*Device{
+	{ContainerPath: "/dev/null", Type: subsystems.DeviceTypeChar, Major: 1, Minor: 3, Permissions: "rwm", FileMode: 0666},
+	{ContainerPath: "/dev/zero", Type: subsystems.DeviceTypeChar, Major: 1, Minor: 5, Permissions: "rwm", FileMode: 0666},
+	{ContainerPath: "/dev/full", Type: subsystems.DeviceTypeChar, Major: 1, Minor: 7, Permissions: "rwm", FileMode: 0666},
+	{ContainerPath: "/dev/random", Type: subsystems.DeviceTypeChar, Major: 1, Minor: 8, Permissions: "rwm", FileMode: 0666},
+	{ContainerPath: "/dev/urandom", Type: subsystems.DeviceTypeChar, Major: 1, Minor: 9, Permissions: "rwm", FileMode: 0666},
+	{ContainerPath: "/dev/tty", Type: subsystems.DeviceTypeChar, Major: 5, Minor: 0, Permissions: "rwm", FileMode: 0666},
+}
+
+/*
+ * DeviceRules 生成容器的设备访问规则
+ * 1. 先禁止访问所有设备
+ * 2. 允许创建任意设备文件（能否访问仍由后面的规则决定），允许访问 /dev/console、/dev/ptmx 和 /dev/pts/*
+ * 3. 允许访问默认设备以及 --device 指定的设备
+ */
+func DeviceRules(devices []*Device) []*subsystems.DeviceRule {
+	rules := []*subsystems.DeviceRule{
+		{Type: subsystems.DeviceTypeAll, Major: subsystems.DeviceWildcard, Minor: subsystems.DeviceWildcard, Permissions: "rwm"},
+		{Type: subsystems.DeviceTypeChar, Major: subsystems.DeviceWildcard, Minor: subsystems.DeviceWildcard, Permissions: "m", Allow: true},
+		{Type: subsystems.DeviceTypeBlock, Major: subsystems.DeviceWildcard, Minor: subsystems.DeviceWildcard, Permissions: "m", Allow: true},
+		{Type: subsystems.DeviceTypeChar, Major: 5, Minor: 1, Permissions: "rwm", Allow: true},
+		{Type: subsystems.DeviceTypeChar, Major: 5, Minor: 2, Permissions: "rwm", Allow: true},
+		{Type: subsystems.DeviceTypeChar, Major: 136, Minor: subsystems.DeviceWildcard, Permissions: "rwm", Allow: true},
+	}
+	for _, device := range DefaultDevices {
+		rules = append(rules, device.Rule())
+	}
+	for _, device := range devices {
+		rules = append(rules, device.Rule())
+	}
+	return rules
+}
+
+/*
+ * ParseDevice 解析 --device 参数，格式为 {宿主机设备路径}[:{容器内设备路径}][:{权限}]，e.g. /dev/sda:/dev/xvda:rwm
+ * 与 docker 一致，只有两段并且第二段是合法的权限时，第二段作为权限，容器内路径与宿主机路径相同
+ */
+func ParseDevice(spec string) (*Device, error) {
+	parts := strings.Split(spec, ":")
+	var hostPath, containerPath, permissions string
+	switch len(parts) {
+	case 3:
+		hostPath, containerPath, permissions = parts[0], parts[1], parts[2]
+	case 2:
+		if isValidDevicePermissions(parts[1]) {
+			hostPath, permissions = parts[0], parts[1]
+		} else {
+			hostPath, containerPath = parts[0], parts[1]
+		}
+	case 1:
+		hostPath = parts[0]
+	default:
+		return nil, fmt.Errorf("invalid device [%s], must be like /dev/sda:/dev/xvda:rwm", spec)
+	}
+	if containerPath == "" {
+		containerPath = hostPath
+	}
+	if permissions == "" {
+		permissions = "rwm"
+	}
+	if !isValidDevicePermissions(permissions) {
+		return nil, fmt.Errorf("invalid device permissions %s", permissions)
+	}
+	if !path.IsAbs(containerPath) {
+		return nil, fmt.Errorf("device path %s in container must be absolute", containerPath)
+	}
+
+	// 通过 stat 拿到设备类型和设备号，容器内的设备文件与宿主机保持一致
+	var stat unix.Stat_t
+	if err := unix.Stat(hostPath, &stat); err != nil {
+		return nil, errors.Wrapf(err, "stat device %s", hostPath)
+	}
+	var deviceType string
+	switch stat.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		deviceType = subsystems.DeviceTypeChar
+	case unix.S_IFBLK:
+		deviceType = subsystems.DeviceTypeBlock
+	default:
+		return nil, fmt.Errorf("%s is not a device", hostPath)
+	}
+	return &Device{
+		HostPath:      hostPath,
+		ContainerPath: path.Clean(containerPath),
+		Permissions:   permissions,
+		Type:          deviceType,
+		Major:         int64(unix.Major(stat.Rdev)),
+		Minor:         int64(unix.Minor(stat.Rdev)),
+		FileMode:      os.FileMode(stat.Mode & 0777),
+		Uid:           stat.Uid,
+		Gid:           stat.Gid,
+	}, nil
+}
+
+// isValidDevicePermissions 权限只能由 r、w、m 组成并且不能重复
+func isValidDevicePermissions(permissions string) bool {
+	if permissions == "" || len(permissions) > 3 {
+		return false
+	}
+	for _, perm := range permissions {
+		if !strings.ContainsRune("rwm", perm) || strings.Count(permissions, string(perm)) > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainerDevices 返回需要在容器中创建的所有设备，包括默认设备和 --device 指定的设备
+func ContainerDevices(devices []*Device) []*Device {
+	return append(DefaultDevices[:len(DefaultDevices):len(DefaultDevices)], devices...)
+}
+
+/*
+ * setUpDev 在 rootfs 中新挂载的 /dev 中创建设备文件以及容器运行所需的目录和符号链接，在 pivot_root 之前调用
+ * 1. 通过 mknod 创建设备文件，并设置与宿主机相同的权限和属主，user namespace 中改为 bind mount 宿主机上的设备
+ * 2. 挂载 devpts（newinstance 保证与宿主机的伪终端隔离），/dev/ptmx 指向 /dev/pts/ptmx
+ * 3. 挂载 /dev/shm，创建 /dev/fd、/dev/stdin 等指向 /proc/self/fd 的符号链接
+ */
+func setUpDev(rootfs string, devices []*Device, userns bool) {
+	for _, device := range devices {
+		create := createDevice
+		if userns {
+			create = bindDevice
+		}
+		if err := create(rootfs, device); err != nil {
+			log.Errorf("Create device %s error %v", device.ContainerPath, err)
+		}
+	}
+
+	if err := os.MkdirAll(path.Join(rootfs, "/dev/pts"), constant.Perm0755); err == nil {
+		_ = unix.Mount("devpts", path.Join(rootfs, "/dev/pts"), "devpts", unix.MS_NOSUID|unix.MS_NOEXEC,
+			"newinstance,ptmxmode=0666,mode=0620")
+	}
+	if err := os.MkdirAll(path.Join(rootfs, "/dev/shm"), constant.Perm0777); err == nil {
+		_ = unix.Mount("shm", path.Join(rootfs, "/dev/shm"), "tmpfs", unix.MS_NOSUID|unix.MS_NOEXEC|unix.MS_NODEV,
+			"mode=1777,size=65536k")
+	}
+	links := [][2]string{
+		{"pts/ptmx", "/dev/ptmx"},
+		{"/proc/self/fd", "/dev/fd"},
+		{"/proc/self/fd/0", "/dev/stdin"},
+		{"/proc/self/fd/1", "/dev/stdout"},
+		{"/proc/self/fd/2", "/dev/stderr"},
+	}
+	for _, link := range links {
+		if err := os.Symlink(link[0], path.Join(rootfs, link[1])); err != nil && !os.IsExist(err) {
+			log.Errorf("Symlink %s to %s error %v", link[1], link[0], err)
+		}
+	}
+}
+
+// createDevice 在容器中创建设备文件
+func createDevice(rootfs string, device *Device) error {
+	devicePath := path.Join(rootfs, device.ContainerPath)
+	if err := os.MkdirAll(path.Dir(devicePath), constant.Perm0755); err != nil {
+		return err
+	}
+	mode := uint32(device.FileMode.Perm())
+	if device.Type == subsystems.DeviceTypeBlock {
+		mode |= unix.S_IFBLK
+	} else {
+		mode |= unix.S_IFCHR
+	}
+	dev := unix.Mkdev(uint32(device.Major), uint32(device.Minor))
+	if err := unix.Mknod(devicePath, mode, int(dev)); err != nil {
+		return errors.Wrap(err, "mknod")
+	}
+	// mknod 创建的文件权限会受 umask 影响，这里重新设置一次
+	if err := unix.Chmod(devicePath, uint32(device.FileMode.Perm())); err != nil {
+		return errors.Wrap(err, "chmod")
+	}
+	return errors.Wrap(unix.Chown(devicePath, int(device.Uid), int(device.Gid)), "chown")
+}
+
+// bindDevice user namespace 中没有权限 mknod，创建一个空文件作为挂载点，再从宿主机 bind mount 对应的设备
+// 默认设备没有指定宿主机路径，使用宿主机上相同路径的设备
+func bindDevice(rootfs string, device *Device) error {
+	devicePath := path.Join(rootfs, device.ContainerPath)
+	if err := os.MkdirAll(path.Dir(devicePath), constant.Perm0755); err != nil {
+		return err
+	}
+	hostPath := device.HostPath
+	if hostPath == "" {
+		hostPath = device.ContainerPath
+	}
+	file, err := os.OpenFile(devicePath, os.O_CREATE|os.O_RDONLY, constant.Perm0644)
+	if err != nil {
+		return err
+	}
+	_ = file.Close()
+	return errors.Wrapf(unix.Mount(hostPath, devicePath, "bind", unix.MS_BIND, ""), "bind mount %s", hostPath)
+}
@@ -1,83 +1,146 @@
 package container
 
 import (
-	"io"
+	"fmt"
 	"mydocker/constant"
+	"mydocker/seccomp"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 
 	errors "github.com/pkg/errors"
 )
 
+// maxSymlinks 解析路径时最多跟随的符号链接数量，与内核保持一致
+const maxSymlinks = 40
+
 /*
  * 这里的 init 函数是在容器内部执行的，也就是说，代码执行到这里后，容器所在的进程其实就已经创建出来了，
  * 这是本容器执行的第一个进程。
- * 使用 mount 先去挂载 proc 文件系统，以便后面通过 ps 等系统命令去查看当前进程资源的情况。
+ * 初始化过程中的任何错误都会通过同步管道发送给父进程，由父进程输出错误并清理容器
  */
 func RunContainerInitProcess() error {
-	// 从 Pipe 中读取命令
-	cmdArray := readUserCommand()
-	if len(cmdArray) == 0 {
-		return errors.New("run container get user command error, cmdArray is nil")
+	// capability、no_new_privs 等都是线程级别的属性，只对设置它们的线程 exec 出来的进程生效
+	// 因此将当前 goroutine 固定在一个线程上，直到 exec 用户进程，初始化失败时进程直接退出，不需要解除
+	runtime.LockOSThread()
+	if err := reexecInUserNamespace(); err != nil {
+		return err
+	}
+	// 同步管道需要在 exec 用户进程时自动关闭，这样父进程才能读到 EOF
+	syscall.CloseOnExec(syncPipeIndex)
+	if err := initContainer(); err != nil {
+		reportInitError(err)
+		return err
+	}
+	return nil
+}
+
+/*
+ * initContainer 初始化容器环境并 exec 用户进程
+ * 1. 从管道中读取父进程发送的 InitConfig
+ * 2. 挂载 /proc、/dev 等文件系统并切换 rootfs，屏蔽敏感路径
+ * 3. 设置主机名、资源限制、工作目录、seccomp、用户和 capability，最后 exec 用户进程
+ */
+func initContainer() error {
+	config, err := readInitConfig()
+	if err != nil {
+		return err
 	}
 
 	// 挂载文件系统
-	setUpMount()
+	if err = setUpMount(config); err != nil {
+		return err
+	}
+	if config.Hostname != "" {
+		if err = unix.Sethostname([]byte(config.Hostname)); err != nil {
+			return errors.Wrapf(err, "set hostname %s", config.Hostname)
+		}
+	}
+	if err = setRlimits(config.Rlimits); err != nil {
+		return err
+	}
+	if config.Cwd != "" {
+		if err = os.Chdir(config.Cwd); err != nil {
+			return errors.Wrapf(err, "chdir to %s", config.Cwd)
+		}
+	}
 
-	path, err := exec.LookPath(cmdArray[0])
+	// 使用用户进程的环境变量查找命令，这样容器中的 PATH 才会生效
+	os.Clearenv()
+	for _, env := range config.Env {
+		if key, value, ok := strings.Cut(env, "="); ok {
+			_ = os.Setenv(key, value)
+		}
+	}
+	path, err := exec.LookPath(config.Args[0])
 	if err != nil {
-		log.Errorf("Exec loop path error: %v", err)
-		return err
+		return errors.Wrap(err, "look path")
 	}
 	log.Infof("Find path %s", path)
-	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
-		log.Errorf("RunContainerInitProcess exec :" + err.Error())
+	// 没有设置 no_new_privs 时安装 seccomp 过滤程序需要 CAP_SYS_ADMIN，因此要在删除 capability 之前安装
+	if !config.NoNewPrivileges {
+		if err = setUpSeccomp(config); err != nil {
+			return err
+		}
+	}
+	if err = setUserAndCapabilities(config); err != nil {
+		return err
+	}
+	// 设置了 no_new_privs 时尽量晚地安装 seccomp 过滤程序，这样过滤程序只需要允许 exec 用户进程之后的系统调用
+	if config.NoNewPrivileges {
+		if err = setNoNewPrivileges(); err != nil {
+			return err
+		}
+		if err = setUpSeccomp(config); err != nil {
+			return err
+		}
+	}
+	if err = syscall.Exec(path, config.Args, config.Env); err != nil {
+		return errors.Wrapf(err, "exec %s", path)
 	}
 	return nil
 }
 
-const fdIndex = 3
+// setNoNewPrivileges 设置 no_new_privs，exec 之后无法再通过 setuid 程序或者文件 capability 获得新的权限
+// no_new_privs 是线程级别的属性，必须在 exec 用户进程的线程上设置，RunContainerInitProcess 已经将 goroutine 固定在该线程上
+func setNoNewPrivileges() error {
+	return errors.Wrap(unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0), "set no new privs")
+}
 
-func readUserCommand() []string {
-	// uintptr(3）就是指 index 为 3 的文件描述符，也就是传递进来的管道的另一端，至于为什么是3，具体解释如下：
-	/*
-		因为每个进程默认都会有 3 个文件描述符，分别是标准输入、标准输出、标准错误。这 3 个是子进程一创建的时候就会默认带着的，
-		前面通过 ExtraFiles 方式带过来的 readPipe 理所当然地就成为了第 4 个。
-		在进程中可以通过 index 方式读取对应的文件，比如
-		index0：标准输入
-		index1：标准输出
-		index2：标准错误
-		index3：带过来的第一个 FD，也就是 readPipe
-		由于可以带多个 FD 过来，所以这里的 3 就不是固定的了。
-		比如像这样：cmd.ExtraFiles = []*os.File{a,b,c,readPipe} 这里带了 4 个文件过来，分别的 index 就是 3,4,5,6
-		那么我们的 readPipe 就是 index6，读取时就要像这样：pipe := os.NewFile(uintptr(6), "pipe")
-	*/
-	pipe := os.NewFile(uintptr(fdIndex), "pipe")
-	defer pipe.Close()
-
-	msg, err := io.ReadAll(pipe)
-	if err != nil {
-		log.Errorf("init read pipe error: %v", err)
+// setUpSeccomp 为用户进程安装 seccomp 过滤程序，过滤程序在 exec 之后仍然生效
+func setUpSeccomp(config *InitConfig) error {
+	if config.Seccomp == nil {
 		return nil
 	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
+	caps := config.Capabilities
+	if caps == nil {
+		caps = DefaultCapabilities
+	}
+	filter, err := seccomp.Compile(config.Seccomp, caps)
+	if err != nil {
+		return errors.WithMessage(err, "compile seccomp profile")
+	}
+	return seccomp.Install(filter)
 }
 
 /*
  * Init 挂载点
+ * 所有文件系统都在 pivot_root 之前挂载到 rootfs 中对应的目录，原因是 user namespace 中
+ * 1. 只有当前 mount namespace 中存在完整可见的 proc 时才允许挂载新的 proc，卸载 old_root 之后就没有了
+ * 2. 无法通过 mknod 创建设备，只能从宿主机的 /dev 中 bind mount
  */
-func setUpMount() {
+func setUpMount(config *InitConfig) error {
 	// 获取当前路径
 	pwd, err := os.Getwd()
 	if err != nil {
-		log.Errorf("Get current location error %v", err)
-		return
+		return errors.Wrap(err, "get current location")
 	}
 	log.Infof("Current location is %s", pwd)
 
@@ -88,31 +151,97 @@ func setUpMount() {
 	// 可以执行 mount -t proc proc /proc 命令重新挂载来解决
 	_ = syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, "")
 
-	err = pivotRoot(pwd)
-	if err != nil {
-		log.Errorf("pivot root error: %v", err)
+	/*
+	 * NOTE: PivotRoot 调用有限制，newRoot 和 oldRoot 不能再同一个文件系统下
+	 * 因此，为了使当前 root 的老 root 和新 root 不在同一个文件系统下，这里把 root 重新 mount 了一次
+	 * bind mount 是把相同的内容换了一个挂载点的挂载方法
+	 */
+	if err = syscall.Mount(pwd, pwd, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return errors.Wrapf(err, "bind mount %s", pwd)
+	}
+
+	for _, mount := range config.Mounts {
+		destination, err := resolveInRoot(pwd, mount.Destination)
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(destination, constant.Perm0755); err != nil {
+			return errors.Wrapf(err, "mkdir %s", mount.Destination)
+		}
+		if err = syscall.Mount(mount.Source, destination, mount.Type, uintptr(mount.Flags), mount.Data); err != nil {
+			return errors.Wrapf(err, "mount %s to %s", mount.Source, mount.Destination)
+		}
 	}
 
-	// mount /proc
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	_ = syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
+	// 工作目录不存在时自动创建，需要在挂载 volume 之后、rootfs 变为只读之前创建
+	if config.Cwd != "" {
+		cwd, err := resolveInRoot(pwd, config.Cwd)
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(cwd, constant.Perm0755); err != nil {
+			return errors.Wrapf(err, "mkdir workdir %s", config.Cwd)
+		}
+	}
 
-	// 由于前面 pivotRoot 切换了 rootfs，因此这里重新 mount 一下 /dev 目录
-	// tmpfs 是一种基于内存的文件系统，可以使用 RAM、swap 分区来存储。
 	// 不挂载 /dev，会导致容器内部无法访问和使用许多设备，这可能导致系统无法正常工作
-	syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
+	// tmpfs 挂载后 /dev 是空的，需要重新创建 /dev/null 等设备
+	setUpDev(pwd, config.Devices, len(config.UidMappings) > 0)
+
+	if err = pivotRoot(pwd); err != nil {
+		return errors.WithMessage(err, "pivot root")
+	}
+	return protectRootfs(config)
 }
 
-func pivotRoot(root string) error {
-	/*
-	 * NOTE: PivotRoot 调用有限制，newRoot 和 oldRoot 不能再同一个文件系统下
-	 * 因此，为了使当前 root 的老 root 和新 root 不在同一个文件系统下，这里把 root 重新 mount 了一次
-	 * bind mount 是把相同的内容换了一个挂载点的挂载方法
-	 */
-	if err := syscall.Mount(root, root, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
-		return err
+/*
+ * resolveInRoot 返回容器内的路径在 rootfs 中对应的路径
+ * 路径中的符号链接以 root 为根目录解析，避免镜像中的符号链接把挂载点指向宿主机上的目录，e.g. /proc -> /etc
+ * 不存在的部分原样保留，由调用方创建
+ */
+func resolveInRoot(root, unsafePath string) (string, error) {
+	current, remaining := "/", path.Clean("/"+unsafePath)
+	for links := 0; remaining != "/"; {
+		part, rest, _ := strings.Cut(remaining[1:], "/")
+		remaining = "/" + rest
+		next := path.Join(current, part)
+		info, err := os.Lstat(path.Join(root, next))
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks in %s", unsafePath)
+		}
+		target, err := os.Readlink(path.Join(root, next))
+		if err != nil {
+			return "", errors.Wrapf(err, "readlink %s", next)
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(current, target)
+		}
+		// path.Join 会清理 ..，超出根目录的 .. 会停留在根目录
+		current, remaining = "/", path.Join("/", target, remaining)
 	}
+	return path.Join(root, current), nil
+}
 
+// setRlimits 设置用户进程的资源限制，exec 之后会被用户进程继承
+func setRlimits(rlimits []*Rlimit) error {
+	for _, rlimit := range rlimits {
+		resource, ok := rlimitTypes[rlimit.Type]
+		if !ok {
+			return fmt.Errorf("invalid rlimit type %s", rlimit.Type)
+		}
+		if err := unix.Setrlimit(resource, &unix.Rlimit{Cur: rlimit.Soft, Max: rlimit.Hard}); err != nil {
+			return errors.Wrapf(err, "set rlimit %s", rlimit.Type)
+		}
+	}
+	return nil
+}
+
+// pivotRoot 将 rootfs 切换为 root，root 需要是一个挂载点
+func pivotRoot(root string) error {
 	// 创建 rootfs/.pivot_root 目录用于存储 old_root
 	pivotDir := filepath.Join(root, ".pivot_root")
 	if err := os.Mkdir(pivotDir, constant.Perm0777); err != nil {
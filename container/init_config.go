@@ -0,0 +1,194 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"mydocker/seccomp"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// initPipeIndex 父进程通过该 fd 向容器 init 进程发送 InitConfig
+	initPipeIndex = 3
+	// syncPipeIndex 容器 init 进程通过该 fd 将初始化失败的原因发送给父进程
+	// 该 fd 设置了 close-on-exec，用户进程 exec 成功后会自动关闭，父进程读到 EOF 即表示初始化成功
+	syncPipeIndex = 4
+)
+
+// InitConfig 父进程通过管道传递给容器 init 进程的配置，以 json 格式传递，避免参数中的空格被错误地拆分
+type InitConfig struct {
+	Args     []string  `json:"args"`     // 用户命令及其参数
+	Env      []string  `json:"env"`      // 用户进程的环境变量
+	Cwd      string    `json:"cwd"`      // 用户进程的工作目录，为空时为 /
+	Hostname string    `json:"hostname"` // 容器的主机名，为空时不设置
+	User     string    `json:"user"`     // 运行用户进程的用户，格式为 user[:group]，可以是名称或者数字 id，为空时为 root
+	Mounts   []*Mount  `json:"mounts"`   // 按顺序挂载到容器内的文件系统
+	Rlimits  []*Rlimit `json:"rlimits"`  // 用户进程的资源限制
+	Devices  []*Device `json:"devices"`  // 需要在容器的 /dev 中创建的设备
+
+	UidMappings []IDMap `json:"uidMappings,omitempty"` // user namespace 的 uid 映射，为空时不创建 user namespace
+	GidMappings []IDMap `json:"gidMappings,omitempty"` // user namespace 的 gid 映射
+
+	Capabilities []string `json:"capabilities"`         // 用户进程拥有的 capability，为 nil 时使用默认集合
+	Privileged   bool     `json:"privileged,omitempty"` // 是否为特权容器，特权容器拥有所有 capability
+
+	Seccomp         *seccomp.Profile `json:"seccomp,omitempty"`         // 用户进程的 seccomp 配置，为 nil 时不限制系统调用
+	NoNewPrivileges bool             `json:"noNewPrivileges,omitempty"` // 是否设置 no_new_privs，设置后 exec setuid 程序无法获得更多权限
+	ReadonlyRootfs  bool             `json:"readonlyRootfs,omitempty"`  // 是否将 rootfs 挂载为只读
+	MaskedPaths     []string         `json:"maskedPaths,omitempty"`     // 容器内不可访问的路径
+	ReadonlyPaths   []string         `json:"readonlyPaths,omitempty"`   // 容器内只读的路径
+}
+
+// Mount 容器内的挂载点，在 pivot_root 之前挂载到 rootfs 中对应的目录
+type Mount struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Type        string `json:"type"`
+	Flags       int    `json:"flags"`
+	Data        string `json:"data"`
+}
+
+// DefaultMounts 每个容器都会挂载的文件系统，sysfs 只读挂载，特权容器可以修改 sysfs
+func DefaultMounts(privileged bool) []*Mount {
+	sysFlags := unix.MS_NOEXEC | unix.MS_NOSUID | unix.MS_NODEV
+	if !privileged {
+		sysFlags |= unix.MS_RDONLY
+	}
+	return []*Mount{
+		// 挂载 proc 文件系统，以便后面通过 ps 等系统命令去查看当前进程资源的情况
+		{Source: "proc", Destination: "/proc", Type: "proc", Flags: unix.MS_NOEXEC | unix.MS_NOSUID | unix.MS_NODEV},
+		// 由于 pivotRoot 切换了 rootfs，因此这里重新 mount 一下 /dev 目录
+		// tmpfs 是一种基于内存的文件系统，可以使用 RAM、swap 分区来存储。
+		{Source: "tmpfs", Destination: "/dev", Type: "tmpfs", Flags: unix.MS_NOSUID | unix.MS_STRICTATIME, Data: "mode=755"},
+		{Source: "sysfs", Destination: "/sys", Type: "sysfs", Flags: sysFlags},
+	}
+}
+
+// ReadonlyRootfsMounts --read-only 时 rootfs 只读，/tmp 和 /run 挂载为 tmpfs 供用户进程写入
+var ReadonlyRootfsMounts = []*Mount{
+	{Source: "tmpfs", Destination: "/tmp", Type: "tmpfs", Flags: unix.MS_NOSUID | unix.MS_NODEV, Data: "mode=1777"},
+	{Source: "tmpfs", Destination: "/run", Type: "tmpfs", Flags: unix.MS_NOSUID | unix.MS_NODEV, Data: "mode=755"},
+}
+
+// Rlimit 进程的资源限制，对应 setrlimit 系统调用
+type Rlimit struct {
+	Type string `json:"type"` // 资源名，e.g. nofile
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// rlimitTypes --ulimit 中的资源名与 RLIMIT_* 常量的对应关系
+var rlimitTypes = map[string]int{
+	"as":         unix.RLIMIT_AS,
+	"core":       unix.RLIMIT_CORE,
+	"cpu":        unix.RLIMIT_CPU,
+	"data":       unix.RLIMIT_DATA,
+	"fsize":      unix.RLIMIT_FSIZE,
+	"locks":      unix.RLIMIT_LOCKS,
+	"memlock":    unix.RLIMIT_MEMLOCK,
+	"msgqueue":   unix.RLIMIT_MSGQUEUE,
+	"nice":       unix.RLIMIT_NICE,
+	"nofile":     unix.RLIMIT_NOFILE,
+	"nproc":      unix.RLIMIT_NPROC,
+	"rss":        unix.RLIMIT_RSS,
+	"rtprio":     unix.RLIMIT_RTPRIO,
+	"rttime":     unix.RLIMIT_RTTIME,
+	"sigpending": unix.RLIMIT_SIGPENDING,
+	"stack":      unix.RLIMIT_STACK,
+}
+
+// ParseRlimit 解析 --ulimit 参数，格式为 {资源名}={软限制}[:{硬限制}]，不指定硬限制时与软限制相同，e.g. nofile=1024:2048
+func ParseRlimit(spec string) (*Rlimit, error) {
+	name, value, ok := strings.Cut(spec, "=")
+	if !ok {
+		return nil, fmt.Errorf("invalid ulimit [%s], must be like nofile=1024:2048", spec)
+	}
+	if _, ok = rlimitTypes[name]; !ok {
+		return nil, fmt.Errorf("invalid ulimit type %s", name)
+	}
+	softStr, hardStr, hasHard := strings.Cut(value, ":")
+	if !hasHard {
+		hardStr = softStr
+	}
+	soft, err := parseRlimitValue(softStr)
+	if err != nil {
+		return nil, err
+	}
+	hard, err := parseRlimitValue(hardStr)
+	if err != nil {
+		return nil, err
+	}
+	if soft > hard {
+		return nil, fmt.Errorf("ulimit soft limit %d is larger than hard limit %d", soft, hard)
+	}
+	return &Rlimit{Type: name, Soft: soft, Hard: hard}, nil
+}
+
+// parseRlimitValue -1 和 unlimited 都表示不限制
+func parseRlimitValue(value string) (uint64, error) {
+	if value == "-1" || value == "unlimited" {
+		return unix.RLIM_INFINITY, nil
+	}
+	limit, err := strconv.ParseUint(value, 10, 64)
+	return limit, errors.Wrapf(err, "invalid ulimit value %s", value)
+}
+
+// SendInitConfig 将 InitConfig 以 json 格式写入管道，写完后关闭管道，init 进程读到 EOF 即表示读取完成
+func SendInitConfig(writePipe *os.File, config *InitConfig) error {
+	defer writePipe.Close()
+	return errors.Wrap(json.NewEncoder(writePipe).Encode(config), "send init config")
+}
+
+// readInitConfig 从管道中读取父进程发送的 InitConfig
+func readInitConfig() (*InitConfig, error) {
+	pipe := os.NewFile(uintptr(initPipeIndex), "pipe")
+	defer pipe.Close()
+	config := &InitConfig{}
+	if err := json.NewDecoder(pipe).Decode(config); err != nil {
+		return nil, errors.Wrap(err, "read init config")
+	}
+	if len(config.Args) == 0 {
+		return nil, errors.New("user command is empty")
+	}
+	return config, nil
+}
+
+// syncMessage 容器 init 进程通过同步管道发送给父进程的消息
+type syncMessage struct {
+	Error string `json:"error"`
+}
+
+// ReadSyncPipe 等待对端完成初始化，读到 EOF 表示初始化成功（容器 init 进程即 exec 用户进程成功），读到消息表示初始化失败
+func ReadSyncPipe(syncPipe *os.File) error {
+	defer syncPipe.Close()
+	content, err := io.ReadAll(syncPipe)
+	if err != nil {
+		return errors.Wrap(err, "read sync pipe")
+	}
+	if len(content) == 0 {
+		return nil
+	}
+	msg := &syncMessage{}
+	if err = json.Unmarshal(content, msg); err != nil {
+		return errors.Wrapf(err, "unmarshal sync message %s", content)
+	}
+	return errors.New(msg.Error)
+}
+
+// WriteSyncPipe 将初始化失败的原因通过同步管道发送给对端
+func WriteSyncPipe(syncPipe *os.File, initErr error) {
+	defer syncPipe.Close()
+	_ = json.NewEncoder(syncPipe).Encode(&syncMessage{Error: initErr.Error()})
+}
+
+// reportInitError 将容器 init 进程初始化失败的原因发送给父进程
+func reportInitError(initErr error) {
+	WriteSyncPipe(os.NewFile(uintptr(syncPipeIndex), "sync"), initErr)
+}
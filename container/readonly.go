@@ -0,0 +1,83 @@
+package container
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultMaskedPaths 容器内默认不可访问的路径，这些路径会泄露宿主机的信息或者可以影响宿主机
+var DefaultMaskedPaths = []string{
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/sysrq-trigger",
+	"/sys/firmware",
+}
+
+// DefaultReadonlyPaths 容器内默认只读的路径，/proc/sys 中的大部分内核参数没有 namespace 隔离
+var DefaultReadonlyPaths = []string{
+	"/proc/sys",
+}
+
+// remountFlags 只读重新挂载时需要保留的挂载参数，user namespace 中不允许去掉宿主机上已经设置的参数
+const remountFlags = unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_NOATIME | unix.MS_NODIRATIME | unix.MS_RELATIME
+
+/*
+ * protectRootfs 在 pivot_root 之后限制容器对文件系统的访问
+ * 1. 将 MaskedPaths 中的文件 bind mount 为 /dev/null，目录挂载为只读的空 tmpfs
+ * 2. 将 ReadonlyPaths bind mount 到自身后重新挂载为只读
+ * 3. --read-only 时将 rootfs 重新挂载为只读，/proc、/dev、/tmp 等挂载点不受影响
+ */
+func protectRootfs(config *InitConfig) error {
+	for _, path := range config.MaskedPaths {
+		if err := maskPath(path); err != nil {
+			return err
+		}
+	}
+	for _, path := range config.ReadonlyPaths {
+		if err := readonlyPath(path); err != nil {
+			return err
+		}
+	}
+	if config.ReadonlyRootfs {
+		return errors.WithMessage(remountReadonly("/"), "readonly rootfs")
+	}
+	return nil
+}
+
+// maskPath 屏蔽容器内的路径，路径不存在时忽略
+func maskPath(path string) error {
+	err := unix.Mount("/dev/null", path, "", unix.MS_BIND, "")
+	if err == unix.ENOTDIR {
+		// 目录无法 bind mount 文件，改为挂载一个只读的空 tmpfs
+		err = unix.Mount("tmpfs", path, "tmpfs", unix.MS_RDONLY, "size=0")
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "mask path %s", path)
+	}
+	return nil
+}
+
+// readonlyPath 将容器内的路径设置为只读，路径不存在时忽略
+func readonlyPath(path string) error {
+	if err := unix.Mount(path, path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		if os.IsNotExist(err) {
+			log.Warnf("readonly path %s does not exist, ignore it", path)
+			return nil
+		}
+		return errors.Wrapf(err, "bind mount %s", path)
+	}
+	return remountReadonly(path)
+}
+
+// remountReadonly 将挂载点重新挂载为只读，并保留原有的 nosuid、nodev 等参数
+func remountReadonly(path string) error {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return errors.Wrapf(err, "statfs %s", path)
+	}
+	flags := uintptr(stat.Flags)&remountFlags | unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY
+	return errors.Wrapf(unix.Mount("", path, "", flags, ""), "remount %s readonly", path)
+}
@@ -0,0 +1,80 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 容器的重启策略，与 docker 保持一致
+const (
+	RestartPolicyNo            = "no"
+	RestartPolicyOnFailure     = "on-failure"
+	RestartPolicyAlways        = "always"
+	RestartPolicyUnlessStopped = "unless-stopped"
+)
+
+// RestartPolicy 容器退出时的重启策略
+type RestartPolicy struct {
+	Name              string `json:"name"`
+	MaximumRetryCount int    `json:"maximumRetryCount"` // 只对 on-failure 生效，0 表示不限制重启次数
+}
+
+// ParseRestartPolicy 解析 --restart 参数，格式为 no、on-failure[:最大重启次数]、always 或者 unless-stopped
+func ParseRestartPolicy(spec string) (*RestartPolicy, error) {
+	if spec == "" {
+		return &RestartPolicy{Name: RestartPolicyNo}, nil
+	}
+	name, count, hasCount := strings.Cut(spec, ":")
+	policy := &RestartPolicy{Name: name}
+	switch name {
+	case RestartPolicyNo, RestartPolicyAlways, RestartPolicyUnlessStopped:
+		if hasCount {
+			return nil, fmt.Errorf("maximum retry count cannot be used with restart policy %s", name)
+		}
+	case RestartPolicyOnFailure:
+		if hasCount {
+			maxCount, err := strconv.Atoi(count)
+			if err != nil || maxCount < 0 {
+				return nil, fmt.Errorf("invalid maximum retry count %s", count)
+			}
+			policy.MaximumRetryCount = maxCount
+		}
+	default:
+		return nil, fmt.Errorf("invalid restart policy %s", spec)
+	}
+	return policy, nil
+}
+
+// IsNone 是否不需要重启
+func (p *RestartPolicy) IsNone() bool {
+	return p == nil || p.Name == "" || p.Name == RestartPolicyNo
+}
+
+/*
+ * ShouldRestart 根据容器的退出状态判断是否需要重启容器
+ * 1. 被 stop 命令手动停止的容器不会被重启
+ * 2. always 和 unless-stopped 总是重启，on-failure 只在退出码不为 0 并且没有超过最大重启次数时重启
+ */
+func (p *RestartPolicy) ShouldRestart(exitCode int, manualStopped bool, restartCount int) bool {
+	if p.IsNone() || manualStopped {
+		return false
+	}
+	switch p.Name {
+	case RestartPolicyAlways, RestartPolicyUnlessStopped:
+		return true
+	case RestartPolicyOnFailure:
+		return exitCode != 0 && (p.MaximumRetryCount == 0 || restartCount < p.MaximumRetryCount)
+	}
+	return false
+}
+
+func (p *RestartPolicy) String() string {
+	if p.IsNone() {
+		return RestartPolicyNo
+	}
+	if p.Name == RestartPolicyOnFailure && p.MaximumRetryCount > 0 {
+		return fmt.Sprintf("%s:%d", p.Name, p.MaximumRetryCount)
+	}
+	return p.Name
+}
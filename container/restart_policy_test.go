@@ -0,0 +1,67 @@
+package container
+
+import "testing"
+
+func TestParseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    RestartPolicy
+		wantErr bool
+	}{
+		{spec: "", want: RestartPolicy{Name: RestartPolicyNo}},
+		{spec: "no", want: RestartPolicy{Name: RestartPolicyNo}},
+		{spec: "always", want: RestartPolicy{Name: RestartPolicyAlways}},
+		{spec: "unless-stopped", want: RestartPolicy{Name: RestartPolicyUnlessStopped}},
+		{spec: "on-failure", want: RestartPolicy{Name: RestartPolicyOnFailure}},
+		{spec: "on-failure:3", want: RestartPolicy{Name: RestartPolicyOnFailure, MaximumRetryCount: 3}},
+		{spec: "on-failure:-1", wantErr: true},
+		{spec: "always:3", wantErr: true},
+		{spec: "sometimes", wantErr: true},
+	}
+	for _, tt := range tests {
+		policy, err := ParseRestartPolicy(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if *policy != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.spec, *policy, tt.want)
+		}
+	}
+}
+
+func TestRestartPolicyShouldRestart(t *testing.T) {
+	tests := []struct {
+		policy        string
+		exitCode      int
+		manualStopped bool
+		restartCount  int
+		want          bool
+	}{
+		{policy: "no", exitCode: 1, want: false},
+		{policy: "always", exitCode: 0, want: true},
+		{policy: "always", exitCode: 143, manualStopped: true, want: false},
+		{policy: "unless-stopped", exitCode: 1, want: true},
+		{policy: "unless-stopped", exitCode: 0, manualStopped: true, want: false},
+		{policy: "on-failure", exitCode: 0, want: false},
+		{policy: "on-failure", exitCode: 1, restartCount: 100, want: true},
+		{policy: "on-failure:3", exitCode: 1, restartCount: 2, want: true},
+		{policy: "on-failure:3", exitCode: 1, restartCount: 3, want: false},
+	}
+	for _, tt := range tests {
+		policy, err := ParseRestartPolicy(tt.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := policy.ShouldRestart(tt.exitCode, tt.manualStopped, tt.restartCount); got != tt.want {
+			t.Errorf("%s (exit %d, manual stopped %v, restart count %d): got %v, want %v",
+				tt.policy, tt.exitCode, tt.manualStopped, tt.restartCount, got, tt.want)
+		}
+	}
+}
@@ -15,10 +15,24 @@ import (
  * 2) 创建 upper、worker 层
  * 3) 创建 merged 目录并挂载 overlayFS
  * 4) 如果有指定 volume 则挂载 volume
+ * 重新启动已经停止的容器时会再次调用，已经存在的目录和挂载点会被复用，upper 层中的数据不会丢失
+ * 容器使用 user namespace 时，文件的属主会被转换为容器内 root 在宿主机上对应的 id
+ * rootless 模式下普通用户无法挂载 overlayfs 和 bind mount，由 mountRootlessRootfs 准备 rootfs，volume 由容器 init 进程挂载
  */
-func NewWorkSpace(containerId, imageName, volume string) {
-	createLower(containerId, imageName)
-	createDirs(containerId)
+func NewWorkSpace(containerId, imageName, volume string, uidMappings, gidMappings []IDMap) {
+	createLower(containerId, imageName, uidMappings, gidMappings)
+	createDirs(containerId, uidMappings, gidMappings)
+	if utils.IsRootless() {
+		mountRootlessRootfs(containerId)
+		if volume != "" {
+			if hostPath, _, err := volumeExtract(volume); err == nil {
+				if err = os.MkdirAll(hostPath, constant.Perm0755); err != nil {
+					log.Errorf("Mkdir host path %s error. %v", hostPath, err)
+				}
+			}
+		}
+		return
+	}
 	mountOverlayFS(containerId)
 
 	if volume != "" {
@@ -42,7 +56,8 @@ func NewWorkSpace(containerId, imageName, volume string) {
 func DeleteWorkSpace(containerId, volume string) {
 	// 如果制定了 volume 则需要 umount volume
 	// NOTE: 一定要要先 umount volume ，然后再删除目录，否则由于 bind mount 存在，删除临时目录会导致 volume 目录中的数据丢失。
-	if volume != "" {
+	// rootless 模式下 volume 挂载在容器的 mount namespace 中，容器退出后自动卸载
+	if volume != "" && !utils.IsRootless() {
 		_, containerPath, err := volumeExtract(volume)
 		if err != nil {
 			log.Errorf("extract volume failed, maybe volume parameter input is not correct, detail:%v", err)
@@ -55,8 +70,21 @@ func DeleteWorkSpace(containerId, volume string) {
 	deleteDirs(containerId)
 }
 
+// UnmountWorkSpace 卸载容器的 volume 和 overlayfs，保留 upper 层等目录，容器重新启动时可以再次挂载
+func UnmountWorkSpace(containerId, volume string) {
+	if volume != "" && !utils.IsRootless() {
+		_, containerPath, err := volumeExtract(volume)
+		if err != nil {
+			log.Errorf("extract volume failed, maybe volume parameter input is not correct, detail:%v", err)
+			return
+		}
+		umountVolume(utils.GetMerged(containerId), containerPath)
+	}
+	umountOverlayFS(containerId)
+}
+
 // createLower 根据 containerID、imageName 准备 lower 层目录
-func createLower(containerId, imageName string) {
+func createLower(containerId, imageName string, uidMappings, gidMappings []IDMap) {
 	// 根据 containerId 拼接出 lower目录
 	// 根据 imageName 找到镜像 tar，并解压到 lower 目录中
 	lowerPath := utils.GetLower(containerId)
@@ -75,21 +103,50 @@ func createLower(containerId, imageName string) {
 		if _, err = exec.Command("tar", "-xvf", imagePath, "-C", lowerPath).CombinedOutput(); err != nil {
 			log.Errorf("Untar dir %s error %v", lowerPath, err)
 		}
+		// rootless 模式下解压出的文件属于当前用户，已经是容器内的 root，不需要转换
+		if len(uidMappings) > 0 && !utils.IsRootless() {
+			if err = shiftOwnership(lowerPath, uidMappings, gidMappings); err != nil {
+				log.Errorf("Shift ownership of %s error %v", lowerPath, err)
+			}
+		}
 	}
 }
 
 // createDirs 创建overlayfs需要的的merged、upper、worker目录
-func createDirs(containerId string) {
+// 使用 user namespace 时 merged、upper 目录的属主为容器内的 root，容器内的 root 才能在 rootfs 中创建文件
+func createDirs(containerId string, uidMappings, gidMappings []IDMap) {
 	dirs := []string{
 		utils.GetMerged(containerId),
 		utils.GetUpper(containerId),
 		utils.GetWorker(containerId),
 	}
 
+	rootUid, uidOk := toHostID(uidMappings, 0)
+	rootGid, gidOk := toHostID(gidMappings, 0)
+	// 容器内的 root 在宿主机上是一个普通用户，需要有进入数据目录的权限才能访问 rootfs
+	if uidOk && gidOk && !utils.IsRootless() {
+		allowTraverse(utils.DataRoot)
+	}
 	for _, dir := range dirs {
-		if err := os.Mkdir(dir, constant.Perm0777); err != nil {
+		if err := os.Mkdir(dir, constant.Perm0777); err != nil && !os.IsExist(err) {
 			log.Errorf("Mkdir dir %s error. %v", dir, err)
 		}
+		if uidOk && gidOk && !utils.IsRootless() {
+			if err := os.Chown(dir, rootUid, rootGid); err != nil {
+				log.Errorf("Chown dir %s error. %v", dir, err)
+			}
+		}
+	}
+}
+
+// allowTraverse 为目录加上所有用户的执行权限，只允许进入目录，不允许列出目录中的内容
+func allowTraverse(dir string) {
+	info, err := os.Stat(dir)
+	if err != nil || info.Mode().Perm()&0o111 == 0o111 {
+		return
+	}
+	if err = os.Chmod(dir, info.Mode().Perm()|0o111); err != nil {
+		log.Errorf("Chmod dir %s error. %v", dir, err)
 	}
 }
 
@@ -99,6 +156,10 @@ func mountOverlayFS(containerId string) {
 	// e.g. lowerdir=/root/busybox,upperdir=/root/upper,workdir=/root/work
 	dirs := utils.GetOverlayFSDirs(utils.GetLower(containerId), utils.GetUpper(containerId), utils.GetWorker(containerId))
 	mergedPath := utils.GetMerged(containerId)
+	if mounted, _ := utils.IsMountPoint(mergedPath); mounted {
+		log.Infof("overlayfs %s has been mounted", mergedPath)
+		return
+	}
 	//完整命令：mount -t overlay overlay -o lowerdir={lowerdir},upperdir={upperdir},workdir={workdir} {mergeddir}
 	cmd := exec.Command("mount", "-t", "overlay", "overlay", "-o", dirs, mergedPath)
 	log.Infof("mount overlayfs: [%s]", cmd.String())
@@ -111,6 +172,10 @@ func mountOverlayFS(containerId string) {
 
 // umountOverlayFS 卸载 overlayfs
 func umountOverlayFS(containerId string) {
+	if utils.IsRootless() {
+		umountRootlessRootfs(containerId)
+		return
+	}
 	mntPath := utils.GetMerged(containerId)
 	cmd := exec.Command("umount", mntPath)
 	cmd.Stdout = os.Stdout
@@ -0,0 +1,61 @@
+package container
+
+import (
+	"os"
+	"os/exec"
+
+	"mydocker/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+ * mountRootlessRootfs rootless 模式下准备容器的 rootfs
+ * 1. 安装了 fuse-overlayfs 时使用它挂载 overlayfs，普通用户可以通过 FUSE 挂载，与 root 模式一样使用 upper 层保存修改
+ * 2. 否则将 lower 层复制到 merged 目录中作为 rootfs，只在 merged 目录为空时复制，容器重新启动时修改不会丢失
+ * NOTE: FUSE 默认只允许挂载的用户访问，容器内映射为从属 id 的用户需要在 /etc/fuse.conf 中开启 user_allow_other
+ */
+func mountRootlessRootfs(containerId string) {
+	lowerPath := utils.GetLower(containerId)
+	mergedPath := utils.GetMerged(containerId)
+	if mounted, _ := utils.IsMountPoint(mergedPath); mounted {
+		log.Infof("rootfs %s has been mounted", mergedPath)
+		return
+	}
+	if fuseOverlayFS, err := exec.LookPath("fuse-overlayfs"); err == nil {
+		dirs := utils.GetOverlayFSDirs(lowerPath, utils.GetUpper(containerId), utils.GetWorker(containerId))
+		cmd := exec.Command(fuseOverlayFS, "-o", dirs, mergedPath)
+		log.Infof("mount fuse-overlayfs: [%s]", cmd.String())
+		if output, err := cmd.CombinedOutput(); err == nil {
+			return
+		} else {
+			log.Warnf("Mount fuse-overlayfs error: %v, %s, fall back to copy", err, output)
+		}
+	}
+	entries, err := os.ReadDir(mergedPath)
+	if err != nil || len(entries) > 0 {
+		return
+	}
+	// cp -a 保留文件的权限和时间戳，lower/. 表示复制目录中的内容而不是目录本身
+	if output, err := exec.Command("cp", "-a", lowerPath+"/.", mergedPath).CombinedOutput(); err != nil {
+		log.Errorf("Copy %s to %s error: %v, %s", lowerPath, mergedPath, err, output)
+	}
+}
+
+// umountRootlessRootfs 卸载 fuse-overlayfs，复制方式准备的 rootfs 不需要卸载
+func umountRootlessRootfs(containerId string) {
+	mergedPath := utils.GetMerged(containerId)
+	if mounted, _ := utils.IsMountPoint(mergedPath); !mounted {
+		return
+	}
+	for _, fusermount := range []string{"fusermount3", "fusermount"} {
+		if _, err := exec.LookPath(fusermount); err != nil {
+			continue
+		}
+		if output, err := exec.Command(fusermount, "-u", mergedPath).CombinedOutput(); err != nil {
+			log.Errorf("Umount fuse-overlayfs %s error: %v, %s", mergedPath, err, output)
+		}
+		return
+	}
+	log.Errorf("Umount fuse-overlayfs %s error: fusermount not found", mergedPath)
+}
@@ -0,0 +1,41 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ParseSignal 解析信号，支持信号名和信号值，信号名可以省略 SIG 前缀，e.g. SIGTERM、TERM、15
+func ParseSignal(rawSignal string) (syscall.Signal, error) {
+	if num, err := strconv.Atoi(rawSignal); err == nil {
+		if num <= 0 || unix.SignalName(syscall.Signal(num)) == "" {
+			return 0, fmt.Errorf("invalid signal %s", rawSignal)
+		}
+		return syscall.Signal(num), nil
+	}
+	name := strings.ToUpper(rawSignal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	signal := unix.SignalNum(name)
+	if signal == 0 {
+		return 0, fmt.Errorf("invalid signal %s", rawSignal)
+	}
+	return signal, nil
+}
+
+// GetStopSignal 返回停止容器时发送的信号，没有通过 --stop-signal 指定时为 SIGTERM
+func (info *Info) GetStopSignal() syscall.Signal {
+	if info.StopSignal == "" {
+		return syscall.SIGTERM
+	}
+	signal, err := ParseSignal(info.StopSignal)
+	if err != nil {
+		return syscall.SIGTERM
+	}
+	return signal
+}
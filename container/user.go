@@ -0,0 +1,236 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// passwdPath、groupPath 容器内的用户、用户组文件，在 pivot_root 之后读取
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+	// setgroupsPath 为 deny 时 user namespace 中不允许调用 setgroups
+	setgroupsPath = "/proc/self/setgroups"
+)
+
+// execUser 用户进程的 uid、gid 以及附加用户组
+type execUser struct {
+	Uid    int
+	Gid    int
+	Groups []int
+}
+
+// passwdEntry /etc/passwd 中的一行
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+}
+
+// groupEntry /etc/group 中的一行
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+/*
+ * resolveUser 根据 --user 解析用户进程的 uid、gid 以及附加用户组，格式为 user[:group]，user、group 可以是名称或者数字 id
+ * 1. user 为名称时必须存在于 passwdFile 中，为数字时可以不存在，此时 gid 为 0
+ * 2. 没有指定 group 时使用 passwdFile 中的主用户组，group 为名称时必须存在于 groupFile 中
+ * 3. 附加用户组为 groupFile 中成员包含该用户的用户组，与 docker 一致
+ * 镜像中没有 passwdFile、groupFile 时只支持数字 id
+ */
+func resolveUser(spec, passwdFile, groupFile string) (*execUser, error) {
+	userPart, groupPart, hasGroup := strings.Cut(spec, ":")
+	if userPart == "" || (hasGroup && groupPart == "") {
+		return nil, fmt.Errorf("invalid user %s, must be like uid[:gid] or user[:group]", spec)
+	}
+	users, err := parsePasswd(passwdFile)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := parseGroup(groupFile)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &execUser{}
+	var matched *passwdEntry
+	for i := range users {
+		if users[i].name == userPart {
+			matched = &users[i]
+			break
+		}
+	}
+	if matched == nil {
+		uid, err := parseID(userPart)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find user %s in %s", userPart, passwdFile)
+		}
+		for i := range users {
+			if users[i].uid == uid {
+				matched = &users[i]
+				break
+			}
+		}
+		result.Uid = uid
+	}
+	if matched != nil {
+		result.Uid, result.Gid = matched.uid, matched.gid
+	}
+
+	if hasGroup {
+		gid, err := lookupGroup(groupPart, groups, groupFile)
+		if err != nil {
+			return nil, err
+		}
+		result.Gid = gid
+	}
+
+	if matched != nil {
+		for _, group := range groups {
+			for _, member := range group.members {
+				if member == matched.name {
+					result.Groups = append(result.Groups, group.gid)
+					break
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// lookupGroup 根据名称或者数字 id 查找用户组
+func lookupGroup(name string, groups []groupEntry, groupFile string) (int, error) {
+	for _, group := range groups {
+		if group.name == name {
+			return group.gid, nil
+		}
+	}
+	gid, err := parseID(name)
+	if err != nil {
+		return 0, fmt.Errorf("unable to find group %s in %s", name, groupFile)
+	}
+	return gid, nil
+}
+
+// parseID 解析数字形式的 uid、gid
+func parseID(value string) (int, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if id < 0 || id > math.MaxInt32 {
+		return 0, fmt.Errorf("id %d out of range", id)
+	}
+	return id, nil
+}
+
+// parsePasswd 解析 /etc/passwd，格式为 name:password:uid:gid:gecos:home:shell，文件不存在时返回空
+func parsePasswd(path string) ([]passwdEntry, error) {
+	var entries []passwdEntry
+	err := readColonFile(path, func(fields []string) {
+		if len(fields) < 4 {
+			return
+		}
+		uid, uidErr := parseID(fields[2])
+		gid, gidErr := parseID(fields[3])
+		if uidErr != nil || gidErr != nil {
+			return
+		}
+		entries = append(entries, passwdEntry{name: fields[0], uid: uid, gid: gid})
+	})
+	return entries, err
+}
+
+// parseGroup 解析 /etc/group，格式为 name:password:gid:member1,member2，文件不存在时返回空
+func parseGroup(path string) ([]groupEntry, error) {
+	var entries []groupEntry
+	err := readColonFile(path, func(fields []string) {
+		if len(fields) < 3 {
+			return
+		}
+		gid, err := parseID(fields[2])
+		if err != nil {
+			return
+		}
+		entry := groupEntry{name: fields[0], gid: gid}
+		if len(fields) > 3 && fields[3] != "" {
+			entry.members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, entry)
+	})
+	return entries, err
+}
+
+// readColonFile 逐行读取以冒号分隔的文件，忽略空行和注释，文件不存在时不报错
+func readColonFile(path string, handle func(fields []string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "open %s", path)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		handle(strings.Split(line, ":"))
+	}
+	return errors.Wrapf(scanner.Err(), "read %s", path)
+}
+
+/*
+ * setUser 切换到 user 指定的用户，user 为空时保持 root
+ * 用户在容器的 /etc/passwd、/etc/group 中解析，因此需要在 pivot_root 之后调用
+ * rootless 模式下 user namespace 可能禁止了 setgroups，此时忽略附加用户组
+ */
+func setUser(user string) error {
+	if user == "" {
+		return nil
+	}
+	execUser, err := resolveUser(user, passwdPath, groupPath)
+	if err != nil {
+		return err
+	}
+	// 使用 syscall 包而不是 unix 包，syscall 包会在 Go runtime 的所有线程上切换用户
+	if err = syscall.Setgroups(execUser.Groups); err != nil {
+		if !setgroupsDenied() {
+			return errors.Wrap(err, "setgroups")
+		}
+		log.Warnf("setgroups is denied in the user namespace, ignore supplementary groups of user %s", user)
+	}
+	// uid、gid 没有映射到 user namespace 中时返回 EINVAL
+	if err = syscall.Setgid(execUser.Gid); err != nil {
+		if err == syscall.EINVAL {
+			return fmt.Errorf("gid %d is not mapped in the user namespace", execUser.Gid)
+		}
+		return errors.Wrapf(err, "setgid %d", execUser.Gid)
+	}
+	if err = syscall.Setuid(execUser.Uid); err != nil {
+		if err == syscall.EINVAL {
+			return fmt.Errorf("uid %d is not mapped in the user namespace", execUser.Uid)
+		}
+		return errors.Wrapf(err, "setuid %d", execUser.Uid)
+	}
+	return nil
+}
+
+// setgroupsDenied 判断当前 user namespace 是否禁止了 setgroups
+func setgroupsDenied() bool {
+	content, err := os.ReadFile(setgroupsPath)
+	return err == nil && strings.TrimSpace(string(content)) == "deny"
+}
@@ -0,0 +1,74 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveUser(t *testing.T) {
+	dir := t.TempDir()
+	passwdFile := filepath.Join(dir, "passwd")
+	groupFile := filepath.Join(dir, "group")
+	passwd := "root:x:0:0:root:/root:/bin/sh\n" +
+		"# comment\n" +
+		"app:x:1000:1000:app:/home/app:/bin/sh\n" +
+		"nobody:x:65534:65534:nobody:/:/bin/false\n"
+	group := "root:x:0:\n" +
+		"app:x:1000:\n" +
+		"wheel:x:10:app,root\n" +
+		"audio:x:29:app\n" +
+		"nogroup:x:65534:\n"
+	if err := os.WriteFile(passwdFile, []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(groupFile, []byte(group), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spec    string
+		want    *execUser
+		wantErr bool
+	}{
+		{spec: "app", want: &execUser{Uid: 1000, Gid: 1000, Groups: []int{10, 29}}},
+		{spec: "1000", want: &execUser{Uid: 1000, Gid: 1000, Groups: []int{10, 29}}},
+		{spec: "app:nogroup", want: &execUser{Uid: 1000, Gid: 65534, Groups: []int{10, 29}}},
+		{spec: "nobody:29", want: &execUser{Uid: 65534, Gid: 29}},
+		{spec: "root", want: &execUser{Uid: 0, Gid: 0, Groups: []int{10}}},
+		{spec: "4242", want: &execUser{Uid: 4242, Gid: 0}},
+		{spec: "4242:4343", want: &execUser{Uid: 4242, Gid: 4343}},
+		{spec: "ghost", wantErr: true},
+		{spec: "app:ghost", wantErr: true},
+		{spec: "app:", wantErr: true},
+		{spec: ":10", wantErr: true},
+		{spec: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveUser(tt.spec, passwdFile, groupFile)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	// 镜像中没有 /etc/passwd、/etc/group 时只支持数字 id
+	missing := filepath.Join(dir, "missing")
+	got, err := resolveUser("1000:1000", missing, missing)
+	if err != nil || !reflect.DeepEqual(got, &execUser{Uid: 1000, Gid: 1000}) {
+		t.Errorf("numeric user without passwd: got %+v, %v", got, err)
+	}
+	if _, err = resolveUser("app", missing, missing); err == nil {
+		t.Errorf("named user without passwd: expected error")
+	}
+}
@@ -0,0 +1,268 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"mydocker/utils"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	subUidFile = "/etc/subuid"
+	subGidFile = "/etc/subgid"
+	// envUsernsReexec 通过 newuidmap 写入映射时设置该环境变量，容器 init 进程在映射写入后重新 exec 自己
+	envUsernsReexec = "_MYDOCKER_USERNS_REEXEC"
+)
+
+// IDMap user namespace 中的一段 uid/gid 映射，容器内的 [ContainerID, ContainerID+Size) 对应宿主机上的 [HostID, HostID+Size)
+type IDMap struct {
+	ContainerID int `json:"containerId"`
+	HostID      int `json:"hostId"`
+	Size        int `json:"size"`
+}
+
+/*
+ * ParseSubIDs 从 /etc/subuid 或者 /etc/subgid 格式的文件中读取分配给用户的从属 id 范围
+ * 文件中每行的格式为 用户名或uid:起始id:数量，e.g. dockremap:100000:65536
+ */
+func ParseSubIDs(file, username string, uid int) ([]IDMap, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", file)
+	}
+	defer f.Close()
+	var ranges []IDMap
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || (fields[0] != username && fields[0] != strconv.Itoa(uid)) {
+			continue
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid start id in %s: %s", file, line)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid id count in %s: %s", file, line)
+		}
+		ranges = append(ranges, IDMap{HostID: start, Size: size})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "read %s", file)
+	}
+	return ranges, nil
+}
+
+/*
+ * RemapIDMappings 生成 --userns-remap 使用的映射，容器内的 root 映射为 remapUser 在 /etc/subuid、/etc/subgid 中的第一段从属 id
+ * remapUser 可以是用户名或者 uid
+ */
+func RemapIDMappings(remapUser string) ([]IDMap, []IDMap, error) {
+	u, err := lookupUser(remapUser)
+	if err != nil {
+		return nil, nil, err
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	uidRanges, err := ParseSubIDs(subUidFile, u.Username, uid)
+	if err != nil {
+		return nil, nil, err
+	}
+	gidRanges, err := ParseSubIDs(subGidFile, u.Username, uid)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(uidRanges) == 0 || len(gidRanges) == 0 {
+		return nil, nil, fmt.Errorf("no subordinate ids for user %s in %s and %s", u.Username, subUidFile, subGidFile)
+	}
+	return []IDMap{{ContainerID: 0, HostID: uidRanges[0].HostID, Size: uidRanges[0].Size}},
+		[]IDMap{{ContainerID: 0, HostID: gidRanges[0].HostID, Size: gidRanges[0].Size}}, nil
+}
+
+/*
+ * RootlessIDMappings 生成 rootless 模式使用的映射
+ * 1. 容器内的 root 映射为当前用户
+ * 2. 当前用户在 /etc/subuid、/etc/subgid 中有从属 id，并且安装了 newuidmap、newgidmap 时，容器内的 1 开始映射为从属 id
+ * 3. 否则容器内只有 root 一个用户，普通用户只能直接写入映射自己的 uid_map
+ */
+func RootlessIDMappings() ([]IDMap, []IDMap) {
+	uid, gid := os.Geteuid(), os.Getegid()
+	uidMappings := []IDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+	gidMappings := []IDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+	if !hasIDMapHelpers() {
+		log.Warnf("newuidmap or newgidmap not found, only root is available in the container")
+		return uidMappings, gidMappings
+	}
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return uidMappings, gidMappings
+	}
+	uidRanges, uidErr := ParseSubIDs(subUidFile, u.Username, uid)
+	gidRanges, gidErr := ParseSubIDs(subGidFile, u.Username, uid)
+	if uidErr != nil || gidErr != nil || len(uidRanges) == 0 || len(gidRanges) == 0 {
+		log.Warnf("no subordinate ids for user %s, only root is available in the container", u.Username)
+		return uidMappings, gidMappings
+	}
+	uidMappings = append(uidMappings, IDMap{ContainerID: 1, HostID: uidRanges[0].HostID, Size: uidRanges[0].Size})
+	gidMappings = append(gidMappings, IDMap{ContainerID: 1, HostID: gidRanges[0].HostID, Size: gidRanges[0].Size})
+	return uidMappings, gidMappings
+}
+
+// lookupUser 根据用户名或者 uid 查找用户
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "lookup user %s", name)
+	}
+	return u, nil
+}
+
+// hasIDMapHelpers 判断是否安装了 newuidmap 和 newgidmap，普通用户需要通过这两个 setuid 程序写入包含从属 id 的映射
+func hasIDMapHelpers() bool {
+	_, uidErr := exec.LookPath("newuidmap")
+	_, gidErr := exec.LookPath("newgidmap")
+	return uidErr == nil && gidErr == nil
+}
+
+// needIDMapHelpers 普通用户映射自己以外的 id 时没有权限直接写入 uid_map，需要通过 newuidmap、newgidmap
+func needIDMapHelpers(uidMappings, gidMappings []IDMap) bool {
+	selfMapping := len(uidMappings) == 1 && len(gidMappings) == 1 &&
+		uidMappings[0].HostID == os.Geteuid() && gidMappings[0].HostID == os.Getegid()
+	return len(uidMappings) > 0 && utils.IsRootless() && !selfMapping
+}
+
+/*
+ * setUpIDMappings 为容器 init 进程创建 user namespace 并设置 uid/gid 映射
+ * exec 时进程的 uid 在 user namespace 中没有映射的话会丢失所有 capability，因此映射需要在 exec 之前写入
+ * 1. 可以直接写入映射时交给 Go 在 fork 之后 exec 之前写入，并切换为容器内的 root，普通用户写入 gid_map 前需要禁用 setgroups
+ * 2. 需要 newuidmap 时 Go 无法在 exec 之前调用，由 ApplyIDMappings 在进程启动后写入，init 进程等映射写入后重新 exec 自己获得 capability
+ */
+func setUpIDMappings(cmd *exec.Cmd, uidMappings, gidMappings []IDMap) {
+	if len(uidMappings) == 0 {
+		return
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+	if needIDMapHelpers(uidMappings, gidMappings) {
+		cmd.Env = append(os.Environ(), envUsernsReexec+"=1")
+		return
+	}
+	for _, m := range uidMappings {
+		cmd.SysProcAttr.UidMappings = append(cmd.SysProcAttr.UidMappings,
+			syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	for _, m := range gidMappings {
+		cmd.SysProcAttr.GidMappings = append(cmd.SysProcAttr.GidMappings,
+			syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = !utils.IsRootless()
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: utils.IsRootless()}
+}
+
+// ApplyIDMappings 在容器 init 进程启动后通过 newuidmap、newgidmap 写入映射，不需要时什么也不做
+// init 进程在读取到 InitConfig 之前会一直阻塞，因此需要在发送 InitConfig 之前调用
+func ApplyIDMappings(pid int, uidMappings, gidMappings []IDMap) error {
+	if !needIDMapHelpers(uidMappings, gidMappings) {
+		return nil
+	}
+	if err := runIDMapHelper("newuidmap", pid, uidMappings); err != nil {
+		return err
+	}
+	return runIDMapHelper("newgidmap", pid, gidMappings)
+}
+
+// runIDMapHelper 调用 newuidmap 或者 newgidmap 写入映射，参数格式为 pid 容器内起始id 宿主机起始id 数量...
+func runIDMapHelper(helper string, pid int, mappings []IDMap) error {
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range mappings {
+		args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+	if output, err := exec.Command(helper, args...).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "%s %s: %s", helper, strings.Join(args, " "), strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// toHostID 将容器内的 id 转换为宿主机上的 id，没有对应的映射时返回 false
+func toHostID(mappings []IDMap, id int) (int, bool) {
+	for _, m := range mappings {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+	return 0, false
+}
+
+/*
+ * shiftOwnership 将 root 下所有文件的属主从容器内的 id 转换为宿主机上的 id
+ * 镜像以 root 身份解压，文件属于宿主机上的 root，--userns-remap 时容器内的 root 对应宿主机上的从属 id，需要修改属主才能正常访问
+ * 没有对应映射的 id 保持不变，在容器内会显示为 nobody
+ */
+func shiftOwnership(root string, uidMappings, gidMappings []IDMap) error {
+	return filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		uid, uidOk := toHostID(uidMappings, int(stat.Uid))
+		gid, gidOk := toHostID(gidMappings, int(stat.Gid))
+		if !uidOk && !gidOk {
+			return nil
+		}
+		if !uidOk {
+			uid = int(stat.Uid)
+		}
+		if !gidOk {
+			gid = int(stat.Gid)
+		}
+		if err = os.Lchown(file, uid, gid); err != nil {
+			return errors.Wrapf(err, "chown %s", file)
+		}
+		// chown 会清除 setuid、setgid 位，这里恢复原来的权限
+		if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 && info.Mode()&os.ModeSymlink == 0 {
+			return errors.Wrapf(os.Chmod(file, info.Mode()), "chmod %s", file)
+		}
+		return nil
+	})
+}
+
+/*
+ * reexecInUserNamespace 通过 newuidmap 写入映射时，init 进程 exec 时 uid 还没有映射，没有任何 capability
+ * 父进程写入映射之后才会发送 InitConfig，因此这里等到管道可读时重新 exec 自己，此时 uid 已经映射为容器内的 root
+ * 只等待不读取，管道中的 InitConfig 留给重新 exec 之后的进程读取
+ */
+func reexecInUserNamespace() error {
+	if os.Getenv(envUsernsReexec) == "" {
+		return nil
+	}
+	fds := []unix.PollFd{{Fd: initPipeIndex, Events: unix.POLLIN}}
+	for {
+		if _, err := unix.Poll(fds, -1); err != unix.EINTR {
+			break
+		}
+	}
+	_ = os.Unsetenv(envUsernsReexec)
+	return errors.Wrap(syscall.Exec("/proc/self/exe", os.Args, os.Environ()), "reexec init")
+}
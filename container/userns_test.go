@@ -0,0 +1,101 @@
+package container
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestParseSubIDs(t *testing.T) {
+	file := path.Join(t.TempDir(), "subuid")
+	content := "# comment\nalice:100000:65536\n1001:165536:65536\nbob:231072:1000\nalice:300000:10\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseSubIDs(file, "alice", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IDMap{{HostID: 100000, Size: 65536}, {HostID: 300000, Size: 10}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	// 文件中也可以使用 uid 指定用户
+	got, err = ParseSubIDs(file, "carol", 1001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want = []IDMap{{HostID: 165536, Size: 65536}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if err = os.WriteFile(file, []byte("alice:100000:0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseSubIDs(file, "alice", 1000); err == nil {
+		t.Error("expected error for empty range")
+	}
+}
+
+func TestToHostID(t *testing.T) {
+	mappings := []IDMap{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}}
+	for _, tc := range []struct {
+		id   int
+		want int
+		ok   bool
+	}{
+		{0, 1000, true},
+		{1, 100000, true},
+		{65536, 165535, true},
+		{65537, 0, false},
+	} {
+		got, ok := toHostID(mappings, tc.id)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("toHostID(%d) = %d, %v, want %d, %v", tc.id, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"run", "usr/lib"} {
+		if err := os.MkdirAll(path.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"var/run": "/run",
+		"lib":     "usr/lib",
+		"escape":  "../../../etc",
+		"proc":    "/etc",
+		"loop":    "loop",
+	}
+	if err := os.MkdirAll(path.Join(root, "var"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, path.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for unsafePath, want := range map[string]string{
+		"/data":          "/data",
+		"/var/run/foo":   "/run/foo",
+		"/lib/modules":   "/usr/lib/modules",
+		"/escape/passwd": "/etc/passwd",
+		"/proc":          "/etc",
+		"/../../tmp":     "/tmp",
+	} {
+		got, err := resolveInRoot(root, unsafePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != path.Join(root, want) {
+			t.Errorf("resolveInRoot(%s) = %s, want %s", unsafePath, got, path.Join(root, want))
+		}
+	}
+	if _, err := resolveInRoot(root, "/loop/x"); err == nil {
+		t.Error("expected error for symlink loop")
+	}
+}
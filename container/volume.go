@@ -3,10 +3,12 @@ package container
 import (
 	"fmt"
 	"mydocker/constant"
+	"mydocker/utils"
 	"os"
 	"os/exec"
 	"path"
 	"strings"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -14,11 +16,15 @@ import (
 // mountVolume 使用 bind mount 挂载 volume
 func mountVolume(mntPath, hostPath, containerPath string) {
 	// 创建宿主机目录
-	if err := os.Mkdir(hostPath, constant.Perm0777); err != nil {
+	if err := os.Mkdir(hostPath, constant.Perm0777); err != nil && !os.IsExist(err) {
 		log.Errorf("Mkdir host path %s error. %v", hostPath, err)
 	}
 	// 拼接出对应的容器目录在宿主机上的位置，并创建对应的目录
 	containerPathInHost := path.Join(mntPath, containerPath)
+	if mounted, _ := utils.IsMountPoint(containerPathInHost); mounted {
+		log.Infof("volume %s has been mounted", containerPathInHost)
+		return
+	}
 	if err := os.Mkdir(containerPathInHost, constant.Perm0777); err != nil {
 		log.Infof("mkdir container dir %s error. %v", containerPathInHost, err)
 	}
@@ -46,6 +52,15 @@ func umountVolume(mntPath, containerPath string) {
 	}
 }
 
+// VolumeMount 返回 volume 对应的 bind mount 挂载点
+func VolumeMount(volume string) (*Mount, error) {
+	hostPath, containerPath, err := volumeExtract(volume)
+	if err != nil {
+		return nil, err
+	}
+	return &Mount{Source: hostPath, Destination: containerPath, Type: "bind", Flags: syscall.MS_BIND}, nil
+}
+
 // volumeExtract 通过冒号分割解析 volume 目录，比如 -v /tmp:/tmp
 func volumeExtract(volume string) (sourcePath, destinationPath string, err error) {
 	parts := strings.Split(volume, ":")
@@ -20,11 +20,17 @@ const (
 
 func ExecContainer(containerId string, cmdArray []string) {
 	// 根据传进来的容器 ID 获取对应的 PID
-	pid, err := container.GetPidByContainerId(containerId)
+	containerInfo, err := container.GetInfoByContainerId(containerId)
 	if err != nil {
 		log.Errorf("Exec container getContainerPidByName %s error %v", containerId, err)
 		return
 	}
+	// 被挂起的容器中 setns 进去的进程也会被冻结，因此直接拒绝
+	if containerInfo.Status == container.PAUSED {
+		log.Errorf("Container %s is paused, unpause the container before exec", containerId)
+		return
+	}
+	pid := containerInfo.Pid
 
 	cmd := exec.Command("/proc/self/exe", "exec")
 	cmd.Stdin = os.Stdin
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"text/template"
+
+	"mydocker/cgroups"
+	"mydocker/cgroups/subsystems"
+	"mydocker/container"
+	"mydocker/network"
+	"mydocker/utils"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	inspectTypeContainer = "container"
+	inspectTypeNetwork   = "network"
+)
+
+// ContainerInspect inspect 命令输出的容器详情，在 container.Info 的基础上增加了容器的状态、挂载点和资源使用情况
+type ContainerInspect struct {
+	*container.Info
+	State   *ContainerState    `json:"state"`
+	Mounts  []*container.Mount `json:"mounts"`          // 容器的 rootfs、volume 以及容器内挂载的文件系统
+	Stats   *subsystems.Stats  `json:"stats,omitempty"` // 容器当前的资源使用情况，只有运行中的容器才有
+	LogPath string             `json:"logPath"`
+}
+
+// ContainerState 容器的运行状态
+type ContainerState struct {
+	Status     string `json:"status"`
+	Running    bool   `json:"running"`
+	Paused     bool   `json:"paused"`
+	Restarting bool   `json:"restarting"`
+	OOMKilled  bool   `json:"oomKilled"`
+	Pid        string `json:"pid"`
+	ExitCode   int    `json:"exitCode"`
+	StartedAt  string `json:"startedAt"`
+	FinishedAt string `json:"finishedAt"`
+}
+
+// NetworkInspect inspect 命令输出的网络详情，在 network.Network 的基础上增加了子网、网关以及网络中的容器
+type NetworkInspect struct {
+	*network.Network
+	Subnet     string                      `json:"subnet"`
+	Gateway    string                      `json:"gateway"`
+	Containers map[string]*NetworkEndpoint `json:"containers"` // key 为容器 Id
+}
+
+// NetworkEndpoint 连接到网络中的容器
+type NetworkEndpoint struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+/*
+ * Inspect 输出容器或者网络的详细信息
+ * 1. objectType 为空时先按容器查找，找不到再按网络查找
+ * 2. 默认以 json 数组的格式输出，format 不为空时对每个对象执行 Go template 并输出一行，e.g. --format '{{.IP}}'
+ */
+func Inspect(names []string, objectType, format string) error {
+	var tmpl *template.Template
+	if format != "" {
+		var err error
+		tmpl, err = template.New("format").Funcs(template.FuncMap{"json": formatJSON}).Parse(format)
+		if err != nil {
+			return errors.Wrap(err, "parse format")
+		}
+	}
+
+	objects := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		object, err := inspectObject(name, objectType)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, object)
+	}
+
+	if tmpl == nil {
+		output, err := json.MarshalIndent(objects, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshal inspect result")
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+	for _, object := range objects {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, object); err != nil {
+			return errors.Wrap(err, "execute format")
+		}
+		fmt.Println(buf.String())
+	}
+	return nil
+}
+
+// inspectObject 根据类型查找容器或者网络
+func inspectObject(name, objectType string) (interface{}, error) {
+	switch objectType {
+	case inspectTypeContainer:
+		return inspectContainer(name)
+	case inspectTypeNetwork:
+		return inspectNetwork(name)
+	case "":
+		if object, err := inspectContainer(name); err == nil {
+			return object, nil
+		}
+		if object, err := inspectNetwork(name); err == nil {
+			return object, nil
+		}
+		return nil, fmt.Errorf("no such object: %s", name)
+	default:
+		return nil, fmt.Errorf("unsupported type %s, must be %s or %s", objectType, inspectTypeContainer, inspectTypeNetwork)
+	}
+}
+
+// inspectContainer 获取容器的详细信息
+func inspectContainer(ref string) (*ContainerInspect, error) {
+	containerId, err := container.ResolveContainerId(ref)
+	if err != nil {
+		return nil, err
+	}
+	info, err := container.GetInfoByContainerId(containerId)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "get container %s info", containerId)
+	}
+	// 与 ps 一样，monitor 进程异常退出时需要根据容器进程是否存在来修正容器状态
+	refreshContainerStatus(info)
+
+	result := &ContainerInspect{
+		Info: info,
+		State: &ContainerState{
+			Status:     info.Status,
+			Running:    info.Status == container.RUNNING || info.Status == container.PAUSED,
+			Paused:     info.Status == container.PAUSED,
+			Restarting: info.Status == container.RESTARTING,
+			OOMKilled:  info.OOMKilled,
+			Pid:        info.Pid,
+			ExitCode:   info.ExitCode,
+			StartedAt:  info.StartedAt,
+			FinishedAt: info.FinishedAt,
+		},
+		Mounts:  containerMounts(info),
+		LogPath: path.Join(container.GetConfigDirPath(info.Id), container.GetLogFileName(info.Id)),
+	}
+	if result.State.Running && info.CgroupPath != "" {
+		if stats, err := cgroups.NewCgroupManager(info.CgroupPath).GetStats(); err == nil {
+			result.Stats = stats
+		}
+	}
+	return result, nil
+}
+
+// containerMounts 返回容器的挂载点，依次为 overlayfs 的 rootfs、volume 以及 init 进程在容器内挂载的文件系统
+func containerMounts(info *container.Info) []*container.Mount {
+	mounts := []*container.Mount{{
+		Source:      utils.GetMerged(info.Id),
+		Destination: "/",
+		Type:        "overlay",
+		Data:        utils.GetOverlayFSDirs(utils.GetLower(info.Id), utils.GetUpper(info.Id), utils.GetWorker(info.Id)),
+	}}
+	// rootless 模式下 volume 由容器 init 进程挂载，已经包含在 InitConfig.Mounts 中
+	if info.Volume != "" && !utils.IsRootless() {
+		if mount, err := container.VolumeMount(info.Volume); err == nil {
+			mounts = append(mounts, mount)
+		}
+	}
+	if info.InitConfig != nil {
+		mounts = append(mounts, info.InitConfig.Mounts...)
+	}
+	return mounts
+}
+
+// inspectNetwork 获取网络的详细信息，网络中的容器通过遍历所有容器的信息得到
+func inspectNetwork(networkName string) (*NetworkInspect, error) {
+	nw, err := network.GetNetwork(networkName)
+	if err != nil {
+		return nil, err
+	}
+	result := &NetworkInspect{
+		Network:    nw,
+		Subnet:     (&net.IPNet{IP: nw.IPRange.IP.Mask(nw.IPRange.Mask), Mask: nw.IPRange.Mask}).String(),
+		Gateway:    nw.IPRange.IP.String(),
+		Containers: make(map[string]*NetworkEndpoint),
+	}
+	files, err := os.ReadDir(container.InfoLoc)
+	if err != nil {
+		return result, nil
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		info, err := getContainerInfo(file)
+		if err != nil || info.NetworkName != networkName {
+			continue
+		}
+		// 已经退出的容器保留了上一次的 IP 用于重新启动，但已经不在网络中了
+		if info.Status != container.RUNNING && info.Status != container.PAUSED {
+			continue
+		}
+		result.Containers[info.Id] = &NetworkEndpoint{Name: info.Name, IP: info.IP}
+	}
+	return result, nil
+}
+
+// formatJSON --format 中的 json 函数，e.g. --format '{{json .State}}'
+func formatJSON(v interface{}) (string, error) {
+	output, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
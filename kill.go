@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+
+	"mydocker/container"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * KillContainer 向容器 init 进程发送信号
+ * 发送的是 SIGKILL 或者容器的停止信号时，与 stop 一样标记为手动停止，容器退出后不会被自动重启
+ */
+func KillContainer(containerId, rawSignal string) error {
+	signal, err := container.ParseSignal(rawSignal)
+	if err != nil {
+		return err
+	}
+	containerInfo, err := container.GetInfoByContainerId(containerId)
+	if err != nil {
+		return errors.WithMessagef(err, "get container %s info", containerId)
+	}
+	if containerInfo.Status != container.RUNNING && containerInfo.Status != container.PAUSED {
+		return fmt.Errorf("container %s is not running, status: %s", containerId, containerInfo.Status)
+	}
+	pid, err := strconv.Atoi(containerInfo.Pid)
+	if err != nil {
+		return errors.Wrapf(err, "invalid pid %s", containerInfo.Pid)
+	}
+	if signal == syscall.SIGKILL || signal == containerInfo.GetStopSignal() {
+		_, err = container.ModifyContainerInfo(containerId, func(info *container.Info) error {
+			info.ManualStopped = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return errors.Wrapf(syscall.Kill(pid, signal), "send signal %v to container %s", signal, containerId)
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
-	"path"
+	"strconv"
+	"strings"
+	"syscall"
 	"text/tabwriter"
+	"time"
 
+	"mydocker/cgroups"
 	"mydocker/container"
 
 	log "github.com/sirupsen/logrus"
@@ -21,23 +24,28 @@ func ListContainers() {
 	}
 	containers := make([]*container.Info, 0, len(files))
 	for _, file := range files {
+		// 目录下除了每个容器的目录之外还有 Store 使用的锁文件
+		if !file.IsDir() {
+			continue
+		}
 		tmpContainer, err := getContainerInfo(file)
 		if err != nil {
 			log.Errorf("Get container info error %v", err)
 			continue
 		}
+		refreshContainerStatus(tmpContainer)
 		containers = append(containers, tmpContainer)
 	}
 	// 使用 tabwritter.NewWriter 在控制台打印出容器信息
 	// tabWriter 是引用的 text/tabwriter 类库，用于在控制台打印对齐的表格
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	_, err = fmt.Fprint(w, "ID\tNAME\tPID\tIP\tSTATUS\tCOMMAND\tCREATED\n")
+	_, err = fmt.Fprint(w, "ID\tNAME\tPID\tIP\tSTATUS\tRESTARTS\tCOMMAND\tCREATED\n")
 	if err != nil {
 		log.Errorf("Fprint error %v", err)
 	}
 	for _, item := range containers {
-		_, err = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-			item.Id, item.Name, item.Pid, item.IP, item.Status, item.Command, item.CreatedTime)
+		_, err = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			container.ShortID(item.Id), item.Name, item.Pid, item.IP, formatStatus(item), item.RestartCount, item.Command, item.CreatedTime)
 		if err != nil {
 			log.Errorf("Fprintf error %v", err)
 		}
@@ -48,20 +56,93 @@ func ListContainers() {
 }
 
 func getContainerInfo(file os.DirEntry) (*container.Info, error) {
-	// 根据文件名拼接出完整路径
-	configFile := path.Join(container.InfoLoc, file.Name(), container.ConfigName)
-	// 读取容器配置文件
-	content, err := os.ReadFile(configFile)
+	// 目录名即为容器 Id
+	info, err := container.GetInfoByContainerId(file.Name())
 	if err != nil {
-		log.Errorf("read file %s error %v", configFile, err)
+		log.Errorf("Get container %s info error %v", file.Name(), err)
 		return nil, err
 	}
-	// 解析配置文件
-	info := new(container.Info)
-	if err = json.Unmarshal(content, info); err != nil {
-		log.Errorf("Unmarshal error %v", err)
-		return nil, err
+	return info, nil
+}
+
+/*
+ * refreshContainerStatus 容器退出时通常由 monitor 进程更新状态，monitor 进程异常退出时没有进程负责更新状态，
+ * 因此在 ps 时检查容器进程是否还存在，进程已经退出的容器释放网络资源并标记为 exited，并将 cgroup 中的 oom_kill 计数与启动时的计数对比判断是否是因为 OOM 被杀死
+ */
+func refreshContainerStatus(info *container.Info) {
+	if !isStaleStatus(info) {
+		return
+	}
+	// 检查和修改之间容器可能被重新启动，持有容器的锁重新检查后再修改
+	latestInfo, err := container.ModifyContainerInfo(info.Id, func(latest *container.Info) error {
+		if !isStaleStatus(latest) {
+			return nil
+		}
+		// 等待重启的容器已经记录过退出状态，monitor 进程退出后不会再被重启，启动过程中异常退出的容器没有运行过
+		if latest.Status == container.RESTARTING || latest.Status == container.STARTING {
+			latest.Status = container.Exit
+			return nil
+		}
+		releaseNetwork(latest)
+		latest.Status = container.Exit
+		latest.ExitCode = -1
+		latest.FinishedAt = time.Now().Format(container.TimeFormat)
+		if isOOMKilled(nil, cgroups.NewCgroupManager(latest.CgroupPath), latest.OOMKillCount) {
+			latest.OOMKilled = true
+			// 被 OOM killer 使用 SIGKILL 杀死，退出码为 128 + 9
+			latest.ExitCode = 128 + int(syscall.SIGKILL)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Update container %s info error %v", info.Id, err)
+		return
+	}
+	*info = *latestInfo
+}
+
+// isStaleStatus 判断容器记录的状态是否已经过期，即 monitor 进程已经退出，而容器仍然是运行中、暂停或者等待重启的状态
+func isStaleStatus(info *container.Info) bool {
+	if info.Status != container.RUNNING && info.Status != container.PAUSED && info.Status != container.RESTARTING &&
+		info.Status != container.STARTING {
+		return false
+	}
+	if info.MonitorPid != 0 && isProcessAlive(info.MonitorPid) {
+		return false
+	}
+	if info.Status == container.RESTARTING || info.Status == container.STARTING {
+		return true
 	}
+	pid, err := strconv.Atoi(info.Pid)
+	return err == nil && !isProcessAlive(pid)
+}
 
-	return info, nil
+// isProcessAlive 判断进程是否存在，已经退出但还没有被回收的僵尸进程也视为不存在
+func isProcessAlive(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+	// /proc/[pid]/stat 的格式为 "pid (comm) state ..."，comm 中可能包含空格，因此从最后一个 ')' 开始解析
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	stat := string(content)
+	idx := strings.LastIndex(stat, ")")
+	if idx < 0 || idx+2 >= len(stat) {
+		return true
+	}
+	return stat[idx+2] != 'Z'
+}
+
+// formatStatus 已退出和等待重启的容器在状态后展示退出码，因 OOM 退出时额外标记出来
+func formatStatus(info *container.Info) string {
+	if (info.Status != container.Exit && info.Status != container.RESTARTING) || info.ExitCode < 0 {
+		return info.Status
+	}
+	status := fmt.Sprintf("%s (%d)", info.Status, info.ExitCode)
+	if info.OOMKilled {
+		status += " oom-killed"
+	}
+	return status
 }
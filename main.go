@@ -22,13 +22,23 @@ func main() {
 
 	app.Commands = []cli.Command{
 		initCommand,
+		monitorCommand,
 		runCommand,
 		commitCommand,
 		listCommand,
 		logCommand,
 		execCommand,
 		stopCommand,
+		killCommand,
+		waitCommand,
+		startCommand,
+		restartCommand,
+		updateCommand,
+		statsCommand,
+		pauseCommand,
+		unpauseCommand,
 		removeCommand,
+		inspectCommand,
 		networkCommand,
 	}
 
@@ -2,20 +2,34 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"mydocker/cgroups/subsystems"
 	"mydocker/container"
 	"mydocker/network"
+	"mydocker/seccomp"
+	"mydocker/utils"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
+// hostNameMax 主机名的最大长度，即内核中的 HOST_NAME_MAX
+const hostNameMax = 64
+
 var runCommand = cli.Command{
 	Name: "run",
 	Usage: `Create a container with namespace and cgroups limit
 			mydocker run -it/-d [-name containerName] imageName command [arg...]`,
+	// -h 用于指定主机名，因此隐藏默认的 -h/--help 参数，可以通过 mydocker help run 查看帮助
+	HideHelp: true,
 	// 每个命令都可以通过 cli.Flag 指定具体参数
 	Flags: []cli.Flag{
 		cli.BoolFlag{
@@ -30,14 +44,87 @@ var runCommand = cli.Command{
 			Name:  "mem", // 限制进程内存使用量
 			Usage: "memory limit, e.g.: -mem 100m",
 		},
+		cli.StringFlag{
+			Name:  "memory-swap", // 限制内存 + swap 的总量
+			Usage: "total memory limit (memory + swap), -1 means unlimited swap, e.g.: -memory-swap 200m",
+		},
+		cli.StringFlag{
+			Name:  "memory-reservation", // 内存软限制
+			Usage: "memory soft limit, e.g.: -memory-reservation 50m",
+		},
 		cli.StringFlag{
 			Name:  "cpu", // 限制进程 cpu 使用率
-			Usage: "cpu quota, e.g.: -cpu 100",
+			Usage: "cpu quota in percent of one cpu, e.g.: -cpu 20",
+		},
+		cli.Float64Flag{
+			Name:  "cpus", // 限制可以使用的 cpu 个数，可以是小数
+			Usage: "number of cpus, e.g.: -cpus 1.5",
+		},
+		cli.Uint64Flag{
+			Name:  "cpu-period", // CFS 调度周期
+			Usage: "limit cpu CFS period in microseconds, e.g.: -cpu-period 100000",
+		},
+		cli.Int64Flag{
+			Name:  "cpu-quota", // 每个 CFS 调度周期内可以使用的 cpu 时间
+			Usage: "limit cpu CFS quota in microseconds, -1 means unlimited, e.g.: -cpu-quota 50000",
+		},
+		cli.Int64Flag{
+			Name:  "cpu-shares", // cpu 时间片权重
+			Usage: "cpu shares (relative weight), e.g.: -cpu-shares 512",
 		},
 		cli.StringFlag{
 			Name:  "cpuset", // 限制进程 cpu 使用核数
 			Usage: "cpuset limit, e.g.: -cpuset 2,4",
 		},
+		cli.Int64Flag{
+			Name:  "pids-limit", // 限制进程数
+			Usage: "container pids limit, -1 means unlimited, e.g.: -pids-limit 100",
+		},
+		cli.UintFlag{
+			Name:  "blkio-weight", // 块设备 IO 权重
+			Usage: "block IO weight, between 10 and 1000, e.g.: -blkio-weight 500",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-bps",
+			Usage: "limit read rate (bytes per second) from a device, e.g.: -device-read-bps /dev/sda:1mb",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-bps",
+			Usage: "limit write rate (bytes per second) to a device, e.g.: -device-write-bps /dev/sda:1mb",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-iops",
+			Usage: "limit read rate (IO per second) from a device, e.g.: -device-read-iops /dev/sda:1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-iops",
+			Usage: "limit write rate (IO per second) to a device, e.g.: -device-write-iops /dev/sda:1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device", // 将宿主机设备添加到容器中
+			Usage: "add a host device to the container, e.g.: -device /dev/sda:/dev/xvda:rwm",
+		},
+		cli.IntFlag{
+			Name:  "oom-score-adj", // 调整 OOM killer 的优先级
+			Usage: "tune container's OOM preferences (-1000 to 1000), e.g.: -oom-score-adj 500",
+		},
+		cli.StringSliceFlag{
+			Name:  "ulimit", // 用户进程的资源限制
+			Usage: "ulimit options, e.g.: -ulimit nofile=1024:2048",
+		},
+		cli.StringFlag{
+			Name:  "restart", // 容器退出时的重启策略
+			Usage: "restart policy to apply when a container exits (no, on-failure[:max-retries], always, unless-stopped), e.g.: -restart always",
+		},
+		cli.StringFlag{
+			Name:  "stop-signal", // stop 命令停止容器时发送的信号
+			Usage: "signal to stop the container, e.g.: -stop-signal SIGINT",
+			Value: "SIGTERM",
+		},
+		cli.BoolFlag{
+			Name:  "rm", // 容器退出后自动删除
+			Usage: "automatically remove the container when it exits",
+		},
 		cli.StringFlag{
 			Name:  "v", // 数据卷挂载
 			Usage: "volume, e.g.: -v /data:/data",
@@ -58,6 +145,42 @@ var runCommand = cli.Command{
 			Name:  "p",
 			Usage: "port mapping, e.g. -p 8080:80 -p 30336:3306",
 		},
+		cli.StringSliceFlag{
+			Name:  "cap-add", // 在默认集合的基础上增加 capability
+			Usage: "add linux capabilities, e.g. -cap-add NET_ADMIN -cap-add ALL",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-drop", // 从默认集合中删除 capability
+			Usage: "drop linux capabilities, e.g. -cap-drop MKNOD -cap-drop ALL",
+		},
+		cli.BoolFlag{
+			Name:  "privileged", // 特权容器拥有所有 capability
+			Usage: "give extended privileges to this container",
+		},
+		cli.StringFlag{
+			Name:  "user, u", // 用户在镜像的 /etc/passwd、/etc/group 中解析
+			Usage: "username or uid, with an optional group name or gid, e.g. -u nobody -u 1000:1000",
+		},
+		cli.StringFlag{
+			Name:  "workdir, w", // 不存在时自动创建
+			Usage: "working directory inside the container, e.g. -w /app",
+		},
+		cli.StringFlag{
+			Name:  "hostname, h", // 默认为容器的短 ID
+			Usage: "container host name, e.g. -h web",
+		},
+		cli.StringSliceFlag{
+			Name:  "security-opt", // 目前支持 seccomp=unconfined、seccomp={配置文件} 和 no-new-privileges=true|false
+			Usage: "security options, e.g. -security-opt seccomp=profile.json -security-opt no-new-privileges=false",
+		},
+		cli.BoolFlag{
+			Name:  "read-only", // rootfs 只读，/tmp 和 /run 挂载为 tmpfs
+			Usage: "mount the container's root filesystem as read only",
+		},
+		cli.StringFlag{
+			Name:  "userns-remap", // 容器内的 root 映射为该用户在 /etc/subuid、/etc/subgid 中的从属 id
+			Usage: "run the container in a user namespace mapped to the subordinate ids of the user, e.g. -userns-remap dockremap",
+		},
 	},
 	/*
 	 * run 命令执行的真正函数
@@ -88,21 +211,236 @@ var runCommand = cli.Command{
 			tty = true
 		}
 
-		resConf := &subsystems.ResourceConfig{
-			MemoryLimit: context.String("mem"),
-			CpuCfsQuota: context.Int("cpu"),
-			CpuSet:      context.String("cpuset"),
+		resConf, err := parseResourceConfig(context)
+		if err != nil {
+			return err
+		}
+		if err = resConf.Validate(); err != nil {
+			return err
+		}
+		oomScoreAdj := context.Int("oom-score-adj")
+		if oomScoreAdj < -1000 || oomScoreAdj > 1000 {
+			return fmt.Errorf("invalid oom score adj %d, must be in range [-1000, 1000]", oomScoreAdj)
+		}
+		var devices []*container.Device
+		for _, spec := range context.StringSlice("device") {
+			device, err := container.ParseDevice(spec)
+			if err != nil {
+				return err
+			}
+			devices = append(devices, device)
+		}
+		resConf.Devices = container.DeviceRules(devices)
+		restartPolicy, err := container.ParseRestartPolicy(context.String("restart"))
+		if err != nil {
+			return err
+		}
+		autoRemove := context.Bool("rm")
+		if !restartPolicy.IsNone() && autoRemove {
+			return fmt.Errorf("conflicting options: restart policy and rm")
+		}
+		stopSignal := context.String("stop-signal")
+		if _, err = container.ParseSignal(stopSignal); err != nil {
+			return err
+		}
+		var rlimits []*container.Rlimit
+		for _, spec := range context.StringSlice("ulimit") {
+			rlimit, err := container.ParseRlimit(spec)
+			if err != nil {
+				return err
+			}
+			rlimits = append(rlimits, rlimit)
+		}
+		privileged := context.Bool("privileged")
+		capabilities, err := container.ParseCapabilities(context.StringSlice("cap-add"),
+			context.StringSlice("cap-drop"), privileged)
+		if err != nil {
+			return err
+		}
+		// 用户进程继承 mydocker 的环境变量，-e 指定的环境变量追加在后面
+		initConfig := &container.InitConfig{
+			Args:            cmdArray,
+			Env:             append(os.Environ(), context.StringSlice("e")...),
+			Cwd:             context.String("workdir"),
+			Hostname:        context.String("hostname"),
+			User:            context.String("user"),
+			Mounts:          container.DefaultMounts(privileged),
+			Rlimits:         rlimits,
+			Devices:         container.ContainerDevices(devices),
+			Capabilities:    capabilities,
+			Privileged:      privileged,
+			NoNewPrivileges: true,
+			ReadonlyRootfs:  context.Bool("read-only"),
+		}
+		if initConfig.Cwd != "" && !filepath.IsAbs(initConfig.Cwd) {
+			return fmt.Errorf("workdir %s must be an absolute path", initConfig.Cwd)
+		}
+		if len(initConfig.Hostname) > hostNameMax {
+			return fmt.Errorf("hostname %s is longer than %d characters", initConfig.Hostname, hostNameMax)
+		}
+		// 与 docker 一致，特权容器不屏蔽 /proc 中的敏感路径
+		if !privileged {
+			initConfig.MaskedPaths = container.DefaultMaskedPaths
+			initConfig.ReadonlyPaths = container.DefaultReadonlyPaths
+		}
+		if initConfig.ReadonlyRootfs {
+			initConfig.Mounts = append(initConfig.Mounts, container.ReadonlyRootfsMounts...)
+		}
+		if err = parseSecurityOpts(context.StringSlice("security-opt"), initConfig); err != nil {
+			return err
 		}
 		volume := context.String("v")
 		containerName := context.String("name")
-		envSlice := context.StringSlice("e")
-		network := context.String("net")
 		portMapping := context.StringSlice("p")
-		Run(tty, cmdArray, envSlice, portMapping, resConf, volume, containerName, imageName, network)
-		return nil
+		network, err := setUpUserNamespace(context.String("userns-remap"), initConfig, volume,
+			context.String("net"), portMapping)
+		if err != nil {
+			return err
+		}
+		return Run(tty, initConfig, portMapping, resConf, volume, containerName, imageName, network, oomScoreAdj,
+			restartPolicy, stopSignal, autoRemove)
 	},
 }
 
+/*
+ * setUpUserNamespace 设置容器的 user namespace 映射，返回容器实际使用的网络
+ * 1. root 运行时只有指定了 --userns-remap 才使用 user namespace
+ * 2. rootless 模式下总是使用 user namespace，普通用户无法创建 bridge、iptables 规则，也无法在宿主机上 bind mount volume
+ *    因此只支持 slirp4netns 网络，不支持端口映射，volume 改为由容器 init 进程挂载，没有指定网络时默认使用 slirp4netns
+ */
+func setUpUserNamespace(remapUser string, initConfig *container.InitConfig, volume, net string,
+	portMapping []string) (string, error) {
+	if !utils.IsRootless() {
+		if remapUser == "" {
+			return net, nil
+		}
+		uidMappings, gidMappings, err := container.RemapIDMappings(remapUser)
+		if err != nil {
+			return "", errors.WithMessage(err, "userns remap")
+		}
+		initConfig.UidMappings, initConfig.GidMappings = uidMappings, gidMappings
+		return net, nil
+	}
+
+	if remapUser != "" {
+		return "", fmt.Errorf("userns-remap is not supported in rootless mode")
+	}
+	if len(portMapping) > 0 {
+		return "", fmt.Errorf("port mapping is not supported in rootless mode")
+	}
+	if net != "" && net != network.SlirpNetwork {
+		return "", fmt.Errorf("only network %s is supported in rootless mode", network.SlirpNetwork)
+	}
+	initConfig.UidMappings, initConfig.GidMappings = container.RootlessIDMappings()
+	if volume != "" {
+		mount, err := container.VolumeMount(volume)
+		if err != nil {
+			return "", err
+		}
+		initConfig.Mounts = append(initConfig.Mounts[:len(initConfig.Mounts):len(initConfig.Mounts)], mount)
+	}
+	if net == "" {
+		if _, err := exec.LookPath("slirp4netns"); err == nil {
+			net = network.SlirpNetwork
+		} else {
+			log.Warnf("slirp4netns not found, the container has no network")
+		}
+	}
+	return net, nil
+}
+
+/*
+ * parseSecurityOpts 解析 --security-opt，设置容器的 seccomp 配置和 no_new_privs
+ * 1. 默认使用 seccomp.DefaultProfile，与 docker 一致，特权容器不限制系统调用
+ * 2. seccomp=unconfined 不限制系统调用，seccomp={配置文件} 使用 docker/OCI 格式的自定义配置
+ * 3. 默认设置 no_new_privs，no-new-privileges=false 时不设置，只写 no-new-privileges 等同于 true
+ * seccomp 配置在这里编译一次，尽早发现配置中的错误
+ */
+func parseSecurityOpts(opts []string, initConfig *container.InitConfig) error {
+	profile := seccomp.DefaultProfile()
+	if initConfig.Privileged {
+		profile = nil
+	}
+	for _, opt := range opts {
+		key, value, ok := strings.Cut(opt, "=")
+		if key == "no-new-privileges" && !ok {
+			value = "true"
+		} else if !ok || value == "" {
+			return fmt.Errorf("invalid security opt %s, must be like seccomp=profile.json", opt)
+		}
+		switch key {
+		case "seccomp":
+			if value == seccomp.Unconfined {
+				profile = nil
+				continue
+			}
+			custom, err := seccomp.LoadProfile(value)
+			if err != nil {
+				return err
+			}
+			profile = custom
+		case "no-new-privileges":
+			noNewPrivileges, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid no-new-privileges value %s", value)
+			}
+			initConfig.NoNewPrivileges = noNewPrivileges
+		default:
+			return fmt.Errorf("unknown security opt %s", key)
+		}
+	}
+	if profile != nil {
+		if _, err := seccomp.Compile(profile, initConfig.Capabilities); err != nil {
+			return errors.WithMessage(err, "compile seccomp profile")
+		}
+	}
+	initConfig.Seccomp = profile
+	return nil
+}
+
+// parseResourceConfig 从命令行参数中解析出容器的资源限制配置
+func parseResourceConfig(context *cli.Context) (*subsystems.ResourceConfig, error) {
+	if context.Uint("blkio-weight") > math.MaxUint16 {
+		return nil, fmt.Errorf("invalid blkio weight %d", context.Uint("blkio-weight"))
+	}
+	resConf := &subsystems.ResourceConfig{
+		MemoryLimit:       context.String("mem"),
+		MemoryReservation: context.String("memory-reservation"),
+		MemorySwap:        context.String("memory-swap"),
+		CpuCfsQuota:       context.Int("cpu"),
+		NanoCpus:          int64(math.Round(context.Float64("cpus") * 1e9)),
+		CpuPeriod:         context.Uint64("cpu-period"),
+		CpuQuota:          context.Int64("cpu-quota"),
+		CpuSet:            context.String("cpuset"),
+		PidsLimit:         context.Int64("pids-limit"),
+		BlkioWeight:       uint16(context.Uint("blkio-weight")),
+	}
+	if shares := context.Int64("cpu-shares"); shares != 0 {
+		resConf.CpuShare = strconv.FormatInt(shares, 10)
+	}
+	// update 命令没有块设备限速相关的参数，context.StringSlice 会返回 nil，不影响解析
+	throttles := []struct {
+		flag     string
+		withUnit bool
+		devices  *[]*subsystems.ThrottleDevice
+	}{
+		{"device-read-bps", true, &resConf.BlkioDeviceReadBps},
+		{"device-write-bps", true, &resConf.BlkioDeviceWriteBps},
+		{"device-read-iops", false, &resConf.BlkioDeviceReadIOps},
+		{"device-write-iops", false, &resConf.BlkioDeviceWriteIOps},
+	}
+	for _, throttle := range throttles {
+		for _, spec := range context.StringSlice(throttle.flag) {
+			device, err := subsystems.ParseThrottleDevice(spec, throttle.withUnit)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "invalid %s", throttle.flag)
+			}
+			*throttle.devices = append(*throttle.devices, device)
+		}
+	}
+	return resConf, nil
+}
+
 var initCommand = cli.Command{
 	Name:  "init",
 	Usage: "Init container process run user's process in container. Do not call it outside",
@@ -113,6 +451,17 @@ var initCommand = cli.Command{
 	},
 }
 
+var monitorCommand = cli.Command{
+	Name:  "monitor",
+	Usage: "Start a detached container and wait for it to exit. Do not call it outside",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		return MonitorContainer(context.Args().Get(0))
+	},
+}
+
 var commitCommand = cli.Command{
 	Name:  "commit",
 	Usage: "commit container to image, e.g. mydocker commit 123456789 myimage",
@@ -120,7 +469,10 @@ var commitCommand = cli.Command{
 		if len(context.Args()) < 2 {
 			return fmt.Errorf("missing container name and image name")
 		}
-		containerId := context.Args().Get(0)
+		containerId, err := container.ResolveContainerId(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
 		imageName := context.Args().Get(1)
 		return CommitContainer(containerId, imageName)
 	},
@@ -142,8 +494,11 @@ var logCommand = cli.Command{
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("missing container name")
 		}
-		containerName := context.Args().Get(0)
-		LogContainer(containerName)
+		containerId, err := container.ResolveContainerId(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		LogContainer(containerId)
 		return nil
 	},
 }
@@ -162,29 +517,245 @@ var execCommand = cli.Command{
 		if len(context.Args()) < 2 {
 			return fmt.Errorf("missing container name or command")
 		}
-		containerName := context.Args().Get(0)
+		containerId, err := container.ResolveContainerId(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
 		// 将除了容器名之外的参数作为命令部分
 		var cmdArray []string
 		cmdArray = append(cmdArray, context.Args().Tail()...)
-		ExecContainer(containerName, cmdArray)
+		ExecContainer(containerId, cmdArray)
 		return nil
 	},
 }
 
 var stopCommand = cli.Command{
 	Name:  "stop",
-	Usage: "stop a container",
+	Usage: "stop a container, e.g. mydocker stop [-t 10] 123456789",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t", // 等待容器退出的时间，超时后强制杀死容器
+			Usage: "seconds to wait for stop before killing it",
+			Value: defaultStopTimeout,
+		},
+	},
 	Action: func(context *cli.Context) error {
 		// 期望输入是：mydocker stop 容器Id，如果没有指定参数直接打印错误
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("missing container name")
 		}
-		containerName := context.Args().Get(0)
-		StopContainer(containerName)
+		if context.Int("t") < 0 {
+			return fmt.Errorf("invalid stop timeout %d", context.Int("t"))
+		}
+		containerId, err := container.ResolveContainerId(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		StopContainer(containerId, time.Duration(context.Int("t"))*time.Second)
 		return nil
 	},
 }
 
+var waitCommand = cli.Command{
+	Name:  "wait",
+	Usage: "block until one or more containers stop, then print their exit codes, e.g. mydocker wait 123456789",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerIds, err := resolveContainerIds(context.Args())
+		if err != nil {
+			return err
+		}
+		return WaitContainers(containerIds)
+	},
+}
+
+var killCommand = cli.Command{
+	Name:  "kill",
+	Usage: "kill a running container, e.g. mydocker kill [-s SIGKILL] 123456789",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "s",
+			Usage: "signal to send to the container",
+			Value: "SIGKILL",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerId, err := container.ResolveContainerId(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		return KillContainer(containerId, context.String("s"))
+	},
+}
+
+var startCommand = cli.Command{
+	Name:  "start",
+	Usage: "start a stopped container, e.g. mydocker start [-a] 123456789",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "a",
+			Usage: "attach container's stdin, stdout and stderr",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerId, err := container.ResolveContainerId(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		return StartContainer(containerId, context.Bool("a"))
+	},
+}
+
+var restartCommand = cli.Command{
+	Name:  "restart",
+	Usage: "restart a container, e.g. mydocker restart [-t 10] 123456789",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Usage: "seconds to wait for stop before killing the container",
+			Value: defaultStopTimeout,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		if context.Int("t") < 0 {
+			return fmt.Errorf("invalid stop timeout %d", context.Int("t"))
+		}
+		containerId, err := container.ResolveContainerId(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		return RestartContainer(containerId, time.Duration(context.Int("t"))*time.Second)
+	},
+}
+
+var updateCommand = cli.Command{
+	Name: "update",
+	Usage: `update resource limits of a running container
+			mydocker update [-mem 100m] [-cpus 1.5] [-cpuset 0,1] containerId`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "mem",
+			Usage: "memory limit, e.g.: -mem 100m",
+		},
+		cli.StringFlag{
+			Name:  "memory-swap",
+			Usage: "total memory limit (memory + swap), -1 means unlimited swap, e.g.: -memory-swap 200m",
+		},
+		cli.StringFlag{
+			Name:  "memory-reservation",
+			Usage: "memory soft limit, e.g.: -memory-reservation 50m",
+		},
+		cli.StringFlag{
+			Name:  "cpu", // 限制进程 cpu 使用率
+			Usage: "cpu quota in percent of one cpu, e.g.: -cpu 20",
+		},
+		cli.Float64Flag{
+			Name:  "cpus", // 限制可以使用的 cpu 个数，可以是小数
+			Usage: "number of cpus, e.g.: -cpus 1.5",
+		},
+		cli.Uint64Flag{
+			Name:  "cpu-period", // CFS 调度周期
+			Usage: "limit cpu CFS period in microseconds, e.g.: -cpu-period 100000",
+		},
+		cli.Int64Flag{
+			Name:  "cpu-quota", // 每个 CFS 调度周期内可以使用的 cpu 时间
+			Usage: "limit cpu CFS quota in microseconds, -1 means unlimited, e.g.: -cpu-quota 50000",
+		},
+		cli.Int64Flag{
+			Name:  "cpu-shares", // cpu 时间片权重
+			Usage: "cpu shares (relative weight), e.g.: -cpu-shares 512",
+		},
+		cli.StringFlag{
+			Name:  "cpuset",
+			Usage: "cpuset limit, e.g.: -cpuset 2,4",
+		},
+		cli.Int64Flag{
+			Name:  "pids-limit",
+			Usage: "container pids limit, -1 means unlimited, e.g.: -pids-limit 100",
+		},
+		cli.UintFlag{
+			Name:  "blkio-weight",
+			Usage: "block IO weight, between 10 and 1000, e.g.: -blkio-weight 500",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		containerId, err := container.ResolveContainerId(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		// 只有通过参数指定了的字段才会被修改
+		patch, err := parseResourceConfig(context)
+		if err != nil {
+			return err
+		}
+		return UpdateContainer(containerId, patch)
+	},
+}
+
+var statsCommand = cli.Command{
+	Name: "stats",
+	Usage: `display a live stream of container(s) resource usage statistics
+			mydocker stats [--no-stream] [containerId...]`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stream",
+			Usage: "disable streaming stats and only pull the first result as json",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		// 不指定容器时展示所有运行中的容器
+		containerIds, err := resolveContainerIds(context.Args())
+		if err != nil {
+			return err
+		}
+		return StatsContainers(containerIds, context.Bool("no-stream"))
+	},
+}
+
+var pauseCommand = cli.Command{
+	Name:  "pause",
+	Usage: "pause all processes within a container, e.g. mydocker pause 1234567890",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		containerId, err := container.ResolveContainerId(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		return PauseContainer(containerId)
+	},
+}
+
+var unpauseCommand = cli.Command{
+	Name:  "unpause",
+	Usage: "unpause all processes within a container, e.g. mydocker unpause 1234567890",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		containerId, err := container.ResolveContainerId(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		return UnpauseContainer(containerId)
+	},
+}
+
 var removeCommand = cli.Command{
 	Name:  "rm",
 	Usage: "remove a container, e.g. mydocker rm 1234567890",
@@ -197,13 +768,38 @@ var removeCommand = cli.Command{
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("missing container id")
 		}
-		containerId := context.Args().Get(0)
+		containerId, err := container.ResolveContainerId(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
 		force := context.Bool("f")
 		RemoveContainer(containerId, force)
 		return nil
 	},
 }
 
+var inspectCommand = cli.Command{
+	Name: "inspect",
+	Usage: `display detailed information on containers or networks
+			mydocker inspect [--type container|network] [-f '{{.IP}}'] name [name...]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format, f",
+			Usage: "format the output using the given Go template",
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Usage: "return JSON for specified type, container or network",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container id or network name")
+		}
+		return Inspect(context.Args(), context.String("type"), context.String("format"))
+	},
+}
+
 var networkCommand = cli.Command{
 	Name:  "network",
 	Usage: "container network commands",
@@ -259,3 +855,16 @@ var networkCommand = cli.Command{
 		},
 	},
 }
+
+// resolveContainerIds 将命令行中的多个容器引用解析为完整的容器 Id
+func resolveContainerIds(refs []string) ([]string, error) {
+	containerIds := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		containerId, err := container.ResolveContainerId(ref)
+		if err != nil {
+			return nil, err
+		}
+		containerIds = append(containerIds, containerId)
+	}
+	return containerIds, nil
+}
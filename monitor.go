@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"syscall"
+	"time"
+
+	"mydocker/cgroups"
+	"mydocker/constant"
+	"mydocker/container"
+	"mydocker/network"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// monitorSyncPipeIndex monitor 进程通过该 fd 将容器启动失败的原因发送给 run 命令
+	monitorSyncPipeIndex = 3
+	// monitorLogFile monitor 进程的日志文件，位于容器信息目录下
+	monitorLogFile = "monitor.log"
+
+	// 自动重启容器的退避时间从 restartBackoffInitial 开始，每次重启翻倍，最多为 restartBackoffMax
+	restartBackoffInitial = 100 * time.Millisecond
+	restartBackoffMax     = time.Minute
+	// 容器运行超过 restartBackoffReset 后退出时认为之前已经正常运行，退避时间重新开始计算
+	restartBackoffReset = 10 * time.Second
+)
+
+/*
+ * startMonitor 启动后台运行容器的 monitor 进程，并等待 monitor 进程启动容器
+ * monitor 进程通过 setsid 脱离当前终端，run 命令退出后继续运行，
+ * 容器 init 进程是 monitor 进程的子进程，因此 monitor 进程可以回收容器进程并拿到容器的退出码
+ */
+func startMonitor(containerId string) error {
+	syncReadPipe, syncWritePipe, err := os.Pipe()
+	if err != nil {
+		return errors.Wrap(err, "new sync pipe")
+	}
+	defer syncReadPipe.Close()
+	logFilePath := path.Join(container.GetConfigDirPath(containerId), monitorLogFile)
+	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, constant.Perm0644)
+	if err != nil {
+		_ = syncWritePipe.Close()
+		return errors.Wrapf(err, "open monitor log file %s", logFilePath)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command("/proc/self/exe", "monitor", containerId)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.ExtraFiles = []*os.File{syncWritePipe}
+	err = cmd.Start()
+	_ = syncWritePipe.Close()
+	if err != nil {
+		return errors.Wrap(err, "start monitor process")
+	}
+	// monitor 进程独立运行，这里不需要等待它退出
+	_ = cmd.Process.Release()
+
+	if err = container.ReadSyncPipe(syncReadPipe); err != nil {
+		return errors.WithMessage(err, "start container")
+	}
+	// monitor 进程异常退出时同样会读到 EOF，通过容器状态确认容器确实已经启动
+	containerInfo, err := container.GetInfoByContainerId(containerId)
+	if err != nil {
+		return errors.WithMessage(err, "get container info")
+	}
+	if containerInfo.Status == container.CREATED || containerInfo.Status == container.STARTING {
+		return errors.Errorf("monitor process exited before container %s started, see %s", containerId, logFilePath)
+	}
+	return nil
+}
+
+/*
+ * MonitorContainer monitor 进程执行的内容
+ * 1. 根据容器信息启动容器，并通过同步管道将启动结果通知给 run 命令
+ * 2. 等待容器退出，记录退出码和退出时间，并清理容器的网络
+ * 3. 根据容器的重启策略重新启动容器
+ */
+func MonitorContainer(containerId string) error {
+	syncPipe := os.NewFile(uintptr(monitorSyncPipeIndex), "sync")
+	containerInfo, err := container.GetInfoByContainerId(containerId)
+	if err != nil {
+		err = errors.WithMessagef(err, "get container %s info", containerId)
+		container.WriteSyncPipe(syncPipe, err)
+		return err
+	}
+	parent, oomCh, err := startContainer(containerInfo, false)
+	if err != nil {
+		container.WriteSyncPipe(syncPipe, err)
+		return err
+	}
+	_ = syncPipe.Close()
+
+	superviseContainer(containerInfo, parent, oomCh, false)
+	return nil
+}
+
+/*
+ * superviseContainer 等待容器退出，并根据容器的重启策略重新启动容器
+ * 1. 重启前等待一段退避时间，退避时间每次翻倍，容器正常运行一段时间后重新计算，避免不断崩溃的容器占满 CPU
+ * 2. 退避期间容器状态为 restarting，被 stop 命令手动停止或者被 rm 删除的容器不再重启
+ * 3. 指定了 --rm 的容器退出后自动删除
+ */
+func superviseContainer(containerInfo *container.Info, parent *exec.Cmd, oomCh <-chan struct{}, tty bool) {
+	backoff := restartBackoffInitial
+	for {
+		startedAt := time.Now()
+		if !waitContainer(containerInfo, parent, oomCh) {
+			return
+		}
+		if containerInfo.AutoRemove {
+			RemoveContainer(containerInfo.Id, false)
+			return
+		}
+		if !containerInfo.RestartPolicy.ShouldRestart(containerInfo.ExitCode, containerInfo.ManualStopped,
+			containerInfo.RestartCount) {
+			return
+		}
+		if time.Since(startedAt) >= restartBackoffReset {
+			backoff = restartBackoffInitial
+		}
+		// 记录退出状态之后容器可能已经被 rm 或者重新 start，此时不再重启
+		if _, err := container.ModifyContainerInfo(containerInfo.Id, func(info *container.Info) error {
+			if info.Status != container.Exit || info.ManualStopped {
+				return fmt.Errorf("container %s status changed to %s", info.Id, info.Status)
+			}
+			info.Status = container.RESTARTING
+			return nil
+		}); err != nil {
+			log.Warnf("Skip restarting container %s: %v", containerInfo.Id, err)
+			return
+		}
+		log.Infof("Container %s exited with code %d, restart it in %v",
+			containerInfo.Id, containerInfo.ExitCode, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, restartBackoffMax)
+
+		// 退避期间容器可能被 stop 或者 rm，重新读取容器信息后再决定是否重启
+		latestInfo, err := container.GetInfoByContainerId(containerInfo.Id)
+		if err != nil || latestInfo.Status != container.RESTARTING || latestInfo.ManualStopped {
+			return
+		}
+		latestInfo.RestartCount++
+		if parent, oomCh, err = startContainer(latestInfo, tty); err != nil {
+			log.Errorf("Restart container %s error %v", latestInfo.Id, err)
+			if _, err = container.ModifyContainerInfo(latestInfo.Id, func(info *container.Info) error {
+				if info.Status == container.RESTARTING {
+					info.Status = container.Exit
+				}
+				return nil
+			}); err != nil {
+				log.Errorf("Update container %s info error %v", latestInfo.Id, err)
+			}
+			return
+		}
+		containerInfo = latestInfo
+	}
+}
+
+/*
+ * waitContainer 等待容器 init 进程退出并记录容器的退出状态，容器已经被删除时返回 false
+ * 1. 回收容器 init 进程，记录退出码、退出时间以及是否因为 OOM 被杀死
+ * 2. 释放容器的网络端点
+ */
+func waitContainer(containerInfo *container.Info, parent *exec.Cmd, oomCh <-chan struct{}) bool {
+	_ = parent.Wait()
+	exitCode := exitCode(parent.ProcessState)
+	// 容器中的其他进程被 OOM killer 杀死时 init 进程不一定退出，只有 init 进程被 SIGKILL 杀死时才认为容器是因为 OOM 退出的
+	oomKilled := exitCode == 128+int(syscall.SIGKILL) &&
+		isOOMKilled(oomCh, cgroups.NewCgroupManager(containerInfo.CgroupPath), containerInfo.OOMKillCount)
+	if oomKilled {
+		log.Warnf("Container %s was killed because it ran out of memory, exit code %d",
+			containerInfo.Id, exitCode)
+	}
+
+	// 容器运行期间 stop、pause 等命令可能修改了容器信息，这里持有容器的锁读取最新的信息后再修改
+	// 读取失败说明容器已经被 rm -f 删除，网络等资源也已经被清理，直接返回
+	latestInfo, err := container.ModifyContainerInfo(containerInfo.Id, func(info *container.Info) error {
+		// 退出的容器不再占用网络资源，这里保留 IP 的记录，容器重新启动时优先使用原来的 IP
+		releaseNetwork(info)
+		info.Status = container.Exit
+		info.Pid = ""
+		info.ExitCode = exitCode
+		info.OOMKilled = oomKilled
+		info.FinishedAt = time.Now().Format(container.TimeFormat)
+		return nil
+	})
+	if err != nil {
+		log.Warnf("Container %s has been removed: %v", containerInfo.Id, err)
+		return false
+	}
+	*containerInfo = *latestInfo
+	return true
+}
+
+// releaseNetwork 释放容器的网络端点、IP 和端口映射，exited 状态的容器已经释放过网络资源
+func releaseNetwork(containerInfo *container.Info) {
+	if containerInfo.NetworkName == "" || containerInfo.IP == "" {
+		return
+	}
+	if err := network.Disconnect(containerInfo.NetworkName, containerInfo); err != nil {
+		log.Errorf("Disconnect container %s from network %s error %v", containerInfo.Id, containerInfo.NetworkName, err)
+	}
+}
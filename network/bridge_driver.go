@@ -1,6 +1,8 @@
 package network
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"os/exec"
@@ -12,6 +14,9 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
+// vethSuffixLength veth 接口名中 endpoint ID 摘要的长度，加上 4 个字符的前缀正好是 Linux 接口名的最大长度 15
+const vethSuffixLength = 11
+
 type BridgeNetworkDriver struct {
 }
 
@@ -65,15 +70,20 @@ func (d *BridgeNetworkDriver) Connect(networkName string, ep *Endpoint) error {
 	}
 	// 创建 Veth 接口的配置
 	la := netlink.NewLinkAttrs()
-	// 由于 Linux 接口名的限制,取 endpointID 的前 5 位
-	la.Name = ep.ID[:5]
+	vethName, peerName := vethNames(ep.ID)
+	// 接口名已经被占用说明 endpoint ID 的摘要发生了冲突，直接报错，不能影响其他容器的网络
+	for _, name := range []string{vethName, peerName} {
+		if _, err = netlink.LinkByName(name); err == nil {
+			return fmt.Errorf("interface %s for endpoint %s already exists", name, ep.ID)
+		}
+	}
+	la.Name = vethName
 	// 通过设置 Veth 接口 master 属性，设置这个 Veth 的一端挂载到网络对应的 Linux Bridge
 	la.MasterIndex = br.Attrs().Index
-	// 创建 Veth 对象，通过 PeerNarne 配置 Veth 另外一端的接口名
-	// 配置 Veth 另外一端的名字 cif-{endpoint ID 的前 5 位｝
+	// 创建 Veth 对象，通过 PeerNarne 配置 Veth 另外一端的接口名 cif-{endpoint ID 的摘要}
 	ep.Device = netlink.Veth{
 		LinkAttrs: la,
-		PeerName:  "cif-" + la.Name,
+		PeerName:  peerName,
 	}
 	// 调用 netlink 的 LinkAdd 方法创建出这个 Veth 接口
 	// 因为上面指定了 link 的 MasterIndex是 网络对应的 Linux Bridge
@@ -92,7 +102,7 @@ func (d *BridgeNetworkDriver) Connect(networkName string, ep *Endpoint) error {
 /* 断开一个网络和网络端点 */
 func (d *BridgeNetworkDriver) Disconnect(endpointID string) error {
 	// 根据名字找到对应的 Veth 设备
-	vethNme := endpointID[:5] // 由于 Linux 接口名的限制,取 endpointID 的前 5 位
+	vethNme, veth2Name := vethNames(endpointID)
 	veth, err := netlink.LinkByName(vethNme)
 	if err != nil {
 		return err
@@ -108,7 +118,6 @@ func (d *BridgeNetworkDriver) Disconnect(endpointID string) error {
 	if err != nil {
 		return errors.WithMessagef(err, "delete veth [%s] failed", vethNme)
 	}
-	veth2Name := "cif-" + vethNme
 	veth2, err := netlink.LinkByName(veth2Name)
 	if err != nil {
 		return errors.WithMessagef(err, "find veth [%s] failed", veth2Name)
@@ -120,6 +129,17 @@ func (d *BridgeNetworkDriver) Disconnect(endpointID string) error {
 	return nil
 }
 
+/*
+ * vethNames 根据 endpoint ID 生成 veth-pair 两端的接口名
+ * Linux 接口名最长 15 个字符，endpoint ID 的前缀在不同容器之间可能相同，因此使用 endpoint ID 的 sha256 摘要
+ * 两端分别为 veth{摘要前 11 位} 和 cif-{摘要前 11 位}
+ */
+func vethNames(endpointID string) (string, string) {
+	sum := sha256.Sum256([]byte(endpointID))
+	suffix := hex.EncodeToString(sum[:])[:vethSuffixLength]
+	return "veth" + suffix, "cif-" + suffix
+}
+
 /* 初始化 Bridge 网络 */
 func (d *BridgeNetworkDriver) initBridge(n *Network) error {
 	bridgeName := n.Name
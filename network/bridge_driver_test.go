@@ -57,3 +57,20 @@ func TestBridgeDisconnect(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestVethNames(t *testing.T) {
+	veth, peer := vethNames("0123456789abcdef-testbridge")
+	if len(veth) != 15 || len(peer) != 15 {
+		t.Fatalf("interface names %s, %s exceed 15 characters", veth, peer)
+	}
+	if veth[4:] != peer[4:] {
+		t.Fatalf("veth %s and peer %s should share the same suffix", veth, peer)
+	}
+	// 前缀相同的 endpoint ID 也要生成不同的接口名
+	if other, _ := vethNames("0123456789abcdef-otherbridge"); other == veth {
+		t.Fatalf("endpoints with the same prefix got the same interface name %s", veth)
+	}
+	if again, _ := vethNames("0123456789abcdef-testbridge"); again != veth {
+		t.Fatalf("got %s and %s for the same endpoint", veth, again)
+	}
+}
@@ -1,20 +1,28 @@
 package network
 
 import (
-	"encoding/json"
+	"encoding/binary"
+	"fmt"
 	"net"
 	"os"
 	"path"
 	"strings"
 
 	"mydocker/constant"
+	"mydocker/store"
 	"mydocker/utils"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-const ipamDefaultAllocatorPath = "/var/lib/mydocker/network/ipam/subnet.json"
+// ipamSchemaVersion 网段地址分配信息文件的格式版本
+const ipamSchemaVersion = 1
+
+var (
+	ipamDefaultAllocatorPath = utils.DataRoot + "/network/ipam/subnet.json"
+	ipamStore                = store.New(ipamSchemaVersion, nil)
+)
 
 type IPAM struct {
 	SubnetAllocatorPath string             // 分配文件存放位置
@@ -28,6 +36,11 @@ var ipAllocator = &IPAM{
 
 /* Alloc 在网段中分配一个可用的 IP 地址 */
 func (ipam *IPAM) Allocate(subnet *net.IPNet) (ip net.IP, err error) {
+	unlock, err := ipam.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	// 存放网段中地址分配信息的数据
 	ipam.Subnets = &map[string]string{}
 
@@ -86,11 +99,51 @@ func (ipam *IPAM) Allocate(subnet *net.IPNet) (ip net.IP, err error) {
 	return
 }
 
+/* AllocateIP 在网段中分配指定的 IP 地址，IP 不在网段中或者已经被分配时返回错误 */
+func (ipam *IPAM) AllocateIP(subnet *net.IPNet, ip net.IP) error {
+	unlock, err := ipam.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	ipam.Subnets = &map[string]string{}
+	if err := ipam.load(); err != nil {
+		return errors.Wrap(err, "load subnet allocation info error")
+	}
+	_, subnet, _ = net.ParseCIDR(subnet.String())
+	one, size := subnet.Mask.Size()
+	if _, exist := (*ipam.Subnets)[subnet.String()]; !exist {
+		(*ipam.Subnets)[subnet.String()] = strings.Repeat("0", 1<<uint8(size-one))
+	}
+	// 和 Release 一样的算法，根据 IP 相对网段起始 IP 的偏移找到位图数组中的索引位置，IP 从 1 开始分配，因此需要减 1
+	allocIP := ip.To4()
+	if allocIP == nil || !subnet.Contains(allocIP) {
+		return fmt.Errorf("ip %s is not in subnet %s", ip, subnet)
+	}
+	c := int(binary.BigEndian.Uint32(allocIP)-binary.BigEndian.Uint32(subnet.IP.To4())) - 1
+	bitmap := (*ipam.Subnets)[subnet.String()]
+	if c < 0 || c >= len(bitmap) {
+		return fmt.Errorf("ip %s can not be allocated", ip)
+	}
+	if bitmap[c] == '1' {
+		return fmt.Errorf("ip %s has been allocated", ip)
+	}
+	ipalloc := []byte(bitmap)
+	ipalloc[c] = '1'
+	(*ipam.Subnets)[subnet.String()] = string(ipalloc)
+	return errors.Wrap(ipam.dump(), "dump ipam")
+}
+
 func (ipam *IPAM) Release(subnet *net.IPNet, ipaddr *net.IP) error {
+	unlock, err := ipam.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	ipam.Subnets = &map[string]string{}
 	_, subnet, _ = net.ParseCIDR(subnet.String())
 
-	err := ipam.load()
+	err = ipam.load()
 	if err != nil {
 		return errors.Wrap(err, "load subnet allocation info error")
 	}
@@ -114,54 +167,26 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ipaddr *net.IP) error {
 	return nil
 }
 
-/* load 加载网段地址分配信息 */
-func (ipam *IPAM) load() error {
-	// 检查存储文件状态，如果不存在，则说明之前没有分配，则不需要加载
-	exist, err := utils.PathExists(ipam.SubnetAllocatorPath)
-	if err != nil {
-		return errors.Wrapf(err, "Fail to judge whether dir %s exists.", ipam.SubnetAllocatorPath)
-	}
-	if !exist {
-		return nil
+/* lock 对网段地址分配信息加锁，分配和释放 IP 时需要在持有锁的情况下完成读取、修改、写回，避免并发分配出相同的 IP */
+func (ipam *IPAM) lock() (func(), error) {
+	ipamConfigFileDir, _ := path.Split(ipam.SubnetAllocatorPath)
+	if err := os.MkdirAll(ipamConfigFileDir, constant.Perm0755); err != nil {
+		return nil, errors.Wrapf(err, "Mkdir dir %s error.", ipamConfigFileDir)
 	}
+	return ipamStore.Lock(ipam.SubnetAllocatorPath)
+}
 
-	// 读取文件，加载配置信息
-	subnetConfigFile, err := os.Open(ipam.SubnetAllocatorPath)
-	if err != nil {
-		return errors.Wrapf(err, "Fail to open subnet config file [%s]", ipam.SubnetAllocatorPath)
-	}
-	defer subnetConfigFile.Close()
-	subnetJson := make([]byte, 2000)
-	n, err := subnetConfigFile.Read(subnetJson)
-	if err != nil {
-		return errors.Wrapf(err, "Fail to read subnet config file [%s]", ipam.SubnetAllocatorPath)
+/* load 加载网段地址分配信息 */
+func (ipam *IPAM) load() error {
+	// 如果存储文件不存在，则说明之前没有分配，则不需要加载
+	err := ipamStore.Load(ipam.SubnetAllocatorPath, ipam.Subnets)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "Fail to load subnet config file [%s]", ipam.SubnetAllocatorPath)
 	}
-	err = json.Unmarshal(subnetJson[:n], ipam.Subnets)
-	return errors.Wrap(err, "err dump allocation info")
+	return nil
 }
 
 /* dump 存储网段地址分配信息 */
 func (ipam *IPAM) dump() error {
-	ipamConfigFileDir, _ := path.Split(ipam.SubnetAllocatorPath)
-	exist, err := utils.PathExists(ipamConfigFileDir)
-	if err != nil {
-		return errors.Wrapf(err, "Fail to judge whether dir %s exists.", ipamConfigFileDir)
-	}
-	if !exist {
-		if err = os.MkdirAll(ipamConfigFileDir, constant.Perm0644); err != nil {
-			return errors.Wrapf(err, "Mkdir dir %s error.", ipamConfigFileDir)
-		}
-	}
-	// 打开存储文件 O_TRUNC 表示如果存在则消空，O_CREATE 表示如果不存在则创建
-	subnetConfigFile, err := os.OpenFile(ipam.SubnetAllocatorPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, constant.Perm0644)
-	if err != nil {
-		return err
-	}
-	defer subnetConfigFile.Close()
-	ipamConfigJson, err := json.Marshal(ipam.Subnets)
-	if err != nil {
-		return err
-	}
-	_, err = subnetConfigFile.Write(ipamConfigJson)
-	return err
+	return ipamStore.Save(ipam.SubnetAllocatorPath, ipam.Subnets, constant.Perm0644)
 }
@@ -22,3 +22,20 @@ func TestRelease(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestAllocateIP(t *testing.T) {
+	ip, ipNet, _ := net.ParseCIDR("192.168.1.8/24")
+	if err := ipAllocator.AllocateIP(ipNet, ip); err != nil {
+		t.Fatal(err)
+	}
+	// 已经分配的 IP 不能重复分配
+	if err := ipAllocator.AllocateIP(ipNet, ip); err == nil {
+		t.Fatalf("ip %s should have been allocated", ip)
+	}
+	if err := ipAllocator.Release(ipNet, &ip); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipAllocator.AllocateIP(ipNet, net.ParseIP("192.168.2.8")); err == nil {
+		t.Fatal("ip out of subnet should not be allocated")
+	}
+}
@@ -1,7 +1,6 @@
 package network
 
 import (
-	"encoding/json"
 	"fmt"
 	"net"
 	"os"
@@ -14,6 +13,7 @@ import (
 
 	"mydocker/constant"
 	"mydocker/container"
+	"mydocker/store"
 	"mydocker/utils"
 
 	"github.com/pkg/errors"
@@ -22,9 +22,13 @@ import (
 	"github.com/vishvananda/netns"
 )
 
+// networkSchemaVersion 网络配置文件的格式版本
+const networkSchemaVersion = 1
+
 var (
-	defaultNetworkPath = "/var/lib/mydocker/network/network/"
+	defaultNetworkPath = utils.DataRoot + "/network/network/"
 	drivers            = map[string]Driver{}
+	networkStore       = store.New(networkSchemaVersion, nil)
 )
 
 func init() {
@@ -34,12 +38,16 @@ func init() {
 
 	// 文件不存在则创建
 	exist, err := utils.PathExists(defaultNetworkPath)
+	// 使用 user namespace 的容器 init 进程没有权限访问网络配置目录，也不需要访问
+	if os.IsPermission(err) {
+		return
+	}
 	if err != nil {
 		log.Errorf("Fail to judge whether dir %s exists. %v", defaultNetworkPath, err)
 		return
 	}
 	if !exist {
-		if err = os.MkdirAll(defaultNetworkPath, constant.Perm0644); err != nil {
+		if err = os.MkdirAll(defaultNetworkPath, constant.Perm0755); err != nil {
 			log.Errorf("create %s failed,detail:%v", defaultNetworkPath, err)
 			return
 		}
@@ -54,27 +62,14 @@ func (net *Network) dump(dumpPath string) error {
 		return err
 	}
 	if !exist {
-		if err = os.MkdirAll(dumpPath, constant.Perm0644); err != nil {
+		if err = os.MkdirAll(dumpPath, constant.Perm0755); err != nil {
 			return errors.Wrapf(err, "create network dump path %s failed", dumpPath)
 		}
 	}
 
-	// 保存的文件名是网络的名字
+	// 保存的文件名是网络的名字，先写入临时文件再替换，避免写入过程中崩溃损坏配置文件
 	netPath := path.Join(dumpPath, net.Name)
-	// 打开保存的文件用于写入,后面打开的模式参数分别是存在内容则清空、只写入、不存在则创建
-	netFile, err := os.OpenFile(netPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, constant.Perm0644)
-	if err != nil {
-		return errors.Wrapf(err, "open file %s failed", dumpPath)
-	}
-	defer netFile.Close()
-
-	netJson, err := json.Marshal(net)
-	if err != nil {
-		return errors.Wrapf(err, "Marshal %v failed", net)
-	}
-
-	_, err = netFile.Write(netJson)
-	return errors.Wrapf(err, "write %s failed", netJson)
+	return errors.WithMessagef(networkStore.Save(netPath, net, constant.Perm0644), "save network %s failed", net.Name)
 }
 
 func (net *Network) remove(dumpPath string) error {
@@ -91,21 +86,8 @@ func (net *Network) remove(dumpPath string) error {
 }
 
 func (net *Network) load(dumpPath string) error {
-	// 打开配置文件
-	netConfigFile, err := os.Open(dumpPath)
-	if err != nil {
-		return err
-	}
-	defer netConfigFile.Close()
-	// 从配置文件中读取网络 配置 json 符串
-	netJson := make([]byte, 2000)
-	n, err := netConfigFile.Read(netJson)
-	if err != nil {
-		return err
-	}
-
-	err = json.Unmarshal(netJson[:n], net)
-	return errors.Wrapf(err, "unmarshal %s failed", netJson[:n])
+	// 从配置文件中读取网络配置
+	return errors.WithMessagef(networkStore.Load(dumpPath, net), "load network from %s failed", dumpPath)
 }
 
 /* 读取 defaultNetworkPath 目录下的 Network 信息存放到内存中，便于使用 */
@@ -114,8 +96,8 @@ func loadNetwork() (map[string]*Network, error) {
 
 	// 检查网络配置目录中的所有文件，并执行第二个参数中的函数指针去处理目录下的每一个文件
 	loadErr := filepath.Walk(defaultNetworkPath, func(netPath string, info os.FileInfo, err error) error {
-		// 如果是目录则跳过
-		if info.IsDir() {
+		// 如果是目录或者锁文件、临时文件则跳过
+		if info.IsDir() || store.IsInternalFile(info.Name()) {
 			return nil
 		}
 		// 加载文件名为网络名
@@ -134,6 +116,19 @@ func loadNetwork() (map[string]*Network, error) {
 	return networks, loadErr
 }
 
+// GetNetwork 根据网络名获取网络信息
+func GetNetwork(networkName string) (*Network, error) {
+	networks, err := loadNetwork()
+	if err != nil {
+		return nil, errors.WithMessage(err, "load network from file failed")
+	}
+	network, ok := networks[networkName]
+	if !ok {
+		return nil, fmt.Errorf("no such network: %s", networkName)
+	}
+	return network, nil
+}
+
 /* 根据不同 driver 创建 Network */
 func CreateNetwork(driver, subnet, name string) error {
 	// 将网段的字符串转换成 net.IPNet 对象
@@ -203,6 +198,9 @@ func DeleteNetwork(networkName string) error {
 
 /* 连接容器到之前创建的网络 mydocker run -net testnet -p 8080:80 xxxx */
 func Connect(networkName string, info *container.Info) (net.IP, error) {
+	if networkName == SlirpNetwork {
+		return connectSlirp(info)
+	}
 	networks, err := loadNetwork()
 	if err != nil {
 		return nil, errors.WithMessage(err, "load network from file failed")
@@ -213,8 +211,8 @@ func Connect(networkName string, info *container.Info) (net.IP, error) {
 		return nil, fmt.Errorf("no Such Network: %s", networkName)
 	}
 
-	// 分配容器 IP 地址
-	ip, err := ipAllocator.Allocate(network.IPRange)
+	// 分配容器 IP 地址，重新启动的容器优先使用之前的 IP
+	ip, err := allocateIP(network.IPRange, info.IP)
 	if err != nil {
 		return ip, errors.Wrapf(err, "allocate ip")
 	}
@@ -237,8 +235,24 @@ func Connect(networkName string, info *container.Info) (net.IP, error) {
 	return ip, addPortMapping(ep)
 }
 
+// allocateIP 如果指定的 IP 没有被占用则分配该 IP，否则重新分配一个可用的 IP
+func allocateIP(subnet *net.IPNet, preferred string) (net.IP, error) {
+	if ip := net.ParseIP(preferred); ip != nil {
+		err := ipAllocator.AllocateIP(subnet, ip)
+		if err == nil {
+			return ip, nil
+		}
+		log.Infof("Can not reuse ip %s, allocate a new one: %v", preferred, err)
+	}
+	return ipAllocator.Allocate(subnet)
+}
+
 /* 将容器中指定网络移除 */
 func Disconnect(networkName string, info *container.Info) error {
+	if networkName == SlirpNetwork {
+		disconnectSlirp(info)
+		return nil
+	}
 	networks, err := loadNetwork()
 	if err != nil {
 		return errors.WithMessage(err, "load network from file failed")
@@ -0,0 +1,87 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+
+	"mydocker/container"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// SlirpNetwork 使用 slirp4netns 的用户态网络，不需要创建 bridge 和 veth，普通用户也可以使用，rootless 模式下默认使用
+	SlirpNetwork = "slirp4netns"
+	// slirpTapDevice slirp4netns 在容器中创建的 tap 设备
+	slirpTapDevice = "tap0"
+	// slirpIP slirp4netns --configure 为容器配置的固定 IP，网关为 10.0.2.2，DNS 为 10.0.2.3
+	slirpIP = "10.0.2.100"
+)
+
+var (
+	// slirpExitPipes key 为容器 Id，value 为 slirp4netns --exit-fd 管道的写端，关闭后 slirp4netns 退出
+	// 当前进程退出时管道也会被关闭，因此 slirp4netns 不会比负责容器的 monitor 进程存活得更久
+	slirpExitPipes = map[string]*os.File{}
+	slirpLock      sync.Mutex
+)
+
+/*
+ * connectSlirp 为容器启动 slirp4netns，容器通过 tap 设备访问外部网络
+ * 1. --configure 由 slirp4netns 在容器中配置 IP、路由和 DNS，--disable-host-loopback 禁止容器访问宿主机的 127.0.0.1
+ * 2. 通过 --ready-fd 等待配置完成，通过 --exit-fd 控制 slirp4netns 的生命周期
+ */
+func connectSlirp(info *container.Info) (net.IP, error) {
+	slirp, err := exec.LookPath("slirp4netns")
+	if err != nil {
+		return nil, errors.Wrap(err, "slirp4netns is required for network "+SlirpNetwork)
+	}
+	exitReadPipe, exitWritePipe, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "new exit pipe")
+	}
+	readyReadPipe, readyWritePipe, err := os.Pipe()
+	if err != nil {
+		_ = exitReadPipe.Close()
+		_ = exitWritePipe.Close()
+		return nil, errors.Wrap(err, "new ready pipe")
+	}
+	defer readyReadPipe.Close()
+
+	// ExtraFiles 在子进程中的 fd 从 3 开始
+	cmd := exec.Command(slirp, "--configure", "--mtu=65520", "--disable-host-loopback",
+		"--exit-fd=3", "--ready-fd=4", info.Pid, slirpTapDevice)
+	cmd.ExtraFiles = []*os.File{exitReadPipe, readyWritePipe}
+	err = cmd.Start()
+	_ = exitReadPipe.Close()
+	_ = readyWritePipe.Close()
+	if err != nil {
+		_ = exitWritePipe.Close()
+		return nil, errors.Wrap(err, "start slirp4netns")
+	}
+	go func() { _ = cmd.Wait() }()
+
+	// 配置完成后 slirp4netns 会向 ready-fd 写入 1，启动失败时直接退出，读取到 EOF
+	buf := make([]byte, 1)
+	if _, err = readyReadPipe.Read(buf); err != nil {
+		_ = exitWritePipe.Close()
+		return nil, fmt.Errorf("slirp4netns exited before network is ready")
+	}
+
+	slirpLock.Lock()
+	slirpExitPipes[info.Id] = exitWritePipe
+	slirpLock.Unlock()
+	return net.ParseIP(slirpIP), nil
+}
+
+// disconnectSlirp 通知容器的 slirp4netns 退出，slirp4netns 不是由当前进程启动的时候什么也不做
+func disconnectSlirp(info *container.Info) {
+	slirpLock.Lock()
+	defer slirpLock.Unlock()
+	if exitPipe, ok := slirpExitPipes[info.Id]; ok {
+		_ = exitPipe.Close()
+		delete(slirpExitPipes, info.Id)
+	}
+}
@@ -9,6 +9,7 @@ package nsenter
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <sys/stat.h>
 
 __attribute__((constructor)) void enter_namespace(void) {
 	// 这里的代码会在 Go 运行时启动前执行，它会在单线程的 C 上下文中运行
@@ -34,6 +35,22 @@ __attribute__((constructor)) void enter_namespace(void) {
 
 	int i;
 	char nspath[1024];
+	// 容器使用了 user namespace 时需要最先进入，之后才有权限进入属于该 user namespace 的其他 namespace
+	// 与当前进程处于同一个 user namespace 时 setns 会返回 EINVAL，因此先比较两者是否相同
+	struct stat self_ns, target_ns;
+	sprintf(nspath, "/proc/%s/ns/user", mydocker_pid);
+	if (stat("/proc/self/ns/user", &self_ns) == 0 && stat(nspath, &target_ns) == 0 &&
+		(self_ns.st_dev != target_ns.st_dev || self_ns.st_ino != target_ns.st_ino)) {
+		int fd = open(nspath, O_RDONLY);
+		if (setns(fd, CLONE_NEWUSER) == -1) {
+			fprintf(stderr, "setns on user namespace failed: %s\n", strerror(errno));
+		}
+		close(fd);
+		// 进入 user namespace 后切换为容器内的 root
+		if (setresgid(0, 0, 0) == -1 || setresuid(0, 0, 0) == -1) {
+			fprintf(stderr, "switch to root in user namespace failed: %s\n", strerror(errno));
+		}
+	}
 	// 需要进入的 5 种namespace
 	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
 	for (i = 0; i < 5; i++) {
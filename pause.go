@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"mydocker/cgroups"
+	"mydocker/container"
+
+	"github.com/pkg/errors"
+)
+
+// PauseContainer 通过 cgroup freezer 挂起容器中的所有进程，进程的状态都会被保留
+// 持有容器的锁完成检查、冻结和状态修改，避免与 monitor 进程记录容器退出等修改互相覆盖
+func PauseContainer(containerId string) error {
+	_, err := container.ModifyContainerInfo(containerId, func(info *container.Info) error {
+		if info.Status != container.RUNNING {
+			return fmt.Errorf("container %s is not running, status: %s", containerId, info.Status)
+		}
+		if err := freezeContainer(info, true); err != nil {
+			return err
+		}
+		info.Status = container.PAUSED
+		return nil
+	})
+	return err
+}
+
+// UnpauseContainer 恢复被挂起的容器
+func UnpauseContainer(containerId string) error {
+	_, err := container.ModifyContainerInfo(containerId, func(info *container.Info) error {
+		if info.Status != container.PAUSED {
+			return fmt.Errorf("container %s is not paused, status: %s", containerId, info.Status)
+		}
+		if err := freezeContainer(info, false); err != nil {
+			return err
+		}
+		info.Status = container.RUNNING
+		return nil
+	})
+	return err
+}
+
+func freezeContainer(containerInfo *container.Info, frozen bool) error {
+	if containerInfo.CgroupPath == "" {
+		return fmt.Errorf("container %s has no cgroup", containerInfo.Id)
+	}
+	err := cgroups.NewCgroupManager(containerInfo.CgroupPath).Freeze(frozen)
+	return errors.WithMessagef(err, "freeze container %s to %v", containerInfo.Id, frozen)
+}
@@ -1,81 +1,287 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"os/exec"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"mydocker/cgroups"
 	"mydocker/cgroups/subsystems"
+	"mydocker/constant"
 	"mydocker/container"
 	"mydocker/network"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-func Run(tty bool, cmdArray, envSlice, portMapping []string, res *subsystems.ResourceConfig,
-	volume, containerName, imageName, net string) {
+/*
+ * Run 创建并启动容器
+ * 1. 记录容器信息，此时容器状态为 created
+ * 2. 后台运行时启动 monitor 进程，由 monitor 进程启动容器并负责回收容器进程，run 命令在容器启动后直接返回
+ * 3. 前台运行时由当前进程启动容器并等待容器退出，autoRemove 为 true 时容器退出后自动删除
+ */
+func Run(tty bool, initConfig *container.InitConfig, portMapping []string, res *subsystems.ResourceConfig,
+	volume, containerName, imageName, net string, oomScoreAdj int, restartPolicy *container.RestartPolicy,
+	stopSignal string, autoRemove bool) error {
 	// 生成容器 ID
-	containerId := container.GenerateContainerID()
+	containerId, err := container.GenerateContainerID()
+	if err != nil {
+		return errors.WithMessage(err, "generate container id")
+	}
+	// 与 docker 一致，没有指定主机名时使用容器的短 ID
+	if initConfig.Hostname == "" {
+		initConfig.Hostname = container.ShortID(containerId)
+	}
+	containerInfo := &container.Info{
+		Id:            containerId,
+		Name:          containerName,
+		Command:       strings.Join(initConfig.Args, " "),
+		Volume:        volume,
+		NetworkName:   net,
+		PortMapping:   portMapping,
+		CgroupPath:    cgroups.ContainerCgroupPath(containerId),
+		Resource:      res,
+		OomScoreAdj:   oomScoreAdj,
+		Image:         imageName,
+		InitConfig:    initConfig,
+		RestartPolicy: restartPolicy,
+		StopSignal:    stopSignal,
+		AutoRemove:    autoRemove,
+	}
+	if err := container.RecordContainerInfo(containerInfo); err != nil {
+		return errors.WithMessage(err, "record container info")
+	}
+
+	if !tty {
+		if err := startMonitor(containerId); err != nil {
+			deleteCreatedContainer(containerId)
+			return err
+		}
+		return nil
+	}
+
+	parent, oomCh, err := startContainer(containerInfo, tty)
+	if err != nil {
+		deleteCreatedContainer(containerId)
+		return err
+	}
+	superviseContainer(containerInfo, parent, oomCh, tty)
+	return nil
+}
+
+/*
+ * startContainer 根据容器信息启动容器，返回容器 init 进程以及 OOM 事件通知
+ * 1. 持有容器的锁将容器状态修改为 starting，同一个容器同时只能有一个启动过程，并发的启动请求直接返回错误
+ * 2. 创建容器 init 进程，设置 cgroup、网络并更新容器信息
+ * 3. 通过管道将 InitConfig 发送给 init 进程，并通过同步管道等待 init 进程完成初始化
+ * 4. 启动失败时杀死 init 进程并清理本次启动创建的资源，容器恢复为启动前的状态，容器信息由调用方处理
+ */
+func startContainer(containerInfo *container.Info, tty bool) (*exec.Cmd, <-chan struct{}, error) {
+	containerId := containerInfo.Id
+	status := containerInfo.Status
+	// 记录当前进程为 monitor 进程，当前进程在启动过程中异常退出时 ps 可以发现并修正容器状态
+	if _, err := container.ModifyContainerInfo(containerId, func(info *container.Info) error {
+		if info.Status != status || !isStartable(status) {
+			return fmt.Errorf("container %s can not be started, status: %s", containerId, info.Status)
+		}
+		info.Status = container.STARTING
+		info.MonitorPid = os.Getpid()
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	// restore 将容器恢复为启动前的状态，只恢复本次启动写入的状态，期间被其他命令修改过的状态保持不变
+	restore := func() {
+		pid := containerInfo.Pid
+		if _, err := container.ModifyContainerInfo(containerId, func(info *container.Info) error {
+			if info.Status == container.STARTING || (info.Status == container.RUNNING && info.Pid == pid) {
+				info.Status = status
+				info.Pid = ""
+			}
+			return nil
+		}); err != nil {
+			log.Errorf("Update container %s info error %v", containerId, err)
+		}
+		containerInfo.Status = status
+		containerInfo.Pid = ""
+	}
 
-	parent, writePipe := container.NewParentProcess(tty, volume, containerId, imageName, envSlice)
+	parent, writePipe, syncPipe := container.NewParentProcess(tty, containerInfo.Volume, containerId, containerInfo.Image,
+		containerInfo.InitConfig)
 	if parent == nil {
-		log.Errorf("New parent process error")
-		return
+		restore()
+		return nil, nil, errors.New("new parent process error")
 	}
 	if err := parent.Start(); err != nil {
-		log.Errorf("Run parent.Start() error: %v", err)
-		return
+		restore()
+		return nil, nil, errors.Wrap(err, "start parent process")
+	}
+	// 子进程已经继承了管道的另一端，父进程中需要关闭，否则读取同步管道时永远读不到 EOF
+	for _, file := range parent.ExtraFiles {
+		_ = file.Close()
+	}
+
+	// 为每个容器创建以容器 ID 命名的 cgroup manager，并通过调用 set 和 apply 设置资源限制并使限制在容器上生效
+	// NOTE: 这里不能 defer Destroy，否则后台运行的容器在父进程退出后 cgroup 就被删除了，资源限制随之失效
+	// 容器退出后保留 cgroup 以便重新启动，cgroup 只在 rm 容器（包括 --rm 自动删除）或者启动失败时才会被删除
+	cgroupManager := cgroups.NewCgroupManager(containerInfo.CgroupPath)
+	containerInfo.Pid = strconv.Itoa(parent.Process.Pid)
+	cgroupCreated := false
+	connected := false
+	var oomKillCount *uint64
+	// fail 启动失败时杀死 init 进程并清理本次启动创建的资源，容器恢复为启动前的状态
+	// 容器处于 starting 状态，工作空间只会被本次启动挂载，重新启动的容器只卸载工作空间，保留 upper 层中的数据
+	fail := func(err error) (*exec.Cmd, <-chan struct{}, error) {
+		_ = parent.Process.Kill()
+		_ = parent.Wait()
+		if cgroupCreated {
+			_ = cgroupManager.Destroy()
+		}
+		if status == container.CREATED {
+			container.DeleteWorkSpace(containerId, containerInfo.Volume)
+		} else {
+			container.UnmountWorkSpace(containerId, containerInfo.Volume)
+		}
+		if connected {
+			_ = network.Disconnect(containerInfo.NetworkName, containerInfo)
+		}
+		restore()
+		return nil, nil, err
+	}
+
+	// 需要通过 newuidmap 写入 user namespace 的映射时，必须在子进程读取到 InitConfig 之前写入
+	if err := container.ApplyIDMappings(parent.Process.Pid, containerInfo.InitConfig.UidMappings,
+		containerInfo.InitConfig.GidMappings); err != nil {
+		return fail(errors.WithMessage(err, "set id mappings"))
 	}
 
-	// 创建 cgroup manager，并通过调用 set 和 apply 设置资源限制并使限制在容器上生效
-	cgroupManager := cgroups.NewCgroupManager("mydocker-cgroup")
-	defer cgroupManager.Destroy()
-	_ = cgroupManager.Set(res)
-	_ = cgroupManager.Apply(parent.Process.Pid, res)
+	// 此时子进程还阻塞在读取管道上，设置的 oom_score_adj 会在 exec 之后被用户进程继承
+	if containerInfo.OomScoreAdj != 0 {
+		if err := setOomScoreAdj(parent.Process.Pid, containerInfo.OomScoreAdj); err != nil {
+			log.Errorf("Set oom_score_adj error: %v", err)
+		}
+	}
+	// rootless 模式下没有可用的 cgroup 时 CgroupPath 为空，不设置资源限制
+	// 资源限制设置失败时不能让容器在没有限制的情况下运行，直接返回错误
+	if containerInfo.CgroupPath != "" {
+		cgroupCreated = true
+		if err := cgroupManager.Set(containerInfo.Resource); err != nil {
+			return fail(errors.WithMessage(err, "set cgroup"))
+		}
+		if err := cgroupManager.Apply(parent.Process.Pid, containerInfo.Resource); err != nil {
+			return fail(errors.WithMessage(err, "apply cgroup"))
+		}
+		// cgroup 在容器重新启动后仍然保留，oom_kill 计数是 cgroup 整个生命周期的累计值，记录启动时的计数用于判断本次运行是否发生了 OOM
+		if stats, err := cgroupManager.GetStats(); err == nil {
+			oomKillCount = &stats.OOMKill
+		}
+	}
 
-	var containerIP string
 	// 如果制定了网络信息则进行配置
-	if net != "" {
+	if containerInfo.NetworkName != "" {
 		// config container network
-		containerInfo := &container.Info{
-			Pid:         strconv.Itoa(parent.Process.Pid),
-			Id:          containerId,
-			Name:        containerName,
-			PortMapping: portMapping,
-		}
-		ip, err := network.Connect(net, containerInfo)
+		ip, err := network.Connect(containerInfo.NetworkName, containerInfo)
 		if err != nil {
-			log.Errorf("Error Connect Network %v", err)
-			return
+			return fail(errors.WithMessage(err, "connect network"))
 		}
-		containerIP = ip.String()
+		connected = true
+		containerInfo.IP = ip.String()
 	}
 
-	// record container info
-	containerInfo, err := container.RecordContainerInfo(parent.Process.Pid, cmdArray, portMapping,
-		containerName, containerId, volume, net, containerIP)
+	// 当前进程负责回收容器进程，记录下来供 stop 等命令判断容器退出状态由谁记录
+	latestInfo, err := container.ModifyContainerInfo(containerId, func(info *container.Info) error {
+		if info.Status != container.STARTING {
+			return fmt.Errorf("container %s status changed to %s while starting", containerId, info.Status)
+		}
+		info.Status = container.RUNNING
+		info.Pid = containerInfo.Pid
+		info.IP = containerInfo.IP
+		info.RestartCount = containerInfo.RestartCount
+		info.MonitorPid = os.Getpid()
+		info.ExitCode = 0
+		info.OOMKilled = false
+		info.OOMKillCount = oomKillCount
+		info.StartedAt = time.Now().Format(container.TimeFormat)
+		info.FinishedAt = ""
+		info.ManualStopped = false
+		return nil
+	})
 	if err != nil {
-		log.Errorf("Record container info error %v", err)
+		return fail(errors.WithMessage(err, "update container info"))
+	}
+	*containerInfo = *latestInfo
+
+	// 在用户进程启动前开始监听 OOM 事件
+	var oomCh <-chan struct{}
+	if containerInfo.CgroupPath != "" {
+		if oomCh, err = cgroupManager.NotifyOOM(); err != nil {
+			log.Warnf("Watch container oom event error %v", err)
+		}
+	}
+
+	// 在子进程创建后通过管道来发送参数，并等待子进程完成初始化
+	if err := container.SendInitConfig(writePipe, containerInfo.InitConfig); err != nil {
+		return fail(err)
+	}
+	if err := container.ReadSyncPipe(syncPipe); err != nil {
+		return fail(errors.WithMessage(err, "init container"))
+	}
+	return parent, oomCh, nil
+}
+
+// isStartable 判断处于 status 状态的容器是否可以启动
+func isStartable(status string) bool {
+	switch status {
+	case container.CREATED, container.Exit, container.STOP, container.RESTARTING:
+		return true
+	}
+	return false
+}
+
+// deleteCreatedContainer 删除启动失败的容器的信息，容器已经被并发的 start 命令启动时保留
+func deleteCreatedContainer(containerId string) {
+	if info, err := container.GetInfoByContainerId(containerId); err == nil && info.Status != container.CREATED {
 		return
 	}
+	_ = container.DeleteContainerInfo(containerId)
+}
 
-	// 在子进程创建后通过管道来发送参数
-	sendInitCommand(cmdArray, writePipe)
-	// 如果是 tty，那么父进程等待，就是前台运行；否则就是跳过，实现后台运行
-	if tty {
-		_ = parent.Wait()
-		container.DeleteWorkSpace(containerId, volume)
-		container.DeleteContainerInfo(containerId)
-		if net != "" {
-			network.Disconnect(net, containerInfo)
+// setOomScoreAdj 设置进程的 oom_score_adj，取值范围为 [-1000, 1000]，值越大越容易被 OOM killer 选中
+func setOomScoreAdj(pid, oomScoreAdj int) error {
+	oomScoreAdjPath := fmt.Sprintf("/proc/%d/oom_score_adj", pid)
+	return os.WriteFile(oomScoreAdjPath, []byte(strconv.Itoa(oomScoreAdj)), constant.Perm0644)
+}
+
+// isOOMKilled 判断容器本次运行期间是否发生过 OOM，oomKillCount 为容器启动时的 oom_kill 计数，计数增加说明发生过 OOM
+// 启动时没有读取到计数时只使用本次运行监听到的 OOM 事件
+func isOOMKilled(oomCh <-chan struct{}, cgroupManager *cgroups.CgroupManager, oomKillCount *uint64) bool {
+	if cgroupManager.Path == "" {
+		return false
+	}
+	if oomKillCount != nil {
+		if stats, err := cgroupManager.GetStats(); err == nil {
+			return stats.OOMKill > *oomKillCount
 		}
 	}
+	if oomCh != nil {
+		select {
+		case _, ok := <-oomCh:
+			return ok
+		default:
+		}
+	}
+	return false
 }
 
-func sendInitCommand(cmdArray []string, writePipe *os.File) {
-	command := strings.Join(cmdArray, " ")
-	log.Infof("command all is %s", command)
-	_, _ = writePipe.WriteString(command)
-	_ = writePipe.Close()
+// exitCode 获取进程的退出码，被信号杀死时与 shell 保持一致，返回 128 + 信号值
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
 }
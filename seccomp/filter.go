@@ -0,0 +1,324 @@
+package seccomp
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// seccomp 过滤程序的返回值，定义在 linux/seccomp.h 中，低 16 位为附加数据，e.g. errno
+const (
+	retKillProcess = 0x80000000
+	retKillThread  = 0x00000000
+	retTrap        = 0x00030000
+	retErrno       = 0x00050000
+	retTrace       = 0x7ff00000
+	retLog         = 0x7ffc0000
+	retAllow       = 0x7fff0000
+	retDataMask    = 0x0000ffff
+)
+
+const (
+	// seccompSetModeFilter seccomp(2) 的 SECCOMP_SET_MODE_FILTER 操作
+	seccompSetModeFilter = 1
+	// seccompFilterFlagTsync 将过滤程序同步到进程的所有线程
+	seccompFilterFlagTsync = 1
+	// bpfMaxInstructions 内核允许的过滤程序最大指令数
+	bpfMaxInstructions = 4096
+	// x32SyscallBit x86_64 上 x32 ABI 的系统调用号带有这个标志，arch 与 x86_64 相同，需要单独屏蔽
+	x32SyscallBit = 0x40000000
+)
+
+// seccomp_data 结构中各字段的偏移，参数为 64 位，小端序下低 32 位在前
+const (
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+)
+
+// defaultErrno SCMP_ACT_ERRNO 没有指定 errnoRet 时返回 EPERM，与 docker 一致
+const defaultErrno = uint(unix.EPERM)
+
+// blockEnd 跳转到当前规则末尾，即下一条规则的开头
+const blockEnd = -1
+
+// instruction 编译过程中的 BPF 指令，jt、jf 为跳转目标在规则内的位置，最后再换算成相对偏移
+type instruction struct {
+	code   uint16
+	k      uint32
+	jt, jf int
+}
+
+func load(offset uint32) instruction {
+	return instruction{code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, k: offset}
+}
+
+func jump(op uint16, k uint32, jt, jf int) instruction {
+	return instruction{code: unix.BPF_JMP | op | unix.BPF_K, k: k, jt: jt, jf: jf}
+}
+
+func ret(k uint32) instruction {
+	return instruction{code: unix.BPF_RET | unix.BPF_K, k: k}
+}
+
+/*
+ * Compile 将 seccomp 配置编译为本机架构的 BPF 过滤程序，caps 为容器拥有的 capability，用于判断规则的 includes、excludes
+ * 程序的结构如下，所有跳转都是向前的：
+ * 1. 检查 seccomp_data.arch，非本机架构（以及 x86_64 上的 x32 ABI）的系统调用返回 EPERM，防止通过其他 ABI 绕过过滤
+ * 2. 按配置中的顺序为每个系统调用生成一段规则：比较系统调用号，再依次比较参数，全部满足时返回规则的动作，否则进入下一段
+ * 3. 默认动作为拒绝时，比系统调用表更新的系统调用返回 ENOSYS，与 runc 一致，这样 libc 可以回退到旧的系统调用
+ * 4. 没有匹配任何规则时返回默认动作
+ * 本机架构上不存在的系统调用会被忽略，与 docker 一致
+ */
+func Compile(profile *Profile, caps []string) ([]unix.SockFilter, error) {
+	if nativeArch == 0 {
+		return nil, fmt.Errorf("seccomp is not supported on %s", runtime.GOARCH)
+	}
+	defaultRet, err := actionRet(profile.DefaultAction, profile.DefaultErrnoRet)
+	if err != nil {
+		return nil, errors.Wrap(err, "default action")
+	}
+	capSet := make(map[string]bool, len(caps))
+	for _, c := range caps {
+		capSet[c] = true
+	}
+
+	archBlock := []instruction{
+		load(offsetArch),
+		jump(unix.BPF_JEQ, nativeArch, 3, 0),
+		ret(retErrno | uint32(defaultErrno)),
+	}
+	if nativeArch == unix.AUDIT_ARCH_X86_64 {
+		archBlock = append(archBlock,
+			load(offsetNr),
+			jump(unix.BPF_JGE, x32SyscallBit, 0, blockEnd),
+			ret(retErrno|uint32(defaultErrno)),
+		)
+	}
+	filter, err := resolve(archBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, syscall := range profile.Syscalls {
+		if !syscall.applies(capSet) {
+			continue
+		}
+		action, err := actionRet(syscall.Action, syscall.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		names := syscall.Names
+		if syscall.Name != "" {
+			names = append([]string{syscall.Name}, names...)
+		}
+		for _, name := range names {
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				continue
+			}
+			block, err := ruleBlock(uint32(nr), syscall.Args, action)
+			if err != nil {
+				return nil, errors.Wrapf(err, "syscall %s", name)
+			}
+			instructions, err := resolve(block)
+			if err != nil {
+				return nil, errors.Wrapf(err, "syscall %s", name)
+			}
+			filter = append(filter, instructions...)
+		}
+	}
+	if profile.DefaultAction != ActAllow && profile.DefaultAction != ActLog {
+		instructions, err := resolve([]instruction{
+			load(offsetNr),
+			jump(unix.BPF_JGT, uint32(maxSyscallNumber()), 2, blockEnd),
+			ret(retErrno | uint32(unix.ENOSYS)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, instructions...)
+	}
+	filter = append(filter, unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: defaultRet})
+	if len(filter) > bpfMaxInstructions {
+		return nil, fmt.Errorf("seccomp filter has %d instructions, more than %d", len(filter), bpfMaxInstructions)
+	}
+	return filter, nil
+}
+
+// ruleBlock 生成一个系统调用的规则：系统调用号与所有参数条件都满足时返回 action，否则跳到规则末尾
+func ruleBlock(nr uint32, args []*Arg, action uint32) ([]instruction, error) {
+	block := []instruction{
+		load(offsetNr),
+		jump(unix.BPF_JEQ, nr, 0, blockEnd),
+	}
+	for _, arg := range args {
+		if arg.Index >= 6 {
+			return nil, fmt.Errorf("invalid argument index %d", arg.Index)
+		}
+		cond, err := argCondition(arg, len(block))
+		if err != nil {
+			return nil, err
+		}
+		block = append(block, cond...)
+	}
+	return append(block, ret(action)), nil
+}
+
+/*
+ * argCondition 生成一个 64 位参数的比较，start 为条件在规则中的起始位置
+ * BPF 只能做 32 位比较，先比较高 32 位，高 32 位相等时再比较低 32 位
+ * 满足条件时跳到条件之后的指令（pass），不满足时跳到规则末尾
+ */
+func argCondition(arg *Arg, start int) ([]instruction, error) {
+	lo := offsetArgs + 8*uint32(arg.Index)
+	hi := lo + 4
+	valueHi, valueLo := uint32(arg.Value>>32), uint32(arg.Value)
+	switch arg.Op {
+	case OpEqualTo:
+		pass := start + 4
+		return []instruction{
+			load(hi),
+			jump(unix.BPF_JEQ, valueHi, start+2, blockEnd),
+			load(lo),
+			jump(unix.BPF_JEQ, valueLo, pass, blockEnd),
+		}, nil
+	case OpNotEqual:
+		pass := start + 4
+		return []instruction{
+			load(hi),
+			jump(unix.BPF_JEQ, valueHi, start+2, pass),
+			load(lo),
+			jump(unix.BPF_JEQ, valueLo, blockEnd, pass),
+		}, nil
+	case OpGreaterThan, OpGreaterEqual:
+		pass := start + 5
+		op := uint16(unix.BPF_JGT)
+		if arg.Op == OpGreaterEqual {
+			op = unix.BPF_JGE
+		}
+		return []instruction{
+			load(hi),
+			jump(unix.BPF_JGT, valueHi, pass, start+2),
+			jump(unix.BPF_JEQ, valueHi, start+3, blockEnd),
+			load(lo),
+			jump(op, valueLo, pass, blockEnd),
+		}, nil
+	case OpLessThan, OpLessEqual:
+		// a < b 即 !(a >= b)，a <= b 即 !(a > b)
+		pass := start + 5
+		op := uint16(unix.BPF_JGE)
+		if arg.Op == OpLessEqual {
+			op = unix.BPF_JGT
+		}
+		return []instruction{
+			load(hi),
+			jump(unix.BPF_JGT, valueHi, blockEnd, start+2),
+			jump(unix.BPF_JEQ, valueHi, start+3, pass),
+			load(lo),
+			jump(op, valueLo, blockEnd, pass),
+		}, nil
+	case OpMaskedEqual:
+		pass := start + 6
+		return []instruction{
+			load(hi),
+			{code: unix.BPF_ALU | unix.BPF_AND | unix.BPF_K, k: valueHi},
+			jump(unix.BPF_JEQ, uint32(arg.ValueTwo>>32), start+3, blockEnd),
+			load(lo),
+			{code: unix.BPF_ALU | unix.BPF_AND | unix.BPF_K, k: valueLo},
+			jump(unix.BPF_JEQ, uint32(arg.ValueTwo), pass, blockEnd),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported operator %q", arg.Op)
+	}
+}
+
+// resolve 将规则中跳转目标的位置换算为 BPF 的相对偏移，BPF 的跳转偏移只有 8 位
+func resolve(block []instruction) ([]unix.SockFilter, error) {
+	filter := make([]unix.SockFilter, len(block))
+	for i, inst := range block {
+		filter[i] = unix.SockFilter{Code: inst.code, K: inst.k}
+		if inst.code&0x07 != unix.BPF_JMP {
+			continue
+		}
+		jt, err := jumpOffset(i, inst.jt, len(block))
+		if err != nil {
+			return nil, err
+		}
+		jf, err := jumpOffset(i, inst.jf, len(block))
+		if err != nil {
+			return nil, err
+		}
+		filter[i].Jt, filter[i].Jf = jt, jf
+	}
+	return filter, nil
+}
+
+// jumpOffset 计算从第 i 条指令跳转到 target 的相对偏移，target 为 0 表示下一条指令
+func jumpOffset(i, target, length int) (uint8, error) {
+	if target == blockEnd {
+		target = length
+	}
+	if target == 0 {
+		return 0, nil
+	}
+	offset := target - i - 1
+	if offset < 0 || offset > 0xff {
+		return 0, fmt.Errorf("invalid jump from %d to %d", i, target)
+	}
+	return uint8(offset), nil
+}
+
+// actionRet 将配置中的动作转换为过滤程序的返回值
+func actionRet(action Action, errnoRet *uint) (uint32, error) {
+	switch action {
+	case ActKill, ActKillThread:
+		return retKillThread, nil
+	case ActKillProcess:
+		return retKillProcess, nil
+	case ActTrap:
+		return retTrap, nil
+	case ActErrno:
+		errno := defaultErrno
+		if errnoRet != nil {
+			errno = *errnoRet
+		}
+		return retErrno | uint32(errno)&retDataMask, nil
+	case ActTrace:
+		errno := defaultErrno
+		if errnoRet != nil {
+			errno = *errnoRet
+		}
+		return retTrace | uint32(errno)&retDataMask, nil
+	case ActAllow:
+		return retAllow, nil
+	case ActLog:
+		return retLog, nil
+	default:
+		return 0, fmt.Errorf("unsupported seccomp action %q", action)
+	}
+}
+
+/*
+ * Install 为当前进程安装 seccomp 过滤程序，exec 之后仍然生效，且无法再被移除
+ * 调用者需要拥有 CAP_SYS_ADMIN 或者已经在当前线程上设置了 PR_SET_NO_NEW_PRIVS，因此调用者需要将 goroutine 固定在当前线程上
+ * 使用 TSYNC 同步到 Go runtime 的所有线程，避免 exec 之前在其他线程上执行的系统调用不受限制
+ */
+func Install(filter []unix.SockFilter) error {
+	if len(filter) == 0 {
+		return errors.New("empty seccomp filter")
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	r1, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	runtime.KeepAlive(filter)
+	if errno != 0 {
+		return errors.Wrap(errno, "install seccomp filter")
+	}
+	if r1 != 0 {
+		return fmt.Errorf("install seccomp filter: thread %d can not be synchronized", r1)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package seccomp
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// seccompData 测试用的 seccomp_data，与内核中的结构一致
+type seccompData struct {
+	nr   int32
+	arch uint32
+	args [6]uint64
+}
+
+// bytes 按小端序编码，BPF_ABS 按偏移读取其中的 32 位字段
+func (d seccompData) bytes() []byte {
+	buf := make([]byte, 64)
+	binary.LittleEndian.PutUint32(buf[offsetNr:], uint32(d.nr))
+	binary.LittleEndian.PutUint32(buf[offsetArch:], d.arch)
+	for i, arg := range d.args {
+		binary.LittleEndian.PutUint64(buf[offsetArgs+8*i:], arg)
+	}
+	return buf
+}
+
+// run 解释执行过滤程序，只支持 Compile 会生成的指令
+func run(t *testing.T, filter []unix.SockFilter, data seccompData) uint32 {
+	t.Helper()
+	input := data.bytes()
+	var a uint32
+	for pc := 0; pc < len(filter); pc++ {
+		inst := filter[pc]
+		switch inst.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			a = binary.LittleEndian.Uint32(input[inst.K:])
+		case unix.BPF_ALU | unix.BPF_AND | unix.BPF_K:
+			a &= inst.K
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGT | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			var ok bool
+			switch inst.Code &^ (unix.BPF_JMP | unix.BPF_K) {
+			case unix.BPF_JEQ:
+				ok = a == inst.K
+			case unix.BPF_JGT:
+				ok = a > inst.K
+			case unix.BPF_JGE:
+				ok = a >= inst.K
+			}
+			if ok {
+				pc += int(inst.Jt)
+			} else {
+				pc += int(inst.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return inst.K
+		default:
+			t.Fatalf("unexpected instruction %#x at %d", inst.Code, pc)
+		}
+	}
+	t.Fatalf("filter does not return")
+	return 0
+}
+
+func syscallNr(t *testing.T, name string) int32 {
+	t.Helper()
+	nr, ok := syscallNumbers[name]
+	if !ok {
+		t.Skipf("syscall %s is not supported on this architecture", name)
+	}
+	return int32(nr)
+}
+
+func TestDefaultProfile(t *testing.T) {
+	if nativeArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+	eperm := uint32(retErrno | unix.EPERM)
+	enosys := uint32(retErrno | unix.ENOSYS)
+	// glibc 创建线程时使用的 clone 标志
+	threadFlags := uint64(unix.CLONE_VM | unix.CLONE_FS | unix.CLONE_FILES | unix.CLONE_SIGHAND | unix.CLONE_THREAD |
+		unix.CLONE_SYSVSEM | unix.CLONE_SETTLS | unix.CLONE_PARENT_SETTID | unix.CLONE_CHILD_CLEARTID)
+	tests := []struct {
+		name    string
+		caps    []string
+		syscall string
+		arch    uint32
+		args    [6]uint64
+		want    uint32
+	}{
+		{name: "read allowed", syscall: "read", want: retAllow},
+		{name: "mount blocked", syscall: "mount", want: eperm},
+		{name: "kexec_load blocked", syscall: "kexec_load", want: eperm},
+		{name: "bpf blocked", syscall: "bpf", want: eperm},
+		{name: "mount with CAP_SYS_ADMIN", caps: []string{"CAP_SYS_ADMIN"}, syscall: "mount", want: retAllow},
+		{name: "kexec_load with CAP_SYS_BOOT", caps: []string{"CAP_SYS_BOOT"}, syscall: "kexec_load", want: eperm},
+		{name: "reboot with CAP_SYS_BOOT", caps: []string{"CAP_SYS_BOOT"}, syscall: "reboot", want: retAllow},
+		{name: "foreign arch", syscall: "read", arch: 0x40000003, want: eperm},
+		{name: "unlisted io_uring_setup", syscall: "io_uring_setup", want: eperm},
+		{name: "unlisted mount_setattr", syscall: "mount_setattr", want: eperm},
+		{name: "fchmodat2 allowed", syscall: "fchmodat2", want: retAllow},
+		{name: "ptrace allowed", syscall: "ptrace", want: retAllow},
+		{name: "kcmp blocked", syscall: "kcmp", want: eperm},
+		{name: "bpf with CAP_BPF", caps: []string{"CAP_BPF"}, syscall: "bpf", want: retAllow},
+		{name: "personality linux", syscall: "personality", want: retAllow},
+		{name: "personality query", syscall: "personality", args: [6]uint64{0xffffffff}, want: retAllow},
+		{name: "personality read implies exec", syscall: "personality", args: [6]uint64{0x0400000}, want: eperm},
+		{name: "socket inet", syscall: "socket", args: [6]uint64{unix.AF_INET}, want: retAllow},
+		{name: "socket vsock", syscall: "socket", args: [6]uint64{unix.AF_VSOCK}, want: eperm},
+		{name: "clone thread", syscall: "clone", args: [6]uint64{threadFlags}, want: retAllow},
+		{name: "clone fork", syscall: "clone", args: [6]uint64{uint64(unix.SIGCHLD)}, want: retAllow},
+		{name: "clone new user namespace", syscall: "clone", args: [6]uint64{unix.CLONE_NEWUSER | uint64(unix.SIGCHLD)}, want: eperm},
+		{name: "clone new net namespace", syscall: "clone", args: [6]uint64{unix.CLONE_NEWNET}, want: eperm},
+		{name: "clone new namespace with CAP_SYS_ADMIN", caps: []string{"CAP_SYS_ADMIN"}, syscall: "clone",
+			args: [6]uint64{unix.CLONE_NEWNS}, want: retAllow},
+		{name: "clone3 enosys", syscall: "clone3", want: enosys},
+		{name: "clone3 with CAP_SYS_ADMIN", caps: []string{"CAP_SYS_ADMIN"}, syscall: "clone3", want: retAllow},
+	}
+	for _, tt := range tests {
+		filter, err := Compile(DefaultProfile(), tt.caps)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		data := seccompData{nr: syscallNr(t, tt.syscall), arch: nativeArch, args: tt.args}
+		if tt.arch != 0 {
+			data.arch = tt.arch
+		}
+		if got := run(t, filter, data); got != tt.want {
+			t.Errorf("%s: got %#x, want %#x", tt.name, got, tt.want)
+		}
+	}
+
+	// 比系统调用表更新的系统调用返回 ENOSYS，而不是默认的 EPERM
+	filter, err := Compile(DefaultProfile(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := seccompData{nr: int32(maxSyscallNumber() + 1), arch: nativeArch}
+	if got := run(t, filter, data); got != enosys {
+		t.Errorf("newer syscall: got %#x, want %#x", got, enosys)
+	}
+}
+
+func TestCompileArgs(t *testing.T) {
+	if nativeArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+	errnoRet := uint(unix.EACCES)
+	rule := func(op Operator, value, valueTwo uint64) *Profile {
+		return &Profile{
+			DefaultAction: ActAllow,
+			Syscalls: []*Syscall{{
+				Names:    []string{"read"},
+				Action:   ActErrno,
+				ErrnoRet: &errnoRet,
+				Args:     []*Arg{{Index: 1, Value: value, ValueTwo: valueTwo, Op: op}},
+			}},
+		}
+	}
+	eacces := uint32(retErrno | unix.EACCES)
+	big := uint64(1) << 33
+	tests := []struct {
+		name    string
+		profile *Profile
+		arg     uint64
+		want    uint32
+	}{
+		{name: "eq match", profile: rule(OpEqualTo, big+5, 0), arg: big + 5, want: eacces},
+		{name: "eq high differs", profile: rule(OpEqualTo, big+5, 0), arg: 5, want: retAllow},
+		{name: "ne match", profile: rule(OpNotEqual, 5, 0), arg: big + 5, want: eacces},
+		{name: "ne equal", profile: rule(OpNotEqual, 5, 0), arg: 5, want: retAllow},
+		{name: "gt high", profile: rule(OpGreaterThan, 10, 0), arg: big, want: eacces},
+		{name: "gt equal", profile: rule(OpGreaterThan, 10, 0), arg: 10, want: retAllow},
+		{name: "ge equal", profile: rule(OpGreaterEqual, 10, 0), arg: 10, want: eacces},
+		{name: "ge less", profile: rule(OpGreaterEqual, big, 0), arg: 10, want: retAllow},
+		{name: "lt less", profile: rule(OpLessThan, big, 0), arg: 10, want: eacces},
+		{name: "lt equal", profile: rule(OpLessThan, 10, 0), arg: 10, want: retAllow},
+		{name: "le equal", profile: rule(OpLessEqual, big+1, 0), arg: big + 1, want: eacces},
+		{name: "le greater", profile: rule(OpLessEqual, 10, 0), arg: 11, want: retAllow},
+		{name: "masked eq match", profile: rule(OpMaskedEqual, 0xff|big, 0x12|big), arg: 0xab12 | big, want: eacces},
+		{name: "masked eq differs", profile: rule(OpMaskedEqual, 0xff|big, 0x12|big), arg: 0xab12, want: retAllow},
+	}
+	for _, tt := range tests {
+		filter, err := Compile(tt.profile, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		data := seccompData{nr: syscallNr(t, "read"), arch: nativeArch}
+		data.args[1] = tt.arg
+		if got := run(t, filter, data); got != tt.want {
+			t.Errorf("%s: got %#x, want %#x", tt.name, got, tt.want)
+		}
+		// 其他系统调用不受规则影响
+		data.nr = syscallNr(t, "write")
+		if got := run(t, filter, data); got != retAllow {
+			t.Errorf("%s: write got %#x, want allow", tt.name, got)
+		}
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	if nativeArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+	path := filepath.Join(t.TempDir(), "profile.json")
+	content := `{
+		"defaultAction": "SCMP_ACT_ERRNO",
+		"defaultErrnoRet": 38,
+		"architectures": ["SCMP_ARCH_X86_64", "SCMP_ARCH_AARCH64"],
+		"syscalls": [
+			{"names": ["read", "write", "no_such_syscall"], "action": "SCMP_ACT_ALLOW"},
+			{"name": "mount", "action": "SCMP_ACT_ALLOW", "includes": {"caps": ["CAP_SYS_ADMIN"]}},
+			{"names": ["kill"], "action": "SCMP_ACT_KILL_PROCESS", "args": [{"index": 1, "value": 9, "op": "SCMP_CMP_EQ"}]}
+		]
+	}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	profile, err := LoadProfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := Compile(profile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enosys := uint32(retErrno | unix.ENOSYS)
+	check := func(name string, args [6]uint64, want uint32) {
+		t.Helper()
+		if got := run(t, filter, seccompData{nr: syscallNr(t, name), arch: nativeArch, args: args}); got != want {
+			t.Errorf("%s: got %#x, want %#x", name, got, want)
+		}
+	}
+	check("read", [6]uint64{}, retAllow)
+	check("mount", [6]uint64{}, enosys)
+	check("kill", [6]uint64{1, 9}, retKillProcess)
+	check("kill", [6]uint64{1, 15}, enosys)
+
+	if _, err = Compile(&Profile{DefaultAction: "SCMP_ACT_NOTIFY"}, nil); err == nil {
+		t.Errorf("expected error for unsupported action")
+	}
+	if _, err = Compile(&Profile{DefaultAction: ActAllow, Syscalls: []*Syscall{{
+		Names: []string{"read"}, Action: ActErrno, Args: []*Arg{{Index: 6, Op: OpEqualTo}},
+	}}}, nil); err == nil {
+		t.Errorf("expected error for invalid argument index")
+	}
+}
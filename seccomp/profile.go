@@ -0,0 +1,262 @@
+package seccomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Action 系统调用匹配规则后执行的动作，与 docker/OCI 的 seccomp 配置保持一致
+type Action string
+
+const (
+	ActKill        Action = "SCMP_ACT_KILL" // 与 libseccomp 一致，等同于 SCMP_ACT_KILL_THREAD
+	ActKillProcess Action = "SCMP_ACT_KILL_PROCESS"
+	ActKillThread  Action = "SCMP_ACT_KILL_THREAD"
+	ActTrap        Action = "SCMP_ACT_TRAP"
+	ActErrno       Action = "SCMP_ACT_ERRNO"
+	ActTrace       Action = "SCMP_ACT_TRACE"
+	ActAllow       Action = "SCMP_ACT_ALLOW"
+	ActLog         Action = "SCMP_ACT_LOG"
+)
+
+// Operator 系统调用参数的比较方式
+type Operator string
+
+const (
+	OpNotEqual     Operator = "SCMP_CMP_NE"
+	OpLessThan     Operator = "SCMP_CMP_LT"
+	OpLessEqual    Operator = "SCMP_CMP_LE"
+	OpEqualTo      Operator = "SCMP_CMP_EQ"
+	OpGreaterEqual Operator = "SCMP_CMP_GE"
+	OpGreaterThan  Operator = "SCMP_CMP_GT"
+	OpMaskedEqual  Operator = "SCMP_CMP_MASKED_EQ" // (参数 & Value) == ValueTwo
+)
+
+// cloneNamespaceFlags clone 创建新 namespace 的标志，即 docker 默认配置中的 0x7E020000
+const cloneNamespaceFlags = unix.CLONE_NEWNS | unix.CLONE_NEWCGROUP | unix.CLONE_NEWUTS | unix.CLONE_NEWIPC |
+	unix.CLONE_NEWUSER | unix.CLONE_NEWPID | unix.CLONE_NEWNET
+
+// Unconfined --security-opt seccomp=unconfined，不限制系统调用
+const Unconfined = "unconfined"
+
+/*
+ * Profile docker/OCI 格式的 seccomp 配置
+ * architectures、archMap 等字段会被忽略，只为本机架构生成过滤规则，其他架构（e.g. 32 位兼容模式）的系统调用一律返回 EPERM
+ */
+type Profile struct {
+	DefaultAction   Action     `json:"defaultAction"`
+	DefaultErrnoRet *uint      `json:"defaultErrnoRet,omitempty"`
+	Syscalls        []*Syscall `json:"syscalls"`
+}
+
+// Syscall 一条过滤规则，Names 中的系统调用在满足所有 Args 条件时执行 Action
+type Syscall struct {
+	Name     string   `json:"name,omitempty"` // 旧版 docker 配置中每条规则只有一个系统调用
+	Names    []string `json:"names,omitempty"`
+	Action   Action   `json:"action"`
+	ErrnoRet *uint    `json:"errnoRet,omitempty"`
+	Args     []*Arg   `json:"args,omitempty"`
+	Includes *Filter  `json:"includes,omitempty"` // 容器满足所有条件时规则才生效
+	Excludes *Filter  `json:"excludes,omitempty"` // 容器满足任意一个条件时规则不生效
+}
+
+// Arg 系统调用参数的比较条件，Index 为参数的位置，从 0 开始
+type Arg struct {
+	Index    uint     `json:"index"`
+	Value    uint64   `json:"value"`
+	ValueTwo uint64   `json:"valueTwo"`
+	Op       Operator `json:"op"`
+}
+
+// Filter 规则生效的条件，Caps 为容器拥有的 capability，Arches 为 Go 的架构名，e.g. amd64
+type Filter struct {
+	Caps   []string `json:"caps,omitempty"`
+	Arches []string `json:"arches,omitempty"`
+}
+
+// LoadProfile 读取 docker/OCI 格式的 seccomp 配置文件
+func LoadProfile(path string) (*Profile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read seccomp profile %s", path)
+	}
+	profile := &Profile{}
+	if err = json.Unmarshal(content, profile); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal seccomp profile %s", path)
+	}
+	if profile.DefaultAction == "" {
+		return nil, fmt.Errorf("seccomp profile %s has no default action", path)
+	}
+	return profile, nil
+}
+
+/*
+ * DefaultProfile 容器默认使用的 seccomp 配置，与 docker 的默认配置一致，使用白名单，没有列出的系统调用返回 EPERM
+ * 1. 普通程序需要的系统调用一律允许，io_uring、kexec、keyring 等没有 namespace 隔离或者攻击面较大的系统调用不在白名单中
+ * 2. mount、bpf、reboot 等系统调用只有容器拥有对应的 capability 时才允许
+ * 3. 没有 CAP_SYS_ADMIN 时 clone 不允许创建新的 namespace，clone3 的参数在内存中无法检查，返回 ENOSYS 让 libc 回退到 clone
+ * 4. personality 只允许 docker 默认配置中的几种执行域，socket 不允许创建 AF_VSOCK
+ */
+func DefaultProfile() *Profile {
+	eperm := uint(unix.EPERM)
+	enosys := uint(unix.ENOSYS)
+	syscalls := []*Syscall{
+		{
+			Names: []string{
+				"accept", "accept4", "access", "adjtimex", "alarm", "bind", "brk", "cachestat", "capget", "capset",
+				"chdir", "chmod", "chown", "chown32", "clock_adjtime", "clock_adjtime64", "clock_getres",
+				"clock_getres_time64", "clock_gettime", "clock_gettime64", "clock_nanosleep",
+				"clock_nanosleep_time64", "close", "close_range", "connect", "copy_file_range", "creat", "dup",
+				"dup2", "dup3", "epoll_create", "epoll_create1", "epoll_ctl", "epoll_ctl_old", "epoll_pwait",
+				"epoll_pwait2", "epoll_wait", "epoll_wait_old", "eventfd", "eventfd2", "execve", "execveat",
+				"exit", "exit_group", "faccessat", "faccessat2", "fadvise64", "fadvise64_64", "fallocate",
+				"fanotify_mark", "fchdir", "fchmod", "fchmodat", "fchmodat2", "fchown", "fchown32", "fchownat",
+				"fcntl", "fcntl64", "fdatasync", "fgetxattr", "flistxattr", "flock", "fork", "fremovexattr",
+				"fsetxattr", "fstat", "fstat64", "fstatat64", "fstatfs", "fstatfs64", "fsync", "ftruncate",
+				"ftruncate64", "futex", "futex_requeue", "futex_time64", "futex_wait", "futex_waitv", "futex_wake",
+				"futimesat", "getcpu", "getcwd", "getdents", "getdents64", "getegid", "getegid32", "geteuid",
+				"geteuid32", "getgid", "getgid32", "getgroups", "getgroups32", "getitimer", "getpeername",
+				"getpgid", "getpgrp", "getpid", "getppid", "getpriority", "getrandom", "getresgid", "getresgid32",
+				"getresuid", "getresuid32", "getrlimit", "get_robust_list", "getrusage", "getsid", "getsockname",
+				"getsockopt", "get_thread_area", "gettid", "gettimeofday", "getuid", "getuid32", "getxattr",
+				"inotify_add_watch", "inotify_init", "inotify_init1", "inotify_rm_watch", "io_cancel", "ioctl",
+				"io_destroy", "io_getevents", "io_pgetevents", "io_pgetevents_time64", "ioprio_get", "ioprio_set",
+				"io_setup", "io_submit", "ipc", "kill", "landlock_add_rule", "landlock_create_ruleset",
+				"landlock_restrict_self", "lchown", "lchown32", "lgetxattr", "link", "linkat", "listen",
+				"listxattr", "llistxattr", "_llseek", "lremovexattr", "lseek", "lsetxattr", "lstat", "lstat64",
+				"madvise", "map_shadow_stack", "membarrier", "memfd_create", "memfd_secret", "mincore", "mkdir",
+				"mkdirat", "mknod", "mknodat", "mlock", "mlock2", "mlockall", "mmap", "mmap2", "mprotect",
+				"mq_getsetattr", "mq_notify", "mq_open", "mq_timedreceive", "mq_timedreceive_time64",
+				"mq_timedsend", "mq_timedsend_time64", "mq_unlink", "mremap", "msgctl", "msgget", "msgrcv",
+				"msgsnd", "msync", "munlock", "munlockall", "munmap", "nanosleep", "newfstatat", "_newselect",
+				"open", "openat", "openat2", "pause", "pidfd_open", "pidfd_send_signal", "pipe", "pipe2",
+				"pkey_alloc", "pkey_free", "pkey_mprotect", "poll", "ppoll", "ppoll_time64", "prctl", "pread64",
+				"preadv", "preadv2", "prlimit64", "process_mrelease", "pselect6", "pselect6_time64", "pwrite64",
+				"pwritev", "pwritev2", "read", "readahead", "readlink", "readlinkat", "readv", "recv", "recvfrom",
+				"recvmmsg", "recvmmsg_time64", "recvmsg", "remap_file_pages", "removexattr", "rename", "renameat",
+				"renameat2", "restart_syscall", "rmdir", "rseq", "rt_sigaction", "rt_sigpending", "rt_sigprocmask",
+				"rt_sigqueueinfo", "rt_sigreturn", "rt_sigsuspend", "rt_sigtimedwait", "rt_sigtimedwait_time64",
+				"rt_tgsigqueueinfo", "sched_getaffinity", "sched_getattr", "sched_getparam",
+				"sched_get_priority_max", "sched_get_priority_min", "sched_getscheduler", "sched_rr_get_interval",
+				"sched_rr_get_interval_time64", "sched_setaffinity", "sched_setattr", "sched_setparam",
+				"sched_setscheduler", "sched_yield", "seccomp", "select", "semctl", "semget", "semop",
+				"semtimedop", "semtimedop_time64", "send", "sendfile", "sendfile64", "sendmmsg", "sendmsg",
+				"sendto", "setfsgid", "setfsgid32", "setfsuid", "setfsuid32", "setgid", "setgid32", "setgroups",
+				"setgroups32", "setitimer", "setpgid", "setpriority", "setregid", "setregid32", "setresgid",
+				"setresgid32", "setresuid", "setresuid32", "setreuid", "setreuid32", "setrlimit",
+				"set_robust_list", "setsid", "setsockopt", "set_thread_area", "set_tid_address", "setuid",
+				"setuid32", "setxattr", "shmat", "shmctl", "shmdt", "shmget", "shutdown", "sigaltstack",
+				"signalfd", "signalfd4", "sigprocmask", "sigreturn", "socketcall", "socketpair", "splice", "stat",
+				"stat64", "statfs", "statfs64", "statx", "symlink", "symlinkat", "sync", "sync_file_range",
+				"syncfs", "sysinfo", "tee", "tgkill", "time", "timer_create", "timer_delete", "timer_getoverrun",
+				"timer_gettime", "timer_gettime64", "timer_settime", "timer_settime64", "timerfd_create",
+				"timerfd_gettime", "timerfd_gettime64", "timerfd_settime", "timerfd_settime64", "times", "tkill",
+				"truncate", "truncate64", "ugetrlimit", "umask", "uname", "unlink", "unlinkat", "utime",
+				"utimensat", "utimensat_time64", "utimes", "vfork", "vmsplice", "wait4", "waitid", "waitpid",
+				"write", "writev", "process_vm_readv", "process_vm_writev", "ptrace",
+			},
+			Action: ActAllow,
+		},
+		{Names: []string{"socket"}, Action: ActAllow, Args: []*Arg{{Index: 0, Value: unix.AF_VSOCK, Op: OpNotEqual}}},
+		{Names: []string{"arch_prctl", "modify_ldt"}, Action: ActAllow, Includes: &Filter{Arches: []string{"amd64"}}},
+		// flags 中不包含 CLONE_NEW* 的 clone 允许，其余的 clone 返回默认的 EPERM
+		{
+			Names:    []string{"clone"},
+			Action:   ActAllow,
+			Args:     []*Arg{{Index: 0, Value: cloneNamespaceFlags, ValueTwo: 0, Op: OpMaskedEqual}},
+			Excludes: &Filter{Caps: []string{"CAP_SYS_ADMIN"}},
+		},
+		{Names: []string{"clone3"}, Action: ActErrno, ErrnoRet: &enosys, Excludes: &Filter{Caps: []string{"CAP_SYS_ADMIN"}}},
+		{
+			Names: []string{
+				"bpf", "clone", "clone3", "fanotify_init", "fsconfig", "fsmount", "fsopen", "fspick",
+				"lookup_dcookie", "mount", "mount_setattr", "move_mount", "name_to_handle_at", "open_tree",
+				"perf_event_open", "quotactl", "quotactl_fd", "setdomainname", "sethostname", "setns",
+				"syslog", "umount", "umount2", "unshare",
+			},
+			Action:   ActAllow,
+			Includes: &Filter{Caps: []string{"CAP_SYS_ADMIN"}},
+		},
+		{Names: []string{"reboot"}, Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYS_BOOT"}}},
+		{Names: []string{"chroot"}, Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYS_CHROOT"}}},
+		{
+			Names:    []string{"delete_module", "init_module", "finit_module"},
+			Action:   ActAllow,
+			Includes: &Filter{Caps: []string{"CAP_SYS_MODULE"}},
+		},
+		{Names: []string{"acct"}, Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYS_PACCT"}}},
+		{
+			Names:    []string{"kcmp", "pidfd_getfd", "process_madvise"},
+			Action:   ActAllow,
+			Includes: &Filter{Caps: []string{"CAP_SYS_PTRACE"}},
+		},
+		{Names: []string{"iopl", "ioperm"}, Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYS_RAWIO"}}},
+		{
+			Names:    []string{"settimeofday", "stime", "clock_settime", "clock_settime64"},
+			Action:   ActAllow,
+			Includes: &Filter{Caps: []string{"CAP_SYS_TIME"}},
+		},
+		{Names: []string{"vhangup"}, Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYS_TTY_CONFIG"}}},
+		{
+			Names:    []string{"get_mempolicy", "mbind", "set_mempolicy", "set_mempolicy_home_node"},
+			Action:   ActAllow,
+			Includes: &Filter{Caps: []string{"CAP_SYS_NICE"}},
+		},
+		{Names: []string{"syslog"}, Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYSLOG"}}},
+		{Names: []string{"bpf"}, Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_BPF"}}},
+		{Names: []string{"perf_event_open"}, Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_PERFMON"}}},
+		{
+			Names:    []string{"open_by_handle_at"},
+			Action:   ActAllow,
+			Includes: &Filter{Caps: []string{"CAP_DAC_READ_SEARCH"}},
+		},
+	}
+	for _, persona := range []uint64{0x0, 0x0008, 0x20000, 0x20008, 0xffffffff} {
+		syscalls = append(syscalls, &Syscall{
+			Names:  []string{"personality"},
+			Action: ActAllow,
+			Args:   []*Arg{{Index: 0, Value: persona, Op: OpEqualTo}},
+		})
+	}
+	return &Profile{DefaultAction: ActErrno, DefaultErrnoRet: &eperm, Syscalls: syscalls}
+}
+
+// applies 判断规则对拥有 caps 的容器是否生效
+func (s *Syscall) applies(caps map[string]bool) bool {
+	if s.Includes != nil {
+		for _, c := range s.Includes.Caps {
+			if !caps[c] {
+				return false
+			}
+		}
+		if len(s.Includes.Arches) > 0 && !containsArch(s.Includes.Arches) {
+			return false
+		}
+	}
+	if s.Excludes != nil {
+		for _, c := range s.Excludes.Caps {
+			if caps[c] {
+				return false
+			}
+		}
+		if containsArch(s.Excludes.Arches) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsArch 判断架构列表中是否包含本机架构
+func containsArch(arches []string) bool {
+	for _, arch := range arches {
+		if arch == runtime.GOARCH {
+			return true
+		}
+	}
+	return false
+}
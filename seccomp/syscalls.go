@@ -0,0 +1,33 @@
+package seccomp
+
+// newerSyscalls golang.org/x/sys 的系统调用表中还没有的系统调用，从 424 开始所有架构使用统一的系统调用号
+var newerSyscalls = map[string]int{
+	"cachestat":        451,
+	"fchmodat2":        452,
+	"map_shadow_stack": 453,
+	"futex_wake":       454,
+	"futex_wait":       455,
+	"futex_requeue":    456,
+}
+
+func init() {
+	if nativeArch == 0 {
+		return
+	}
+	for name, nr := range newerSyscalls {
+		if _, ok := syscallNumbers[name]; !ok {
+			syscallNumbers[name] = nr
+		}
+	}
+}
+
+// maxSyscallNumber 系统调用表中最大的系统调用号，更大的系统调用号是比系统调用表更新的系统调用
+func maxSyscallNumber() int {
+	max := 0
+	for _, nr := range syscallNumbers {
+		if nr > max {
+			max = nr
+		}
+	}
+	return max
+}
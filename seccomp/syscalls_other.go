@@ -0,0 +1,9 @@
+//go:build !amd64 && !arm64
+
+package seccomp
+
+// nativeArch 不支持的架构，Compile 会返回错误
+const nativeArch = 0
+
+// syscallNumbers 不支持的架构没有系统调用表
+var syscallNumbers = map[string]int{}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"mydocker/container"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * StartContainer 根据保存的容器信息重新启动已经停止的容器
+ * 1. 重新挂载容器原来的工作空间和 volume，upper 层中的数据不会丢失
+ * 2. 重新连接容器原来的网络，原来的 IP 没有被占用时继续使用，并重新配置端口映射
+ * 3. attach 为 true 时在前台运行并等待容器退出，否则与 run -d 一样由 monitor 进程负责
+ */
+func StartContainer(containerId string, attach bool) error {
+	containerInfo, err := container.ModifyContainerInfo(containerId, func(info *container.Info) error {
+		switch info.Status {
+		case container.STOP:
+			// 没有 monitor 进程的容器 stop 时不会释放网络资源，这里先释放，重新连接时再分配
+			releaseNetwork(info)
+		case container.Exit, container.CREATED:
+		default:
+			return fmt.Errorf("container %s can not be started, status: %s", containerId, info.Status)
+		}
+		if info.InitConfig == nil {
+			return fmt.Errorf("container %s has no init config, it may be created by an older version", containerId)
+		}
+		// 手动启动的容器重新开始计算自动重启的次数
+		info.RestartCount = 0
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !attach {
+		return startMonitor(containerId)
+	}
+	parent, oomCh, err := startContainer(containerInfo, true)
+	if err != nil {
+		return err
+	}
+	superviseContainer(containerInfo, parent, oomCh, true)
+	return nil
+}
+
+// RestartContainer 停止正在运行的容器，然后重新启动，timeout 为等待容器退出的时间
+func RestartContainer(containerId string, timeout time.Duration) error {
+	containerInfo, err := container.GetInfoByContainerId(containerId)
+	if err != nil {
+		return errors.WithMessagef(err, "get container %s info", containerId)
+	}
+	if containerInfo.Status == container.RUNNING || containerInfo.Status == container.PAUSED {
+		StopContainer(containerId, timeout)
+	}
+	return StartContainer(containerId, false)
+}
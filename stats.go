@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"mydocker/cgroups"
+	"mydocker/cgroups/subsystems"
+	"mydocker/container"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	statsInterval = time.Second
+	// /proc/stat 中的 CPU 时间单位为 jiffies，USER_HZ 在绝大多数系统上都是 100
+	clockTicksPerSecond = 100
+)
+
+// ContainerStats 容器的资源使用情况，--no-stream 时会以 json 格式输出
+type ContainerStats struct {
+	Id            string  `json:"id"`
+	Name          string  `json:"name"`
+	CpuPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   uint64  `json:"memoryUsage"`
+	MemoryLimit   uint64  `json:"memoryLimit"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	Pids          uint64  `json:"pids"`
+	BlockRead     uint64  `json:"blockRead"`
+	BlockWrite    uint64  `json:"blockWrite"`
+}
+
+// statsSample 某一时刻的采样，CPU 使用率需要根据前后两次采样的差值来计算
+type statsSample struct {
+	stats     *subsystems.Stats
+	systemCpu uint64
+}
+
+/*
+ * StatsContainers 展示容器的资源使用情况
+ * 1. 不指定容器时展示所有运行中的容器
+ * 2. 默认每秒刷新一次；noStream 为 true 时只采样一次并以 json 格式输出，便于脚本解析
+ */
+func StatsContainers(containerIds []string, noStream bool) error {
+	containers, err := getStatsContainers(containerIds)
+	if err != nil {
+		return err
+	}
+
+	prev := sampleContainers(containers)
+	for {
+		time.Sleep(statsInterval)
+		current := sampleContainers(containers)
+		results := make([]*ContainerStats, 0, len(containers))
+		for _, info := range containers {
+			if current[info.Id] == nil || prev[info.Id] == nil {
+				continue
+			}
+			results = append(results, calculateStats(info, prev[info.Id], current[info.Id]))
+		}
+		if noStream {
+			output, err := json.MarshalIndent(results, "", "  ")
+			if err != nil {
+				return errors.Wrap(err, "marshal stats")
+			}
+			fmt.Println(string(output))
+			return nil
+		}
+		printStats(results)
+		prev = current
+	}
+}
+
+// getStatsContainers 获取需要展示的容器，不指定时返回所有运行中（包括被挂起）的容器
+func getStatsContainers(containerIds []string) ([]*container.Info, error) {
+	var containers []*container.Info
+	if len(containerIds) == 0 {
+		files, err := os.ReadDir(container.InfoLoc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read dir %s", container.InfoLoc)
+		}
+		for _, file := range files {
+			if !file.IsDir() {
+				continue
+			}
+			info, err := getContainerInfo(file)
+			if err != nil {
+				continue
+			}
+			if info.Status == container.RUNNING || info.Status == container.PAUSED {
+				containers = append(containers, info)
+			}
+		}
+		return containers, nil
+	}
+	for _, containerId := range containerIds {
+		info, err := container.GetInfoByContainerId(containerId)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "get container %s info", containerId)
+		}
+		if info.CgroupPath == "" {
+			return nil, fmt.Errorf("container %s has no cgroup", containerId)
+		}
+		containers = append(containers, info)
+	}
+	return containers, nil
+}
+
+// sampleContainers 对每个容器的 cgroup 进行一次采样，读取失败的容器（比如已经退出）会被跳过
+func sampleContainers(containers []*container.Info) map[string]*statsSample {
+	samples := make(map[string]*statsSample, len(containers))
+	systemCpu, err := getSystemCpuUsage()
+	if err != nil {
+		log.Errorf("get system cpu usage error %v", err)
+		return samples
+	}
+	for _, info := range containers {
+		if info.CgroupPath == "" {
+			continue
+		}
+		stats, err := cgroups.NewCgroupManager(info.CgroupPath).GetStats()
+		if err != nil {
+			log.Debugf("get container %s stats error %v", info.Id, err)
+			continue
+		}
+		samples[info.Id] = &statsSample{stats: stats, systemCpu: systemCpu}
+	}
+	return samples
+}
+
+// calculateStats 根据前后两次采样计算容器的资源使用情况
+// CPU 使用率的计算方式与 docker 一致：容器 CPU 时间增量 / 系统 CPU 时间增量 * CPU 核数
+func calculateStats(info *container.Info, prev, current *statsSample) *ContainerStats {
+	result := &ContainerStats{
+		Id:          info.Id,
+		Name:        info.Name,
+		MemoryUsage: current.stats.MemoryUsage,
+		MemoryLimit: current.stats.MemoryLimit,
+		Pids:        current.stats.PidsCurrent,
+		BlockRead:   current.stats.BlkioRead,
+		BlockWrite:  current.stats.BlkioWrite,
+	}
+	cpuDelta := float64(current.stats.CpuUsage) - float64(prev.stats.CpuUsage)
+	systemDelta := float64(current.systemCpu) - float64(prev.systemCpu)
+	if cpuDelta > 0 && systemDelta > 0 {
+		result.CpuPercent = cpuDelta / systemDelta * float64(runtime.NumCPU()) * 100
+	}
+	// 没有内存限制时 limit 是一个非常大的值，这种情况下使用宿主机的内存总量作为 limit
+	if hostMemory := getHostMemory(); hostMemory > 0 && (result.MemoryLimit == 0 || result.MemoryLimit > hostMemory) {
+		result.MemoryLimit = hostMemory
+	}
+	if result.MemoryLimit > 0 {
+		result.MemoryPercent = float64(result.MemoryUsage) / float64(result.MemoryLimit) * 100
+	}
+	return result
+}
+
+func printStats(results []*ContainerStats) {
+	// 清屏并将光标移动到左上角，实现刷新的效果
+	fmt.Print("\033[2J\033[H")
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	_, err := fmt.Fprint(w, "ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tPIDS\tBLOCK I/O\n")
+	if err != nil {
+		log.Errorf("Fprint error %v", err)
+	}
+	for _, item := range results {
+		_, err = fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%d\t%s / %s\n",
+			container.ShortID(item.Id), item.Name, item.CpuPercent,
+			formatBytes(item.MemoryUsage), formatBytes(item.MemoryLimit), item.MemoryPercent,
+			item.Pids, formatBytes(item.BlockRead), formatBytes(item.BlockWrite))
+		if err != nil {
+			log.Errorf("Fprintf error %v", err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+	}
+}
+
+// getSystemCpuUsage 读取 /proc/stat 中第一行 cpu 的各项时间之和，转换为纳秒
+// 第一行大概是这样的：cpu  3357 0 4313 1362393 0 0 0 0 0 0
+func getSystemCpuUsage() (uint64, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "cpu" {
+			continue
+		}
+		var totalTicks uint64
+		for _, field := range fields[1:] {
+			ticks, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, errors.Wrapf(err, "parse /proc/stat field %s", field)
+			}
+			totalTicks += ticks
+		}
+		return totalTicks * uint64(time.Second) / clockTicksPerSecond, nil
+	}
+	return 0, errors.New("invalid /proc/stat format")
+}
+
+// getHostMemory 读取 /proc/meminfo 中的 MemTotal，单位为字节
+func getHostMemory() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// MemTotal:       16303864 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// formatBytes 将字节数转换成便于阅读的格式，e.g. 1.5MiB
+func formatBytes(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", value, units[i])
+}
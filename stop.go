@@ -1,47 +1,120 @@
 package main
 
 import (
-	"encoding/json"
-	"os"
 	"strconv"
 	"syscall"
+	"time"
 
-	"mydocker/constant"
+	"mydocker/cgroups"
 	"mydocker/container"
 	"mydocker/network"
 
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
-func StopContainer(containerId string) {
-	// 1. 根据容器Id查询容器信息
-	containerInfo, err := container.GetInfoByContainerId(containerId)
+const (
+	// defaultStopTimeout stop 命令默认等待容器退出的秒数，超时后使用 SIGKILL 强制杀死容器
+	defaultStopTimeout = 10
+	// stopPollInterval 等待容器退出时检查容器状态的间隔
+	stopPollInterval = 100 * time.Millisecond
+)
+
+/*
+ * StopContainer 停止容器
+ * 1. 向容器 init 进程发送容器的停止信号（默认为 SIGTERM）
+ * 2. 等待容器退出，超过 timeout 后使用 SIGKILL 强制杀死
+ * 3. 确认容器进程已经退出后才更新容器状态
+ */
+func StopContainer(containerId string, timeout time.Duration) {
+	// 1. 持有容器的锁读取容器信息，标记为手动停止，避免容器退出后被自动重启
+	// 2. 等待自动重启的容器没有运行中的进程，标记为手动停止后 monitor 进程不会再重启它，直接置为 exited
+	restarting := false
+	containerInfo, err := container.ModifyContainerInfo(containerId, func(info *container.Info) error {
+		switch info.Status {
+		case container.RESTARTING:
+			restarting = true
+			info.Status = container.Exit
+			info.ManualStopped = true
+		case container.RUNNING, container.PAUSED:
+			info.ManualStopped = true
+		}
+		return nil
+	})
 	if err != nil {
 		log.Errorf("Get container %s info error %v", containerId, err)
 		return
 	}
+	if restarting {
+		return
+	}
 	pidInt, err := strconv.Atoi(containerInfo.Pid)
 	if err != nil {
 		log.Errorf("Conver pid from string to int error %v", err)
 		return
 	}
-	// 2. 发送 SIGTERM 信号
-	if err = syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
+	// 3. 被挂起的容器中的进程无法处理信号，需要先解冻
+	if containerInfo.Status == container.PAUSED {
+		if err = freezeContainer(containerInfo, false); err != nil {
+			log.Errorf("Unpause container %s error %v", containerId, err)
+			return
+		}
+	}
+	// 4. 发送停止信号
+	if err = syscall.Kill(pidInt, containerInfo.GetStopSignal()); err != nil {
 		log.Errorf("Stop container %s error %v", containerId, err)
 		return
 	}
-	// 3. 修改容器信息，将容器置为 STOP 状态，并清空 PID
-	containerInfo.Status = container.STOP
-	containerInfo.Pid = ""
-	newContentBytes, err := json.Marshal(containerInfo)
-	if err != nil {
-		log.Errorf("Json marshal %s error %v", containerId, err)
+	// 5. 等待容器退出，超时后强制杀死
+	hasMonitor := containerInfo.MonitorPid != 0 && isProcessAlive(containerInfo.MonitorPid)
+	if !waitContainerExit(containerInfo, pidInt, hasMonitor, timeout) {
+		log.Warnf("Container %s did not exit within %v, kill it", containerId, timeout)
+		if err = syscall.Kill(pidInt, syscall.SIGKILL); err != nil {
+			log.Errorf("Kill container %s error %v", containerId, err)
+			return
+		}
+		// SIGKILL 无法被忽略，这里只需要等待 monitor 进程回收容器进程并记录状态
+		if !waitContainerExit(containerInfo, pidInt, hasMonitor, defaultStopTimeout*time.Second) {
+			log.Errorf("Container %s did not exit after SIGKILL", containerId)
+			return
+		}
+	}
+	// 6. 容器进程由 monitor 进程回收并记录退出状态
+	if hasMonitor {
 		return
 	}
-	// 4. 重新协会存储容器信息的文件
-	configFilePath := container.GetConfigFilePath(containerId)
-	if err := os.WriteFile(configFilePath, newContentBytes, constant.Perm0622); err != nil {
-		log.Errorf("Write file %s error:%v", configFilePath, err)
+	// 7. 没有 monitor 进程时修改容器信息，将容器置为 STOP 状态，并清空 PID
+	_, err = container.ModifyContainerInfo(containerId, func(info *container.Info) error {
+		info.Status = container.STOP
+		info.Pid = ""
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Update container %s info error %v", containerId, err)
+	}
+}
+
+/*
+ * waitContainerExit 在 timeout 内等待容器退出，返回容器是否已经退出
+ * 有 monitor 进程时等待 monitor 进程记录容器的退出状态，容器信息被删除（指定了 --rm 的容器退出后会被删除）同样视为已经退出，
+ * 否则等待容器进程退出
+ */
+func waitContainerExit(containerInfo *container.Info, pid int, hasMonitor bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if !hasMonitor && !isProcessAlive(pid) {
+			return true
+		}
+		if hasMonitor {
+			latestInfo, err := container.GetInfoByContainerId(containerInfo.Id)
+			if err != nil || (latestInfo.Status != container.RUNNING && latestInfo.Status != container.PAUSED) {
+				return true
+			}
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(stopPollInterval)
 	}
 }
 
@@ -52,30 +125,43 @@ func RemoveContainer(containerId string, force bool) {
 		return
 	}
 	switch containerInfo.Status {
-	case container.STOP:
-		// 如果容器已经停止，直接删除容器信息
+	case container.STOP, container.Exit, container.CREATED:
+		// 如果容器已经停止（或者从未成功启动），直接删除容器信息
+		// 等待正在执行 wait 命令的进程读取容器的退出码后再删除
+		unlock, err := lockContainerWait(containerId, unix.LOCK_EX)
+		if err != nil {
+			log.Errorf("Lock container %s error %v", containerId, err)
+			return
+		}
+		defer unlock()
 		// 先删除配置目录，再删除 rootfs 目录
 		if err = container.DeleteContainerInfo(containerId); err != nil {
 			log.Errorf("Remove container [%s]'s config failed, detail: %v", containerId, err)
 			return
 		}
 		container.DeleteWorkSpace(containerId, containerInfo.Volume)
-		if containerInfo.NetworkName != "" { // 清理网络资源
+		if containerInfo.CgroupPath != "" { // 清理 cgroup
+			_ = cgroups.NewCgroupManager(containerInfo.CgroupPath).Destroy()
+		}
+		if containerInfo.Status == container.STOP && containerInfo.NetworkName != "" { // 清理网络资源，exited 状态的容器已经释放过
 			if err = network.Disconnect(containerInfo.NetworkName, containerInfo); err != nil {
 				log.Errorf("Remove container [%s]'s config failed, detail: %v", containerId, err)
 				return
 			}
 		}
-	case container.RUNNING:
-		// 如果容器正在运行，且强制删除为 true，则停止容器后删除容器信息
+	case container.RUNNING, container.PAUSED, container.RESTARTING:
+		// 如果容器正在运行（或被挂起、等待重启），且强制删除为 true，则停止容器后删除容器信息
 		if !force {
 			log.Errorf("Couldn't remove running container [%s], Stop the container before attempting removal or"+
 				" force remove", containerId)
 			return
 		}
 		log.Infof("force delete running container [%s]", containerId)
-		StopContainer(containerId)
-		RemoveContainer(containerId, force)
+		StopContainer(containerId, defaultStopTimeout*time.Second)
+		// 指定了 --rm 的容器退出后会被自动删除，不需要再删除
+		if _, err = container.GetInfoByContainerId(containerId); err == nil {
+			RemoveContainer(containerId, force)
+		}
 	default:
 		log.Errorf("Couldn't remove container,invalid status %s", containerInfo.Status)
 		return
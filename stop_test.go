@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"mydocker/container"
+)
+
+func TestRemoveCreatedContainer(t *testing.T) {
+	infoLoc, infoLocFormat := container.InfoLoc, container.InfoLocFormat
+	container.InfoLoc = t.TempDir() + "/"
+	container.InfoLocFormat = container.InfoLoc + "%s/"
+	t.Cleanup(func() {
+		container.InfoLoc, container.InfoLocFormat = infoLoc, infoLocFormat
+	})
+
+	containerId, err := container.GenerateContainerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 启动失败的容器保持 created 状态，没有 cgroup 和网络
+	if err = container.RecordContainerInfo(&container.Info{Id: containerId}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := container.GetInfoByContainerId(containerId)
+	if err != nil || info.Status != container.CREATED {
+		t.Fatalf("got %+v, %v, want created container", info, err)
+	}
+
+	RemoveContainer(containerId, false)
+	if _, err = container.GetInfoByContainerId(containerId); err == nil {
+		t.Errorf("created container %s was not removed", containerId)
+	}
+	if _, err = os.Stat(container.GetConfigDirPath(containerId)); !os.IsNotExist(err) {
+		t.Errorf("config dir of container %s still exists: %v", containerId, err)
+	}
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"mydocker/constant"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Migration 将某个版本的数据升级为下一个版本的数据
+type Migration func(data json.RawMessage) (json.RawMessage, error)
+
+// document 状态文件的格式，data 为对象本身序列化后的 json
+// 没有 schemaVersion 字段的文件是引入版本号之前写入的，版本为 0，整个文件就是对象本身
+type document struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Data          json.RawMessage `json:"data"`
+}
+
+/*
+ * Store 保存容器、网络等对象状态的文件存储
+ * 1. 每个对象保存在一个文件中，通过对 .{文件名}.lock 加 flock 实现对象粒度的互斥
+ * 2. 写入时先写临时文件再 rename，进程在写入过程中崩溃也不会留下写了一半的文件
+ * 3. 文件中记录了格式版本，读取旧版本的文件时依次执行迁移函数升级到当前版本
+ */
+type Store struct {
+	version    int
+	migrations map[int]Migration // key 为升级前的版本，没有迁移函数的相邻版本之间格式兼容
+}
+
+// New 创建一个当前格式版本为 version 的 Store
+func New(version int, migrations map[int]Migration) *Store {
+	if migrations == nil {
+		migrations = map[int]Migration{}
+	}
+	return &Store{version: version, migrations: migrations}
+}
+
+// Lock 对 filePath 对应的对象加排他锁，返回解锁的函数
+// 文件所在的目录需要已经存在，避免对象目录被删除后又因为加锁被重新创建出来
+func (s *Store) Lock(filePath string) (func(), error) {
+	dir, name := path.Split(filePath)
+	lockPath := path.Join(dir, "."+name+".lock")
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDONLY, constant.Perm0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", lockPath)
+	}
+	if err = unix.Flock(int(file.Fd()), unix.LOCK_EX); err != nil {
+		_ = file.Close()
+		return nil, errors.Wrapf(err, "lock %s", lockPath)
+	}
+	return func() {
+		_ = unix.Flock(int(file.Fd()), unix.LOCK_UN)
+		_ = file.Close()
+	}, nil
+}
+
+// Load 读取 filePath 中保存的对象，文件不存在时返回的错误满足 os.IsNotExist
+func (s *Store) Load(filePath string, v interface{}) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	var doc document
+	if err = json.Unmarshal(content, &doc); err != nil {
+		return errors.Wrapf(err, "unmarshal %s", filePath)
+	}
+	if doc.SchemaVersion == 0 {
+		doc.Data = content
+	}
+	if doc.SchemaVersion > s.version {
+		return fmt.Errorf("%s has schema version %d, newer than supported version %d", filePath, doc.SchemaVersion, s.version)
+	}
+	for version := doc.SchemaVersion; version < s.version; version++ {
+		migrate, ok := s.migrations[version]
+		if !ok {
+			continue
+		}
+		if doc.Data, err = migrate(doc.Data); err != nil {
+			return errors.WithMessagef(err, "migrate %s from schema version %d", filePath, version)
+		}
+	}
+	return errors.Wrapf(json.Unmarshal(doc.Data, v), "unmarshal %s", filePath)
+}
+
+// Save 将对象写入 filePath，先写入同目录下的临时文件，再通过 rename 原子地替换原文件
+func (s *Store) Save(filePath string, v interface{}, perm os.FileMode) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+	content, err := json.Marshal(&document{SchemaVersion: s.version, Data: data})
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+	dir, name := path.Split(filePath)
+	tmpFile, err := os.CreateTemp(dir, "."+name+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "create temp file in %s", dir)
+	}
+	// rename 成功之后临时文件已经不存在，这里的删除只在失败时生效
+	defer os.Remove(tmpFile.Name())
+	if _, err = tmpFile.Write(content); err == nil {
+		// 先落盘再 rename，避免系统崩溃后 rename 生效了但数据还没有写入
+		err = tmpFile.Sync()
+	}
+	if err == nil {
+		err = tmpFile.Chmod(perm)
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "write %s", tmpFile.Name())
+	}
+	return errors.Wrapf(os.Rename(tmpFile.Name(), filePath), "rename %s to %s", tmpFile.Name(), filePath)
+}
+
+// Update 在持有对象锁的情况下读取对象，调用 fn 修改之后写回，fn 返回错误时不会写回
+// 文件不存在时 v 保持原样传给 fn，由 fn 负责初始化
+func (s *Store) Update(filePath string, v interface{}, perm os.FileMode, fn func() error) error {
+	unlock, err := s.Lock(filePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err = s.Load(filePath, v); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = fn(); err != nil {
+		return err
+	}
+	return s.Save(filePath, v, perm)
+}
+
+// IsInternalFile 判断目录中的文件是否为 Store 使用的锁文件或者临时文件，遍历对象目录时需要跳过
+func IsInternalFile(name string) bool {
+	return len(name) > 0 && name[0] == '.'
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testObject struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "object.json")
+	s := New(1, nil)
+	if err := s.Save(filePath, &testObject{Name: "a", Count: 1}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(filePath)
+	if !strings.Contains(string(content), `"schemaVersion":1`) {
+		t.Fatalf("schema version not recorded: %s", content)
+	}
+	var got testObject
+	if err := s.Load(filePath, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (testObject{Name: "a", Count: 1}) {
+		t.Fatalf("got %+v", got)
+	}
+	// 临时文件在 rename 之后不应该残留
+	entries, _ := os.ReadDir(path.Dir(filePath))
+	for _, entry := range entries {
+		if entry.Name() != "object.json" {
+			t.Fatalf("unexpected file %s", entry.Name())
+		}
+	}
+}
+
+func TestLoadMigration(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "object.json")
+	// 引入版本号之前的文件就是对象本身
+	if err := os.WriteFile(filePath, []byte(`{"name":"legacy","count":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := New(2, map[int]Migration{
+		1: func(data json.RawMessage) (json.RawMessage, error) {
+			var obj testObject
+			if err := json.Unmarshal(data, &obj); err != nil {
+				return nil, err
+			}
+			obj.Count *= 10
+			return json.Marshal(&obj)
+		},
+	})
+	var got testObject
+	if err := s.Load(filePath, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (testObject{Name: "legacy", Count: 10}) {
+		t.Fatalf("got %+v", got)
+	}
+
+	// 更新版本写入的文件不能被旧版本读取
+	if err := s.Save(filePath, &got, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(1, nil).Load(filePath, &got); err == nil {
+		t.Fatal("expected error loading newer schema version")
+	}
+}
+
+func TestConcurrentUpdate(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "counter.json")
+	s := New(1, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var obj testObject
+			err := s.Update(filePath, &obj, 0644, func() error {
+				obj.Count++
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	var got testObject
+	if err := s.Load(filePath, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Count != 20 {
+		t.Fatalf("got count %d, want 20", got.Count)
+	}
+}
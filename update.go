@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	"mydocker/cgroups"
+	"mydocker/cgroups/subsystems"
+	"mydocker/container"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+ * UpdateContainer 修改运行中容器的资源限制
+ * 持有容器的锁完成以下步骤，避免与其他命令对容器信息的修改互相覆盖
+ * 1. 读取最新的容器信息，只有运行中的容器才能修改
+ * 2. 将新的限制与原有的限制合并，并校验合并后的配置
+ * 3. 通过容器 cgroup 的 Subsystem.Set 写入新的限制
+ * 4. 将新的限制写回容器配置文件
+ */
+func UpdateContainer(containerId string, patch *subsystems.ResourceConfig) error {
+	containerInfo, err := container.ModifyContainerInfo(containerId, func(info *container.Info) error {
+		if info.Status != container.RUNNING && info.Status != container.PAUSED {
+			return fmt.Errorf("container %s is not running, status: %s", containerId, info.Status)
+		}
+		if info.CgroupPath == "" {
+			return fmt.Errorf("container %s has no cgroup", containerId)
+		}
+
+		res := info.Resource.Merge(patch)
+		if err := res.Validate(); err != nil {
+			return err
+		}
+
+		cgroupManager := cgroups.NewCgroupManager(info.CgroupPath)
+		// 内存限制不能比当前已经使用的内存还小，否则内核会尝试回收内存，回收不了就会触发 OOM
+		if patch.MemoryLimit != "" {
+			if err := checkMemoryUsage(cgroupManager, res); err != nil {
+				return err
+			}
+		}
+		// 设备访问规则只在创建容器时设置，v1 中重新写入规则时会先禁止访问所有设备，导致容器短暂地无法访问设备
+		setRes := *res
+		setRes.Devices = nil
+		if err := cgroupManager.Set(&setRes); err != nil {
+			return errors.WithMessagef(err, "update container %s resource", containerId)
+		}
+		info.Resource = res
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("update container %s resource: %+v", containerId, containerInfo.Resource)
+	return nil
+}
+
+// checkMemoryUsage 校验新的内存限制不小于容器当前的内存使用量
+func checkMemoryUsage(cgroupManager *cgroups.CgroupManager, res *subsystems.ResourceConfig) error {
+	limit, err := res.MemoryLimitInBytes()
+	if err != nil {
+		return err
+	}
+	stats, err := cgroupManager.GetStats()
+	if err != nil {
+		return errors.WithMessage(err, "get memory usage")
+	}
+	if uint64(limit) < stats.MemoryUsage {
+		return fmt.Errorf("memory limit %s(%d bytes) is less than current usage %d bytes",
+			res.MemoryLimit, limit, stats.MemoryUsage)
+	}
+	return nil
+}
@@ -1,6 +1,11 @@
 package utils
 
-import "os"
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
 
 func PathExists(path string) (bool, error) {
 	_, err := os.Stat(path)
@@ -12,3 +17,21 @@ func PathExists(path string) (bool, error) {
 	}
 	return false, err
 }
+
+// IsMountPoint 判断 path 是否是挂载点，/proc/self/mountinfo 的第 5 列为挂载点路径
+func IsMountPoint(path string) (bool, error) {
+	path = filepath.Clean(path)
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && fields[4] == path {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
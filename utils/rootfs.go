@@ -2,14 +2,15 @@ package utils
 
 import "fmt"
 
-const (
-	ImagePath       = "/var/lib/mydocker/image/"
-	RootPath        = "/var/lib/mydocker/overlay2/"
+const overlayFSFormat = "lowerdir=%s,upperdir=%s,workdir=%s"
+
+var (
+	ImagePath       = DataRoot + "/image/"
+	RootPath        = DataRoot + "/overlay2/"
 	lowerDirFormat  = RootPath + "%s/lower"
 	upperDirFormat  = RootPath + "%s/upper"
 	workDirFormat   = RootPath + "%s/work"
 	mergedDirFormat = RootPath + "%s/merged"
-	overlayFSFormat = "lowerdir=%s,upperdir=%s,workdir=%s"
 )
 
 func GetImage(imageName string) string {
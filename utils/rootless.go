@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"os"
+	"path"
+)
+
+// rootDataRoot 以 root 身份运行时保存镜像、容器和网络等数据的根目录
+const rootDataRoot = "/var/lib/mydocker"
+
+// DataRoot mydocker 保存数据的根目录，rootless 模式下保存在当前用户的数据目录中
+var DataRoot = dataRoot()
+
+// IsRootless 是否以普通用户身份运行，此时容器运行在 user namespace 中，容器内的 root 对应宿主机上的当前用户
+func IsRootless() bool {
+	return os.Geteuid() != 0
+}
+
+// dataRoot rootless 模式下遵循 XDG 规范，优先使用 $XDG_DATA_HOME/mydocker，否则使用 ~/.local/share/mydocker
+func dataRoot() string {
+	if !IsRootless() {
+		return rootDataRoot
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return path.Join(dataHome, "mydocker")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path.Join(os.TempDir(), "mydocker-"+os.Getenv("USER"))
+	}
+	return path.Join(home, ".local", "share", "mydocker")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"mydocker/constant"
+	"mydocker/container"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// waitLockFile wait 命令在等待期间持有该文件的共享锁，删除容器前需要先获取排他锁，保证 wait 命令可以读到 --rm 容器的退出码
+const waitLockFile = "wait.lock"
+
+// WaitContainers 依次等待容器退出，并按顺序打印容器的退出码
+func WaitContainers(containerIds []string) error {
+	for _, containerId := range containerIds {
+		exitCode, err := waitExitCode(containerId)
+		if err != nil {
+			return err
+		}
+		fmt.Println(exitCode)
+	}
+	return nil
+}
+
+// waitExitCode 阻塞直到容器退出，返回容器的退出码
+func waitExitCode(containerId string) (int, error) {
+	unlock, err := lockContainerWait(containerId, unix.LOCK_SH)
+	if err != nil {
+		return 0, errors.WithMessagef(err, "no such container %s", containerId)
+	}
+	defer unlock()
+	for {
+		containerInfo, err := container.GetInfoByContainerId(containerId)
+		if err != nil {
+			return 0, errors.WithMessagef(err, "get container %s info", containerId)
+		}
+		// monitor 进程异常退出时没有进程负责更新容器状态，这里与 ps 一样检查容器进程是否还存在
+		refreshContainerStatus(containerInfo)
+		if containerInfo.Status == container.Exit || containerInfo.Status == container.STOP {
+			return containerInfo.ExitCode, nil
+		}
+		time.Sleep(stopPollInterval)
+	}
+}
+
+// lockContainerWait 对容器的 wait 锁文件加锁，how 为 unix.LOCK_SH 或者 unix.LOCK_EX，返回解锁的函数
+func lockContainerWait(containerId string, how int) (func(), error) {
+	lockPath := path.Join(container.GetConfigDirPath(containerId), waitLockFile)
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDONLY, constant.Perm0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", lockPath)
+	}
+	if err = unix.Flock(int(file.Fd()), how); err != nil {
+		_ = file.Close()
+		return nil, errors.Wrapf(err, "lock %s", lockPath)
+	}
+	return func() {
+		_ = unix.Flock(int(file.Fd()), unix.LOCK_UN)
+		_ = file.Close()
+	}, nil
+}